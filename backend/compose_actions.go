@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Labels docker compose records where a project was started from
+const (
+	composeWorkingDirLabel  = "com.docker.compose.project.working_dir"
+	composeConfigFilesLabel = "com.docker.compose.project.config_files"
+	composeServiceLabel     = "com.docker.compose.service"
+)
+
+// Request for a compose project action
+type ComposeActionRequest struct {
+	Hostname       string   `json:"hostname"`
+	Username       string   `json:"username"`
+	ComposeProject string   `json:"composeProject"`
+	Action         string   `json:"action"`   // up, down, restart, stop, start, pull or scale
+	Services       []string `json:"services"` // limit the action to these services, not used by down
+	Build          bool     `json:"build"`    // up: build images before starting
+	Pull           bool     `json:"pull"`     // up: always pull images before starting
+	Volumes        bool     `json:"volumes"`  // down: remove named volumes
+	RemoveOrphans  bool     `json:"removeOrphans"`
+	Service        string   `json:"service"`  // scale: service to scale
+	Replicas       int      `json:"replicas"` // scale: desired number of containers
+	// Used when the project has no containers left to read the labels from,
+	// e.g. bringing it up again after down
+	WorkingDir  string   `json:"workingDir"`
+	ConfigFiles []string `json:"configFiles"`
+}
+
+// Where a compose project lives on the remote host
+type ComposeProjectLocation struct {
+	WorkingDir  string   `json:"workingDir"`
+	ConfigFiles []string `json:"configFiles"`
+}
+
+// Event streamed while a compose action runs
+type ComposeActionEvent struct {
+	Type     string                  `json:"type"` // start, output, done or error
+	Line     string                  `json:"line,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+	Location *ComposeProjectLocation `json:"location,omitempty"`
+}
+
+// Run a lifecycle action on a compose project, streaming its output
+func runComposeAction(ctx echo.Context) error {
+	var req ComposeActionRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.ComposeProject == "" || req.Action == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateComposeProjectName(req.ComposeProject); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid compose project: %v", err)})
+	}
+
+	actionArgs, err := buildComposeActionArgs(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	location, output, err := resolveComposeProject(req.Username, req.Hostname, req.ComposeProject)
+	if err != nil {
+		logger.Errorf("Error resolving compose project: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to resolve compose project: %v", err),
+			"output": string(output),
+		})
+	}
+	if location == nil {
+		if req.WorkingDir == "" {
+			return ctx.JSON(http.StatusNotFound, map[string]string{
+				"error": "Project has no containers to read its location from, provide workingDir and configFiles",
+			})
+		}
+		location, err = cleanComposeLocation(req.WorkingDir, req.ConfigFiles)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	args := append(composeBaseArgs(req.ComposeProject, *location), actionArgs...)
+	// compose reports progress on stderr
+	command := utils.BuildDockerCommand(args...) + " 2>&1"
+	logger.Infof("Executing compose command: %s", command)
+
+	stream := newEventStream(ctx)
+	if err := stream.Send(ComposeActionEvent{Type: "start", Location: location}); err != nil {
+		return nil
+	}
+
+	lines := newLineWriter(func(line string) error {
+		return stream.Send(ComposeActionEvent{Type: "output", Line: line})
+	})
+	err = tunnelManager.StreamCommand(ctx.Request().Context(), req.Username, req.Hostname, command, nil, lines)
+	if flushErr := lines.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		logger.Errorf("Compose %s for %s failed: %v", req.Action, req.ComposeProject, err)
+		stream.Send(ComposeActionEvent{Type: "error", Error: fmt.Sprintf("Compose %s failed: %v", req.Action, err)})
+		return nil
+	}
+	stream.Send(ComposeActionEvent{Type: "done"})
+	return nil
+}
+
+// resolveComposeProject reads the working directory and config files from the
+// labels of the project's containers. Returns nil if the project has no containers.
+func resolveComposeProject(username, hostname, project string) (*ComposeProjectLocation, []byte, error) {
+	format := fmt.Sprintf(`{{.Label "%s"}}|{{.Label "%s"}}`, composeWorkingDirLabel, composeConfigFilesLabel)
+	dockerCommand := utils.BuildDockerCommand("ps", "-a", "--filter", "label="+composeProjectLabel+"="+project, "--format", format)
+	output, err := tunnelManager.ExecuteCommand(username, hostname, dockerCommand)
+	if err != nil {
+		return nil, output, err
+	}
+	return parseComposeLocation(string(output)), output, nil
+}
+
+// parseComposeLocation reads the location from the first container listed
+// with a working directory label
+func parseComposeLocation(output string) *ComposeProjectLocation {
+	for _, line := range strings.Split(string(output), "\n") {
+		workingDir, configFiles, _ := strings.Cut(strings.TrimSpace(line), "|")
+		if workingDir == "" {
+			continue
+		}
+		location := &ComposeProjectLocation{WorkingDir: workingDir, ConfigFiles: []string{}}
+		for _, file := range strings.Split(configFiles, ",") {
+			if file = strings.TrimSpace(file); file != "" {
+				location.ConfigFiles = append(location.ConfigFiles, file)
+			}
+		}
+		return location
+	}
+	return nil
+}
+
+// cleanComposeLocation validates a client-supplied project location. Paths
+// must be absolute since they end up as --project-directory and -f flags.
+func cleanComposeLocation(workingDir string, configFiles []string) (*ComposeProjectLocation, error) {
+	dir, err := utils.CleanContainerPath(workingDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workingDir: %v", err)
+	}
+	location := &ComposeProjectLocation{WorkingDir: dir, ConfigFiles: []string{}}
+	for _, file := range configFiles {
+		cleaned, err := utils.CleanContainerPath(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %q: %v", file, err)
+		}
+		location.ConfigFiles = append(location.ConfigFiles, cleaned)
+	}
+	return location, nil
+}
+
+// composeBaseArgs returns `compose` with the project, directory and files set
+func composeBaseArgs(project string, location ComposeProjectLocation) []string {
+	args := []string{"compose", "--ansi", "never", "-p", project, "--project-directory", location.WorkingDir}
+	for _, file := range location.ConfigFiles {
+		args = append(args, "-f", file)
+	}
+	return args
+}
+
+// buildComposeActionArgs validates the action and its options
+func buildComposeActionArgs(req ComposeActionRequest) ([]string, error) {
+	for _, service := range req.Services {
+		if err := utils.ValidateComposeServiceName(service); err != nil {
+			return nil, fmt.Errorf("invalid service %q: %v", service, err)
+		}
+	}
+
+	var args []string
+	switch req.Action {
+	case "up":
+		args = []string{"up", "-d"}
+		if req.Build {
+			args = append(args, "--build")
+		}
+		if req.Pull {
+			args = append(args, "--pull", "always")
+		}
+		if req.RemoveOrphans {
+			args = append(args, "--remove-orphans")
+		}
+	case "down":
+		if len(req.Services) > 0 {
+			return nil, fmt.Errorf("down applies to the whole project, use stop for single services")
+		}
+		args = []string{"down"}
+		if req.Volumes {
+			args = append(args, "--volumes")
+		}
+		if req.RemoveOrphans {
+			args = append(args, "--remove-orphans")
+		}
+	case "restart", "stop", "start", "pull":
+		args = []string{req.Action}
+	case "scale":
+		if err := utils.ValidateComposeServiceName(req.Service); err != nil {
+			return nil, fmt.Errorf("invalid service: %v", err)
+		}
+		if req.Replicas < 0 || req.Replicas > 100 {
+			return nil, fmt.Errorf("replicas must be between 0 and 100")
+		}
+		// up --scale works on all compose v2 releases, unlike the scale command
+		return []string{"up", "-d", "--no-deps", "--no-recreate", "--scale", req.Service + "=" + strconv.Itoa(req.Replicas), req.Service}, nil
+	default:
+		return nil, fmt.Errorf("unknown action: %s", req.Action)
+	}
+	return append(args, req.Services...), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildComposeActionArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ComposeActionRequest
+		want []string
+	}{
+		{"up with options", ComposeActionRequest{Action: "up", Build: true, Pull: true, RemoveOrphans: true, Services: []string{"web"}},
+			[]string{"up", "-d", "--build", "--pull", "always", "--remove-orphans", "web"}},
+		{"down with volumes", ComposeActionRequest{Action: "down", Volumes: true}, []string{"down", "--volumes"}},
+		{"restart services", ComposeActionRequest{Action: "restart", Services: []string{"web", "worker"}}, []string{"restart", "web", "worker"}},
+		{"scale", ComposeActionRequest{Action: "scale", Service: "worker", Replicas: 3, Services: []string{"ignored"}},
+			[]string{"up", "-d", "--no-deps", "--no-recreate", "--scale", "worker=3", "worker"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildComposeActionArgs(tt.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	invalid := []ComposeActionRequest{
+		{Action: "exec"},
+		{Action: "down", Services: []string{"web"}},
+		{Action: "up", Services: []string{"--detach"}},
+		{Action: "scale", Service: "worker", Replicas: 101},
+		{Action: "scale", Service: "worker", Replicas: -1},
+		{Action: "scale"},
+	}
+	for _, req := range invalid {
+		if args, err := buildComposeActionArgs(req); err == nil {
+			t.Errorf("%+v: expected an error, got %v", req, args)
+		}
+	}
+}
+
+func TestParseComposeLocation(t *testing.T) {
+	output := "|\n/srv/app|/srv/app/compose.yml, /srv/app/compose.prod.yml\n/srv/other|/srv/other/compose.yml\n"
+	want := &ComposeProjectLocation{WorkingDir: "/srv/app", ConfigFiles: []string{"/srv/app/compose.yml", "/srv/app/compose.prod.yml"}}
+	if got := parseComposeLocation(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := parseComposeLocation("|\n\n"); got != nil {
+		t.Errorf("expected no location for a project without labels, got %+v", got)
+	}
+}
+
+func TestCleanComposeLocation(t *testing.T) {
+	got, err := cleanComposeLocation("/srv/app/", []string{"/srv/app//compose.yml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &ComposeProjectLocation{WorkingDir: "/srv/app", ConfigFiles: []string{"/srv/app/compose.yml"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		workingDir  string
+		configFiles []string
+		errContains string
+	}{
+		{"srv/app", nil, "workingDir"},
+		{"/srv/../etc", nil, "workingDir"},
+		{"/srv/app", []string{"compose.yml"}, "config file"},
+		{"/srv/app", []string{"--file=/etc/passwd"}, "config file"},
+		{"/srv/app", []string{"/srv/app/../../etc/compose.yml"}, "config file"},
+	}
+	for _, tt := range tests {
+		_, err := cleanComposeLocation(tt.workingDir, tt.configFiles)
+		if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+			t.Errorf("%s %v: expected a %s error, got %v", tt.workingDir, tt.configFiles, tt.errContains, err)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+const (
+	// Largest uncompressed project archive accepted for a deploy
+	maxDeployArchiveSize int64 = 100 << 20
+	// Manifest of the deployed files, stored inside the project directory
+	deployManifestName = ".deploy-manifest.json"
+)
+
+// Compose file names looked up when the request does not name one
+var defaultComposeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Files of a deployed revision
+type deployManifest struct {
+	Project     string            `json:"project"`
+	ComposeFile string            `json:"composeFile"`
+	Revision    int               `json:"revision"`
+	DeployedAt  string            `json:"deployedAt"`
+	Files       map[string]string `json:"files"` // relative path -> deployFileHash
+}
+
+// File of an uploaded project archive
+type deployFile struct {
+	Data []byte
+	Mode int64 // permission bits from the tar header
+}
+
+// State of a compose service after a deploy
+type ComposeServiceResult struct {
+	Service   string `json:"service"`
+	Replicas  int    `json:"replicas"`
+	Running   int    `json:"running"`
+	Unhealthy int    `json:"unhealthy"`
+	State     string `json:"state"` // running, unhealthy, partial or stopped
+}
+
+// Result of a deploy or rollback
+type ComposeDeployResponse struct {
+	Success    string                 `json:"success"`
+	Project    string                 `json:"project"`
+	Revision   int                    `json:"revision"`
+	WorkingDir string                 `json:"workingDir"`
+	Uploaded   []string               `json:"uploaded"`
+	Deleted    []string               `json:"deleted"`
+	Unchanged  int                    `json:"unchanged"`
+	Services   []ComposeServiceResult `json:"services"`
+	Output     string                 `json:"output"`
+}
+
+// Request to roll a deployed project back to its previous revision
+type ComposeRollbackRequest struct {
+	Hostname    string `json:"hostname"`
+	Username    string `json:"username"`
+	ProjectName string `json:"projectName"`
+}
+
+// One container from `docker compose ps --format json`
+type composePSEntry struct {
+	Name    string `json:"Name"`
+	Service string `json:"Service"`
+	State   string `json:"State"`
+	Health  string `json:"Health"`
+}
+
+// Deploy a compose project archive (tar or tar.gz) from the local machine
+func deployComposeProject(ctx echo.Context) error {
+	ctx.Request().Body = http.MaxBytesReader(ctx.Response(), ctx.Request().Body, maxDeployArchiveSize+1<<20)
+
+	hostname := ctx.FormValue("hostname")
+	username := ctx.FormValue("username")
+	project := ctx.FormValue("projectName")
+	composeFile := ctx.FormValue("composeFile")
+	forceRecreate := ctx.FormValue("forceRecreate") == "true"
+
+	if hostname == "" || username == "" || project == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(username, hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := validateDeployProjectName(project); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	fileHeader, err := ctx.FormFile("archive")
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing archive"})
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read uploaded archive"})
+	}
+	defer src.Close()
+
+	files, err := readDeployArchive(src)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid archive: %v", err)})
+	}
+	if composeFile == "" {
+		for _, name := range defaultComposeFiles {
+			if _, ok := files[name]; ok {
+				composeFile = name
+				break
+			}
+		}
+	}
+	if _, ok := files[composeFile]; !ok || composeFile == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Archive does not contain the compose file"})
+	}
+
+	previous, output, err := readDeployManifest(username, hostname, project, "current")
+	if err != nil {
+		logger.Errorf("Error reading deploy manifest: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to read deployed revision: %v", err),
+			"output": string(output),
+		})
+	}
+
+	manifest := deployManifest{
+		Project:     project,
+		ComposeFile: composeFile,
+		Revision:    1,
+		DeployedAt:  time.Now().Format(time.RFC3339),
+		Files:       make(map[string]string, len(files)),
+	}
+	if previous != nil {
+		manifest.Revision = previous.Revision + 1
+	}
+	response := ComposeDeployResponse{
+		Project:    project,
+		Revision:   manifest.Revision,
+		WorkingDir: "~/.remote-docker/projects/" + project + "/current",
+		Uploaded:   []string{},
+		Deleted:    []string{},
+	}
+
+	var changed []string
+	for _, name := range sortedKeys(files) {
+		manifest.Files[name] = deployFileHash(files[name])
+		if previous == nil || previous.Files[name] != manifest.Files[name] {
+			changed = append(changed, name)
+		} else {
+			response.Unchanged++
+		}
+	}
+	if previous != nil {
+		for _, name := range sortedKeys(previous.Files) {
+			if _, ok := files[name]; !ok {
+				response.Deleted = append(response.Deleted, name)
+			}
+		}
+	}
+	response.Uploaded = append(response.Uploaded, changed...)
+
+	archive, err := buildDeployTar(files, changed, manifest)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to build archive: %v", err)})
+	}
+
+	// Keep the deployed revision as previous, then update the working
+	// directory in place so bind mounts of unchanged files stay valid
+	base := deployDir(project)
+	steps := []string{
+		"mkdir -p " + base + "/current",
+		"rm -rf " + base + "/previous",
+		"cp -a " + base + "/current " + base + "/previous",
+		"cd " + base + "/current",
+		// -p applies the archived modes as is instead of masking them with the umask
+		"tar -xpf -",
+	}
+	if len(response.Deleted) > 0 {
+		steps = append(steps, "rm -f -- "+shellEscapeAll(response.Deleted))
+	}
+	syncCommand := strings.Join(steps, " && ")
+	logger.Infof("Syncing %d changed files of %s to %s", len(changed), project, hostname)
+
+	if err := tunnelManager.StreamCommand(ctx.Request().Context(), username, hostname, syncCommand, bytes.NewReader(archive), io.Discard); err != nil {
+		logger.Errorf("Error syncing project %s: %v", project, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to sync project files: %v", err),
+		})
+	}
+
+	return composeUpDeployed(ctx, username, hostname, manifest, forceRecreate, response)
+}
+
+// Roll a deployed project back to the previous revision and bring it up
+func rollbackComposeDeploy(ctx echo.Context) error {
+	var req ComposeRollbackRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.ProjectName == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := validateDeployProjectName(req.ProjectName); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	current, output, err := readDeployManifest(req.Username, req.Hostname, req.ProjectName, "current")
+	if err != nil {
+		logger.Errorf("Error reading deploy manifest: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to read deployed revision: %v", err),
+			"output": string(output),
+		})
+	}
+	if current == nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Project has not been deployed"})
+	}
+	previous, output, err := readDeployManifest(req.Username, req.Hostname, req.ProjectName, "previous")
+	if err != nil {
+		logger.Errorf("Error reading deploy manifest: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to read previous revision: %v", err),
+			"output": string(output),
+		})
+	}
+	if previous == nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "No previous revision to roll back to"})
+	}
+
+	// Restore in place like a deploy does. The rolled back revision becomes
+	// previous, so a second rollback undoes the first.
+	base := deployDir(req.ProjectName)
+	steps := []string{
+		"rm -rf " + base + "/swap",
+		"cp -a " + base + "/current " + base + "/swap",
+	}
+	removed := []string{}
+	for _, name := range sortedKeys(current.Files) {
+		if _, ok := previous.Files[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) > 0 {
+		steps = append(steps, "(cd "+base+"/current && rm -f -- "+shellEscapeAll(removed)+")")
+	}
+	steps = append(steps,
+		"cp -a "+base+"/previous/. "+base+"/current/",
+		"rm -rf "+base+"/previous",
+		"mv "+base+"/swap "+base+"/previous",
+	)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, strings.Join(steps, " && "))
+	if err != nil {
+		logger.Errorf("Error rolling back project %s: %v, output: %s", req.ProjectName, err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to restore previous revision: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return composeUpDeployed(ctx, req.Username, req.Hostname, *previous, false, ComposeDeployResponse{
+		Project:    req.ProjectName,
+		Revision:   previous.Revision,
+		WorkingDir: "~/.remote-docker/projects/" + req.ProjectName + "/current",
+		Uploaded:   []string{},
+		Deleted:    removed,
+	})
+}
+
+// composeUpDeployed runs `docker compose up -d` for a deployed revision and
+// responds with the resulting service states
+func composeUpDeployed(ctx echo.Context, username, hostname string, manifest deployManifest, forceRecreate bool, response ComposeDeployResponse) error {
+	// Run from the managed directory so compose records its absolute path
+	location := ComposeProjectLocation{WorkingDir: ".", ConfigFiles: []string{manifest.ComposeFile}}
+	args := append(composeBaseArgs(manifest.Project, location), "up", "-d", "--remove-orphans")
+	if forceRecreate {
+		args = append(args, "--force-recreate")
+	}
+	upCommand := "cd " + deployDir(manifest.Project) + "/current && " + utils.BuildDockerCommand(args...) + " 2>&1"
+	logger.Infof("Executing compose command: %s", upCommand)
+
+	output, upErr := tunnelManager.ExecuteCommand(username, hostname, upCommand)
+	response.Output = string(output)
+
+	services, psOutput, err := composeServiceResults(username, hostname, manifest.Project)
+	if err != nil {
+		logger.Warnf("Error reading service states: %v, output: %s", err, string(psOutput))
+	}
+	response.Services = services
+
+	if upErr != nil {
+		logger.Errorf("Error starting project %s: %v, output: %s", manifest.Project, upErr, string(output))
+		response.Success = "false"
+		return ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error":  fmt.Sprintf("Files synced but compose up failed: %v", upErr),
+			"output": string(output),
+			"result": response,
+		})
+	}
+	response.Success = "true"
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// composeServiceResults summarizes the containers of a project per service
+func composeServiceResults(username, hostname, project string) ([]ComposeServiceResult, []byte, error) {
+	dockerCommand := utils.BuildDockerCommand("compose", "-p", project, "ps", "-a", "--format", "json")
+	output, err := tunnelManager.ExecuteCommand(username, hostname, dockerCommand)
+	if err != nil {
+		return []ComposeServiceResult{}, output, err
+	}
+	entries, err := parseComposePS(output)
+	if err != nil {
+		return []ComposeServiceResult{}, output, err
+	}
+
+	byService := make(map[string]*ComposeServiceResult)
+	for _, entry := range entries {
+		result, ok := byService[entry.Service]
+		if !ok {
+			result = &ComposeServiceResult{Service: entry.Service}
+			byService[entry.Service] = result
+		}
+		result.Replicas++
+		if entry.State == "running" {
+			result.Running++
+		}
+		if entry.Health == "unhealthy" {
+			result.Unhealthy++
+		}
+	}
+
+	results := make([]ComposeServiceResult, 0, len(byService))
+	for _, name := range sortedKeys(byService) {
+		result := *byService[name]
+		switch {
+		case result.Unhealthy > 0:
+			result.State = "unhealthy"
+		case result.Running == result.Replicas:
+			result.State = "running"
+		case result.Running == 0:
+			result.State = "stopped"
+		default:
+			result.State = "partial"
+		}
+		results = append(results, result)
+	}
+	return results, output, nil
+}
+
+// parseComposePS accepts both output styles of `docker compose ps --format json`:
+// a single JSON array (older releases) or one object per line
+func parseComposePS(output []byte) ([]composePSEntry, error) {
+	trimmed := bytes.TrimSpace(output)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	var entries []composePSEntry
+	if trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse compose ps output: %v", err)
+		}
+		return entries, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry composePSEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse compose ps output: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// readDeployManifest reads the manifest of the current or previous revision.
+// Returns nil if the revision does not exist.
+func readDeployManifest(username, hostname, project, revision string) (*deployManifest, []byte, error) {
+	command := fmt.Sprintf("cat %s/%s/%s 2>/dev/null || true", deployDir(project), revision, deployManifestName)
+	output, err := tunnelManager.ExecuteCommand(username, hostname, command)
+	if err != nil {
+		return nil, output, err
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, output, nil
+	}
+	var manifest deployManifest
+	if err := json.Unmarshal(output, &manifest); err != nil {
+		return nil, output, fmt.Errorf("failed to parse deploy manifest: %v", err)
+	}
+	return &manifest, output, nil
+}
+
+// deployFileHash hashes the mode along with the content, so a chmod alone
+// gets the file redeployed
+func deployFileHash(file deployFile) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%04o\n", file.Mode)
+	h.Write(file.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readDeployArchive reads all regular files of a tar or tar.gz archive.
+// Links, absolute paths and paths leaving the project are rejected.
+func readDeployArchive(r io.Reader) (map[string]deployFile, error) {
+	br := bufio.NewReader(r)
+	var reader io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	files := make(map[string]deployFile)
+	var total int64
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean(strings.TrimPrefix(header.Name, "./")), "./")
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, fmt.Errorf("%s: only regular files are supported", header.Name)
+		}
+		if name == "." || strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s: path must stay inside the project", header.Name)
+		}
+		if name == deployManifestName {
+			return nil, fmt.Errorf("%s is reserved", deployManifestName)
+		}
+
+		total += header.Size
+		if total > maxDeployArchiveSize {
+			return nil, utils.ErrSizeLimitExceeded
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return nil, err
+		}
+		// Archivers that record no permissions would leave the file unreadable
+		mode := header.Mode & 0777
+		if mode == 0 {
+			mode = 0644
+		}
+		files[name] = deployFile{Data: data, Mode: mode}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("archive is empty")
+	}
+	return files, nil
+}
+
+// buildDeployTar packs the changed files and the new manifest
+func buildDeployTar(files map[string]deployFile, changed []string, manifest deployManifest) ([]byte, error) {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	dirs := make(map[string]bool)
+	write := func(name string, file deployFile) error {
+		// tar creates missing parents as root owned 0755 anyway, explicit
+		// entries keep the permissions predictable
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755, ModTime: now}); err != nil {
+				return err
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: file.Mode, Size: int64(len(file.Data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(file.Data)
+		return err
+	}
+
+	sort.Strings(changed)
+	for _, name := range changed {
+		if err := write(name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := write(deployManifestName, deployFile{Data: manifestData, Mode: 0644}); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// validateDeployProjectName checks a project name, compose requires lowercase names
+func validateDeployProjectName(project string) error {
+	if err := utils.ValidateComposeProjectName(project); err != nil {
+		return fmt.Errorf("Invalid project name: %v", err)
+	}
+	if strings.ToLower(project) != project {
+		return fmt.Errorf("Invalid project name: must be lowercase")
+	}
+	return nil
+}
+
+// deployDir is the managed directory of a project as a shell word. The
+// project name is validated, so only $HOME needs the shell.
+func deployDir(project string) string {
+	return `"$HOME"/.remote-docker/projects/` + project
+}
+
+func shellEscapeAll(args []string) string {
+	escaped := make([]string, len(args))
+	for i, arg := range args {
+		escaped[i] = utils.ShellEscape(arg)
+	}
+	return strings.Join(escaped, " ")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func deployTestArchive(t *testing.T, entries map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range entries {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	return &buf
+}
+
+func TestReadDeployArchive(t *testing.T) {
+	files, err := readDeployArchive(deployTestArchive(t, map[string]string{
+		"./compose.yaml":  "services: {}",
+		"config/app.conf": "x=1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(files["compose.yaml"].Data) != "services: {}" || string(files["config/app.conf"].Data) != "x=1" {
+		t.Errorf("unexpected files: %v", files)
+	}
+
+	for _, name := range []string{"../escape", "/etc/passwd", deployManifestName} {
+		if _, err := readDeployArchive(deployTestArchive(t, map[string]string{name: "x"})); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestDeployFileModes(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, mode := range map[string]int64{"entrypoint.sh": 04755, "secrets.env": 0600, "legacy.txt": 0} {
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: 1})
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+
+	files, err := readDeployArchive(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int64{"entrypoint.sh": 0755, "secrets.env": 0600, "legacy.txt": 0644} {
+		if files[name].Mode != want {
+			t.Errorf("%s: mode %04o, want %04o", name, files[name].Mode, want)
+		}
+	}
+
+	// A mode-only change must show up in the manifest
+	chmodded := files["secrets.env"]
+	chmodded.Mode = 0640
+	if deployFileHash(chmodded) == deployFileHash(files["secrets.env"]) {
+		t.Error("mode change did not change the file hash")
+	}
+
+	archive, err := buildDeployTar(files, []string{"entrypoint.sh", "secrets.env"}, deployManifest{Project: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := map[string]int64{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		modes[header.Name] = header.Mode
+	}
+	want := map[string]int64{"entrypoint.sh": 0755, "secrets.env": 0600, deployManifestName: 0644}
+	if !reflect.DeepEqual(modes, want) {
+		t.Errorf("archive modes = %v, want %v", modes, want)
+	}
+}
+
+func TestParseComposePS(t *testing.T) {
+	array := `[{"Name":"p-web-1","Service":"web","State":"running","Health":"healthy"}]`
+	lines := "{\"Name\":\"p-web-1\",\"Service\":\"web\",\"State\":\"running\"}\n{\"Name\":\"p-db-1\",\"Service\":\"db\",\"State\":\"exited\"}\n"
+
+	entries, err := parseComposePS([]byte(array))
+	if err != nil || len(entries) != 1 || entries[0].Health != "healthy" {
+		t.Errorf("array format: %v, %v", entries, err)
+	}
+	entries, err = parseComposePS([]byte(lines))
+	if err != nil || len(entries) != 2 || entries[1].Service != "db" {
+		t.Errorf("line format: %v, %v", entries, err)
+	}
+}
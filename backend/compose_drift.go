@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Label compose puts on containers of `docker compose run`
+const composeOneoffLabel = "com.docker.compose.oneoff"
+
+// Service drift states
+const (
+	driftInSync  = "in-sync"
+	driftDrifted = "drifted"
+	driftMissing = "missing" // in the compose file but no containers
+	driftExtra   = "extra"   // containers for a service the compose file no longer has
+)
+
+// Request for the drift report of a compose project
+type ComposeDriftRequest struct {
+	Hostname       string `json:"hostname"`
+	Username       string `json:"username"`
+	ComposeProject string `json:"composeProject"`
+}
+
+// A single difference between the compose file and a running container
+type DriftItem struct {
+	Field     string `json:"field"` // image, imageDigest, env, ports, mounts or replicas
+	Container string `json:"container,omitempty"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+}
+
+// Drift of one service
+type ServiceDrift struct {
+	Service     string      `json:"service"`
+	Status      string      `json:"status"`
+	Differences []DriftItem `json:"differences"`
+}
+
+// Drift report of a compose project
+type ComposeDriftReport struct {
+	Project    string         `json:"project"`
+	WorkingDir string         `json:"workingDir"`
+	Drifted    bool           `json:"drifted"`
+	Services   []ServiceDrift `json:"services"`
+}
+
+// Drift summary of one project in the fleet report
+type FleetProjectDrift struct {
+	Project         string   `json:"project"`
+	Drifted         bool     `json:"drifted"`
+	DriftedServices []string `json:"driftedServices"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// Drift summary of one environment in the fleet report
+type FleetEnvironmentDrift struct {
+	EnvironmentID string              `json:"environmentId"`
+	Name          string              `json:"name"`
+	Hostname      string              `json:"hostname"`
+	Projects      []FleetProjectDrift `json:"projects"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// `docker compose config --format json`, only the compared fields are mapped
+type composeConfigJSON struct {
+	Services map[string]composeServiceConfig `json:"services"`
+	Volumes  map[string]struct {
+		Name string `json:"name"`
+	} `json:"volumes"`
+}
+
+type composeServiceConfig struct {
+	Image       string             `json:"image"`
+	Environment map[string]*string `json:"environment"`
+	Ports       []struct {
+		Target    int             `json:"target"`
+		Published json.RawMessage `json:"published"` // a number in older releases, a string in newer ones
+		Protocol  string          `json:"protocol"`
+		HostIP    string          `json:"host_ip"`
+	} `json:"ports"`
+	Volumes []struct {
+		Type     string `json:"type"`
+		Source   string `json:"source"`
+		Target   string `json:"target"`
+		ReadOnly bool   `json:"read_only"`
+	} `json:"volumes"`
+	Scale  *int `json:"scale"`
+	Deploy *struct {
+		Replicas *int `json:"replicas"`
+	} `json:"deploy"`
+}
+
+// Compare a compose project's files with its running containers
+func getComposeDrift(ctx echo.Context) error {
+	var req ComposeDriftRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.ComposeProject == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateComposeProjectName(req.ComposeProject); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid compose project: %v", err)})
+	}
+
+	report, output, err := composeDriftReport(req.Username, req.Hostname, req.ComposeProject)
+	if err != nil {
+		logger.Errorf("Error computing drift for %s: %v, output: %s", req.ComposeProject, err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to compute drift: %v", err),
+			"output": string(output),
+		})
+	}
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// Flag drifted compose projects across all saved environments
+func getFleetComposeDrift(ctx echo.Context) error {
+	environments, err := loadEnvironments()
+	if err != nil {
+		logger.Errorf("Error loading environments: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	results := make([]FleetEnvironmentDrift, len(environments))
+	forEachEnvironment(environments, func(i int, env Environment) {
+		result := FleetEnvironmentDrift{
+			EnvironmentID: env.ID,
+			Name:          env.Name,
+			Hostname:      env.Hostname,
+			Projects:      []FleetProjectDrift{},
+		}
+		defer func() { results[i] = result }()
+
+		format := fmt.Sprintf(`{{.Label "%s"}}`, composeProjectLabel)
+		output, err := tunnelManager.ExecuteCommand(env.Username, env.Hostname, utils.BuildDockerCommand("ps", "-a", "--format", format))
+		if err != nil {
+			result.Error = fmt.Sprintf("Failed to list projects: %v", err)
+			return
+		}
+		seen := make(map[string]bool)
+		for _, project := range strings.Fields(string(output)) {
+			if seen[project] || utils.ValidateComposeProjectName(project) != nil {
+				continue
+			}
+			seen[project] = true
+
+			summary := FleetProjectDrift{Project: project, DriftedServices: []string{}}
+			report, _, err := composeDriftReport(env.Username, env.Hostname, project)
+			if err != nil {
+				summary.Error = err.Error()
+			} else {
+				summary.Drifted = report.Drifted
+				for _, service := range report.Services {
+					if service.Status != driftInSync {
+						summary.DriftedServices = append(summary.DriftedServices, service.Service)
+					}
+				}
+			}
+			result.Projects = append(result.Projects, summary)
+		}
+		sort.Slice(result.Projects, func(a, b int) bool { return result.Projects[a].Project < result.Projects[b].Project })
+	})
+
+	return ctx.JSON(http.StatusOK, results)
+}
+
+// composeDriftReport gathers the resolved compose config, the project's
+// containers and the images involved, and compares them
+func composeDriftReport(username, hostname, project string) (*ComposeDriftReport, []byte, error) {
+	location, output, err := resolveComposeProject(username, hostname, project)
+	if err != nil {
+		return nil, output, err
+	}
+	if location == nil {
+		return nil, output, fmt.Errorf("project %s has no containers", project)
+	}
+
+	configArgs := append(composeBaseArgs(project, *location), "config", "--format", "json")
+	output, err = tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(configArgs...))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to read compose config: %v", err)
+	}
+	var config composeConfigJSON
+	if err := json.Unmarshal(output, &config); err != nil {
+		return nil, output, fmt.Errorf("failed to parse compose config: %v", err)
+	}
+
+	output, err = tunnelManager.ExecuteCommand(username, hostname,
+		utils.BuildDockerCommand("ps", "-a", "-q", "--no-trunc", "--filter", "label="+composeProjectLabel+"="+project))
+	if err != nil {
+		return nil, output, err
+	}
+	inspected, output, err := inspectContainers(username, hostname, strings.Fields(string(output))...)
+	if err != nil {
+		return nil, output, err
+	}
+
+	imageSet := make(map[string]bool)
+	var imageIDs []string
+	for _, raw := range inspected {
+		if !imageSet[raw.Image] {
+			imageSet[raw.Image] = true
+			imageIDs = append(imageIDs, raw.Image)
+		}
+	}
+	images, output, err := inspectImages(username, hostname, imageIDs...)
+	if err != nil {
+		return nil, output, err
+	}
+	imagesByID := make(map[string]imageInspectJSON, len(images))
+	for _, image := range images {
+		imagesByID[image.ID] = image
+	}
+
+	// Current image IDs of the configured references, to tell whether a
+	// newer image was pulled without recreating the container
+	output, err = tunnelManager.ExecuteCommand(username, hostname,
+		utils.BuildDockerCommand("image", "ls", "--no-trunc", "--format", "{{.Repository}}:{{.Tag}}|{{.ID}}"))
+	if err != nil {
+		return nil, output, err
+	}
+	localImages := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		if ref, id, ok := strings.Cut(strings.TrimSpace(line), "|"); ok {
+			localImages[ref] = id
+		}
+	}
+
+	report := &ComposeDriftReport{
+		Project:    project,
+		WorkingDir: location.WorkingDir,
+		Services:   compareComposeDrift(config, inspected, imagesByID, localImages),
+	}
+	for _, service := range report.Services {
+		if service.Status != driftInSync {
+			report.Drifted = true
+		}
+	}
+	return report, nil, nil
+}
+
+// compareComposeDrift diffs every configured service with its containers
+func compareComposeDrift(config composeConfigJSON, containers []containerInspectJSON, images map[string]imageInspectJSON, localImages map[string]string) []ServiceDrift {
+	byService := make(map[string][]containerInspectJSON)
+	for _, raw := range containers {
+		if strings.EqualFold(raw.Config.Labels[composeOneoffLabel], "true") {
+			continue
+		}
+		service := raw.Config.Labels[composeServiceLabel]
+		byService[service] = append(byService[service], raw)
+	}
+
+	names := sortedKeys(config.Services)
+	for _, service := range sortedKeys(byService) {
+		if _, ok := config.Services[service]; !ok {
+			names = append(names, service)
+		}
+	}
+
+	result := make([]ServiceDrift, 0, len(names))
+	for _, name := range names {
+		drift := ServiceDrift{Service: name, Status: driftInSync, Differences: []DriftItem{}}
+		service, configured := config.Services[name]
+		running := byService[name]
+
+		switch {
+		case !configured:
+			drift.Status = driftExtra
+		case len(running) == 0:
+			drift.Status = driftMissing
+		default:
+			expectedReplicas := 1
+			if service.Deploy != nil && service.Deploy.Replicas != nil {
+				expectedReplicas = *service.Deploy.Replicas
+			} else if service.Scale != nil {
+				expectedReplicas = *service.Scale
+			}
+			if len(running) != expectedReplicas {
+				drift.Differences = append(drift.Differences, DriftItem{
+					Field:    "replicas",
+					Expected: strconv.Itoa(expectedReplicas),
+					Actual:   strconv.Itoa(len(running)),
+				})
+			}
+			for _, raw := range running {
+				drift.Differences = append(drift.Differences, diffServiceContainer(config, service, raw, images[raw.Image], localImages)...)
+			}
+			if len(drift.Differences) > 0 {
+				drift.Status = driftDrifted
+			}
+		}
+		result = append(result, drift)
+	}
+	return result
+}
+
+// diffServiceContainer compares one container with its service definition
+func diffServiceContainer(config composeConfigJSON, service composeServiceConfig, raw containerInspectJSON, image imageInspectJSON, localImages map[string]string) []DriftItem {
+	container := strings.TrimPrefix(raw.Name, "/")
+	var items []DriftItem
+	add := func(field, expected, actual string) {
+		items = append(items, DriftItem{Field: field, Container: container, Expected: expected, Actual: actual})
+	}
+
+	if service.Image != "" {
+		if raw.Config.Image != service.Image {
+			add("image", service.Image, raw.Config.Image)
+		} else if id, ok := localImages[normalizeImageRef(service.Image)]; ok && id != raw.Image {
+			add("imageDigest", id, raw.Image)
+		}
+	}
+
+	// Environment, values of secret looking keys are masked
+	actualEnv := make(map[string]string)
+	for _, kv := range raw.Config.Env {
+		key, value, _ := strings.Cut(kv, "=")
+		actualEnv[key] = value
+	}
+	imageEnv := make(map[string]string)
+	for _, kv := range image.Config.Env {
+		key, value, _ := strings.Cut(kv, "=")
+		imageEnv[key] = value
+	}
+	mask := func(key, value string) string {
+		if secretEnvPattern.MatchString(key) {
+			return maskedValue
+		}
+		return value
+	}
+	for _, key := range sortedKeys(service.Environment) {
+		expected := service.Environment[key]
+		actual, ok := actualEnv[key]
+		switch {
+		case !ok:
+			add("env", key+"="+mask(key, valueOrEmpty(expected)), "")
+		case expected != nil && *expected != actual:
+			add("env", key+"="+mask(key, *expected), key+"="+mask(key, actual))
+		}
+	}
+	for _, key := range sortedKeys(actualEnv) {
+		if _, ok := service.Environment[key]; ok {
+			continue
+		}
+		if imageValue, ok := imageEnv[key]; ok && imageValue == actualEnv[key] {
+			continue
+		}
+		add("env", "", key+"="+mask(key, actualEnv[key]))
+	}
+
+	// Ports
+	var expectedPorts []string
+	for _, port := range service.Ports {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		spec := strconv.Itoa(port.Target) + "/" + protocol
+		if published := strings.Trim(string(port.Published), `"`); published != "" && published != "null" && published != "0" {
+			spec = published + ":" + spec
+			if port.HostIP != "" {
+				hostIP := port.HostIP
+				if strings.Contains(hostIP, ":") {
+					hostIP = "[" + hostIP + "]"
+				}
+				spec = hostIP + ":" + spec
+			}
+		}
+		expectedPorts = append(expectedPorts, spec)
+	}
+	if expected, actual := sortedJoin(expectedPorts), sortedJoin(publishSpecs(raw)); expected != actual {
+		add("ports", expected, actual)
+	}
+
+	// Mounts, named volumes are compared by their resolved name
+	var expectedMounts []string
+	expectedTargets := make(map[string]bool)
+	for _, volume := range service.Volumes {
+		expectedTargets[volume.Target] = true
+		source := volume.Source
+		switch volume.Type {
+		case "volume":
+			if top, ok := config.Volumes[source]; ok && top.Name != "" {
+				source = top.Name
+			}
+		case "bind":
+		default:
+			continue
+		}
+		expectedMounts = append(expectedMounts, driftMountSpec(volume.Type, source, volume.Target, volume.ReadOnly))
+	}
+	var actualMounts []string
+	for _, m := range raw.Mounts {
+		switch m.Type {
+		case "volume":
+			source := m.Name
+			if anonymousVolumePattern.MatchString(source) {
+				// Created for a VOLUME of the image, not by the compose file
+				if _, declared := image.Config.Volumes[m.Destination]; declared && !expectedTargets[m.Destination] {
+					continue
+				}
+				source = ""
+			}
+			actualMounts = append(actualMounts, driftMountSpec(m.Type, source, m.Destination, !m.RW))
+		case "bind":
+			actualMounts = append(actualMounts, driftMountSpec(m.Type, m.Source, m.Destination, !m.RW))
+		}
+	}
+	if expected, actual := sortedJoin(expectedMounts), sortedJoin(actualMounts); expected != actual {
+		add("mounts", expected, actual)
+	}
+
+	return items
+}
+
+// normalizeImageRef adds the implicit latest tag so references match `docker image ls`
+func normalizeImageRef(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+func driftMountSpec(mountType, source, target string, readOnly bool) string {
+	spec := mountType + ":" + source + ":" + target
+	if readOnly {
+		spec += ":ro"
+	}
+	return spec
+}
+
+func sortedJoin(items []string) string {
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
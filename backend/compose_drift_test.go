@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCompareComposeDrift(t *testing.T) {
+	var config composeConfigJSON
+	err := json.Unmarshal([]byte(`{
+		"services": {
+			"web": {
+				"image": "nginx:1.25",
+				"environment": {"MODE": "prod", "DB_PASSWORD": "secret"},
+				"ports": [{"target": 80, "published": "8080", "protocol": "tcp"}],
+				"volumes": [{"type": "volume", "source": "data", "target": "/data"}]
+			},
+			"worker": {"image": "worker:1"}
+		},
+		"volumes": {"data": {"name": "app_data"}}
+	}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var web, old containerInspectJSON
+	err = json.Unmarshal([]byte(`{
+		"Name": "/app-web-1",
+		"Image": "sha256:old",
+		"Config": {
+			"Image": "nginx:1.25",
+			"Env": ["MODE=dev", "DB_PASSWORD=other", "DEBUG=1"],
+			"Labels": {"com.docker.compose.service": "web"}
+		},
+		"HostConfig": {"PortBindings": {"80/tcp": [{"HostIp": "", "HostPort": "8080"}]}},
+		"Mounts": [{"Type": "volume", "Name": "app_data", "Destination": "/data", "RW": true}]
+	}`), &web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Name = "/app-cron-1"
+	old.Config.Labels = map[string]string{"com.docker.compose.service": "cron"}
+
+	drift := compareComposeDrift(config, []containerInspectJSON{web, old}, nil, map[string]string{"nginx:1.25": "sha256:new"})
+	if len(drift) != 3 {
+		t.Fatalf("expected 3 services, got %+v", drift)
+	}
+
+	statuses := map[string]string{}
+	for _, d := range drift {
+		statuses[d.Service] = d.Status
+	}
+	if statuses["web"] != driftDrifted || statuses["worker"] != driftMissing || statuses["cron"] != driftExtra {
+		t.Errorf("unexpected statuses: %v", statuses)
+	}
+
+	fields := map[string]int{}
+	for _, item := range drift[0].Differences {
+		fields[item.Field]++
+		if item.Field == "env" && (item.Actual == "DB_PASSWORD=other" || item.Expected == "DB_PASSWORD=secret") {
+			t.Errorf("secret env value not masked: %+v", item)
+		}
+	}
+	if fields["imageDigest"] != 1 || fields["env"] != 3 || fields["ports"] != 0 || fields["mounts"] != 0 {
+		t.Errorf("unexpected differences: %+v", drift[0].Differences)
+	}
+}
+
+func TestDiffServiceContainerImageVolumes(t *testing.T) {
+	var config composeConfigJSON
+	err := json.Unmarshal([]byte(`{
+		"services": {
+			"db": {"image": "postgres:16"},
+			"cache": {"image": "postgres:16", "volumes": [{"type": "volume", "target": "/var/lib/postgresql/data"}]}
+		}
+	}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var image imageInspectJSON
+	image.Config.Volumes = map[string]struct{}{"/var/lib/postgresql/data": {}}
+
+	anonymous := func(destination string) string {
+		return `{"Type": "volume", "Name": "` + strings.Repeat("ab", 32) + `", "Destination": "` + destination + `", "RW": true}`
+	}
+	container := func(mounts ...string) containerInspectJSON {
+		var raw containerInspectJSON
+		if err := json.Unmarshal([]byte(`{"Name": "/app-db-1", "Config": {"Image": "postgres:16"}, "Mounts": [`+strings.Join(mounts, ",")+`]}`), &raw); err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	tests := []struct {
+		name    string
+		service string
+		raw     containerInspectJSON
+		drift   bool
+	}{
+		{"volume declared by the image", "db", container(anonymous("/var/lib/postgresql/data")), false},
+		{"anonymous volume not in the image", "db", container(anonymous("/cache")), true},
+		{"anonymous volume from the compose file", "cache", container(anonymous("/var/lib/postgresql/data")), false},
+		{"compose volume missing", "cache", container(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := diffServiceContainer(config, config.Services[tt.service], tt.raw, image, nil)
+			drifted := false
+			for _, item := range items {
+				if item.Field == "mounts" {
+					drifted = true
+				}
+			}
+			if drifted != tt.drift {
+				t.Errorf("mounts drift = %v, want %v: %+v", drifted, tt.drift, items)
+			}
+		})
+	}
+}
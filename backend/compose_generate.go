@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+var (
+	// Strings that can be written as plain YAML scalars without changing meaning
+	yamlPlainPattern = regexp.MustCompile(`^[A-Za-z_./][A-Za-z0-9_./@+-]*$`)
+	// Plain words YAML 1.1 parsers would read as booleans or null
+	yamlReservedPattern = regexp.MustCompile(`(?i)^(y|n|yes|no|true|false|on|off|null|~)$`)
+	// Characters not allowed in compose service names
+	serviceNameInvalidPattern = regexp.MustCompile(`[^a-z0-9_-]+`)
+	// Anonymous volumes are named by a 64 character hex ID
+	anonymousVolumePattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Request to generate a compose file. Exactly one selector must be set.
+type ComposeGenerateRequest struct {
+	Hostname      string   `json:"hostname"`
+	Username      string   `json:"username"`
+	ContainerIds  []string `json:"containerIds"`
+	LabelSelector string   `json:"labelSelector"` // "key" or "key=value"
+	Ungrouped     bool     `json:"ungrouped"`     // all containers not managed by compose
+	ProjectName   string   `json:"projectName"`
+}
+
+// Generated compose file
+type ComposeGenerateResponse struct {
+	YAML     string   `json:"yaml"`
+	Services []string `json:"services"`
+	Warnings []string `json:"warnings"`
+}
+
+// Generate a compose file equivalent to a set of existing containers
+func generateComposeFile(ctx echo.Context) error {
+	var req ComposeGenerateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	selectors := 0
+	if len(req.ContainerIds) > 0 {
+		selectors++
+	}
+	if req.LabelSelector != "" {
+		selectors++
+	}
+	if req.Ungrouped {
+		selectors++
+	}
+	if selectors != 1 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Specify exactly one of containerIds, labelSelector or ungrouped"})
+	}
+	for _, id := range req.ContainerIds {
+		if err := utils.ValidateContainerID(id); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid container ID: %v", err)})
+		}
+	}
+	if req.LabelSelector != "" {
+		if err := utils.ValidateLabelFilter(req.LabelSelector); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid label selector: %v", err)})
+		}
+	}
+	if req.ProjectName != "" {
+		if err := utils.ValidateComposeProjectName(req.ProjectName); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid project name: %v", err)})
+		}
+	}
+
+	ids := req.ContainerIds
+	if len(ids) == 0 {
+		args := []string{"ps", "-a", "-q", "--no-trunc"}
+		if req.LabelSelector != "" {
+			args = append(args, "--filter", "label="+req.LabelSelector)
+		}
+		output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(args...))
+		if err != nil {
+			logger.Errorf("Error listing containers: %v, output: %s", err, string(output))
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error":  fmt.Sprintf("Failed to list containers: %v", err),
+				"output": string(output),
+			})
+		}
+		ids = strings.Fields(string(output))
+	}
+	if len(ids) == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "No matching containers"})
+	}
+
+	inspected, output, err := inspectContainers(req.Username, req.Hostname, ids...)
+	if err != nil {
+		logger.Errorf("Error inspecting containers: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect containers: %v", err),
+			"output": string(output),
+		})
+	}
+
+	warnings := []string{}
+	var containers []containerInspectJSON
+	imageSet := make(map[string]bool)
+	var imageIDs []string
+	for _, raw := range inspected {
+		if project := raw.Config.Labels[composeProjectLabel]; project != "" {
+			if !req.Ungrouped {
+				warnings = append(warnings, fmt.Sprintf("%s is already managed by compose project %s, skipped", strings.TrimPrefix(raw.Name, "/"), project))
+			}
+			continue
+		}
+		containers = append(containers, raw)
+		if !imageSet[raw.Image] {
+			imageSet[raw.Image] = true
+			imageIDs = append(imageIDs, raw.Image)
+		}
+	}
+	if len(containers) == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "No containers outside of compose projects matched"})
+	}
+
+	images, output, err := inspectImages(req.Username, req.Hostname, imageIDs...)
+	if err != nil {
+		logger.Errorf("Error inspecting images: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect images: %v", err),
+			"output": string(output),
+		})
+	}
+	imagesByID := make(map[string]imageInspectJSON, len(images))
+	for _, image := range images {
+		imagesByID[image.ID] = image
+	}
+
+	yaml, services, genWarnings := buildComposeYAML(req.ProjectName, containers, imagesByID)
+	return ctx.JSON(http.StatusOK, ComposeGenerateResponse{
+		YAML:     yaml,
+		Services: services,
+		Warnings: append(warnings, genWarnings...),
+	})
+}
+
+// buildComposeYAML renders the containers as compose services. Named volumes
+// and user networks already exist on the host, so they are declared external
+// to keep their data and names. Containers on the default bridge network join
+// the project network instead.
+func buildComposeYAML(project string, containers []containerInspectJSON, images map[string]imageInspectJSON) (string, []string, []string) {
+	var w yamlWriter
+	warnings := []string{}
+	services := []string{}
+	usedNames := make(map[string]bool)
+	volumes := make(map[string]bool)
+	networks := make(map[string]bool)
+
+	if project != "" {
+		w.scalar(0, "name", project)
+	}
+	w.line(0, "services:")
+
+	for _, raw := range containers {
+		name := strings.TrimPrefix(raw.Name, "/")
+		service := serviceName(name, usedNames)
+		services = append(services, service)
+		image, ok := images[raw.Image]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: image %s not found, all settings are written explicitly", name, raw.Config.Image))
+		}
+
+		w.line(1, service+":")
+		w.scalar(2, "image", raw.Config.Image)
+		w.scalar(2, "container_name", name)
+
+		if !equalStrings(raw.Config.Entrypoint, image.Config.Entrypoint) {
+			if len(raw.Config.Entrypoint) == 0 {
+				w.line(2, "entrypoint: []")
+			}
+			w.list(2, "entrypoint", raw.Config.Entrypoint)
+			w.list(2, "command", raw.Config.Cmd)
+		} else if !equalStrings(raw.Config.Cmd, image.Config.Cmd) {
+			w.list(2, "command", raw.Config.Cmd)
+		}
+		if raw.Config.User != image.Config.User {
+			w.scalar(2, "user", raw.Config.User)
+		}
+		if raw.Config.WorkingDir != image.Config.WorkingDir {
+			w.scalar(2, "working_dir", raw.Config.WorkingDir)
+		}
+		w.list(2, "environment", containerOnlyEnv(raw, image))
+		w.mapping(2, "labels", containerOnlyLabels(raw, image))
+		w.list(2, "ports", publishSpecs(raw))
+
+		var mounts []string
+		for _, m := range raw.Mounts {
+			spec := ""
+			switch m.Type {
+			case "volume":
+				if anonymousVolumePattern.MatchString(m.Name) {
+					spec = m.Destination
+				} else {
+					spec = m.Name + ":" + m.Destination
+					volumes[m.Name] = true
+				}
+			case "bind":
+				spec = m.Source + ":" + m.Destination
+			default:
+				warnings = append(warnings, fmt.Sprintf("%s: %s mount at %s is not supported, skipped", name, m.Type, m.Destination))
+				continue
+			}
+			if !m.RW && spec != m.Destination {
+				spec += ":ro"
+			}
+			mounts = append(mounts, spec)
+		}
+		w.list(2, "volumes", mounts)
+
+		var tmpfs []string
+		for _, dest := range sortedKeys(raw.HostConfig.Tmpfs) {
+			spec := dest
+			if opts := raw.HostConfig.Tmpfs[dest]; opts != "" {
+				spec += ":" + opts
+			}
+			tmpfs = append(tmpfs, spec)
+		}
+		w.list(2, "tmpfs", tmpfs)
+
+		writeServiceNetworks(&w, raw, networks)
+
+		if policy := raw.HostConfig.RestartPolicy; policy.Name != "" && policy.Name != "no" {
+			value := policy.Name
+			if policy.Name == "on-failure" && policy.MaximumRetryCount > 0 {
+				value += ":" + strconv.Itoa(policy.MaximumRetryCount)
+			}
+			w.scalar(2, "restart", value)
+		}
+
+		if hc := raw.Config.Healthcheck; hc != nil && len(hc.Test) > 0 && (image.Config.Healthcheck == nil || !equalHealthchecks(*hc, *image.Config.Healthcheck)) {
+			w.line(2, "healthcheck:")
+			if hc.Test[0] == "NONE" {
+				w.line(3, "disable: true")
+			} else {
+				w.list(3, "test", hc.Test)
+				w.duration(3, "interval", hc.Interval)
+				w.duration(3, "timeout", hc.Timeout)
+				w.duration(3, "start_period", hc.StartPeriod)
+				if hc.Retries > 0 {
+					w.line(3, "retries: %d", hc.Retries)
+				}
+			}
+		}
+
+		limits := inspectLimits(raw)
+		if limits.Memory > 0 {
+			w.line(2, "mem_limit: %d", limits.Memory)
+		}
+		if limits.MemorySwap != 0 {
+			w.line(2, "memswap_limit: %d", limits.MemorySwap)
+		}
+		if limits.MemoryReservation > 0 {
+			w.line(2, "mem_reservation: %d", limits.MemoryReservation)
+		}
+		if limits.NanoCPUs > 0 {
+			w.line(2, "cpus: %s", strconv.FormatFloat(float64(limits.NanoCPUs)/1e9, 'f', -1, 64))
+		}
+		if limits.CPUShares > 0 {
+			w.line(2, "cpu_shares: %d", limits.CPUShares)
+		}
+		if limits.CpusetCpus != "" {
+			w.scalar(2, "cpuset", limits.CpusetCpus)
+		}
+		if limits.PidsLimit > 0 {
+			w.line(2, "pids_limit: %d", limits.PidsLimit)
+		}
+		if raw.HostConfig.Privileged {
+			w.line(2, "privileged: true")
+		}
+		w.list(2, "cap_add", raw.HostConfig.CapAdd)
+		w.list(2, "cap_drop", raw.HostConfig.CapDrop)
+		w.list(2, "extra_hosts", raw.HostConfig.ExtraHosts)
+		if logConfig := raw.HostConfig.LogConfig; (logConfig.Type != "" && logConfig.Type != "json-file") || len(logConfig.Config) > 0 {
+			w.line(2, "logging:")
+			w.scalar(3, "driver", logConfig.Type)
+			w.mapping(3, "options", logConfig.Config)
+		}
+	}
+
+	if len(volumes) > 0 {
+		w.line(0, "volumes:")
+		for _, name := range sortedKeys(volumes) {
+			w.line(1, yamlString(name)+":")
+			w.line(2, "external: true")
+		}
+	}
+	if len(networks) > 0 {
+		w.line(0, "networks:")
+		for _, name := range sortedKeys(networks) {
+			w.line(1, yamlString(name)+":")
+			w.line(2, "external: true")
+		}
+	}
+
+	return w.String(), services, warnings
+}
+
+// writeServiceNetworks writes network_mode or the networks of a service and
+// records user networks for the top level declaration
+func writeServiceNetworks(w *yamlWriter, raw containerInspectJSON, networks map[string]bool) {
+	mode := raw.HostConfig.NetworkMode
+	switch {
+	case mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:"):
+		w.scalar(2, "network_mode", mode)
+		return
+	case mode == "" || mode == "default" || mode == "bridge":
+		if len(raw.NetworkSettings.Networks) <= 1 {
+			return
+		}
+	}
+
+	var names []string
+	for _, name := range sortedKeys(raw.NetworkSettings.Networks) {
+		if name != "bridge" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	w.line(2, "networks:")
+	for _, name := range names {
+		networks[name] = true
+		n := raw.NetworkSettings.Networks[name]
+		var aliases []string
+		for _, alias := range n.Aliases {
+			if !strings.HasPrefix(raw.ID, alias) && alias != strings.TrimPrefix(raw.Name, "/") {
+				aliases = append(aliases, alias)
+			}
+		}
+		if len(aliases) == 0 && n.IPAMConfig == nil {
+			w.line(3, yamlString(name)+": {}")
+			continue
+		}
+		w.line(3, yamlString(name)+":")
+		w.list(4, "aliases", aliases)
+		if n.IPAMConfig != nil {
+			w.scalar(4, "ipv4_address", n.IPAMConfig.IPv4Address)
+			w.scalar(4, "ipv6_address", n.IPAMConfig.IPv6Address)
+		}
+	}
+}
+
+// serviceName derives a unique compose service name from a container name
+func serviceName(containerName string, used map[string]bool) string {
+	base := strings.Trim(serviceNameInvalidPattern.ReplaceAllString(strings.ToLower(containerName), "-"), "-")
+	if base == "" {
+		base = "service"
+	}
+	name := base
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalHealthchecks(a, b inspectHealthcheck) bool {
+	return equalStrings(a.Test, b.Test) && a.Interval == b.Interval && a.Timeout == b.Timeout &&
+		a.StartPeriod == b.StartPeriod && a.Retries == b.Retries
+}
+
+// yamlWriter emits block style YAML. Keys with empty values are omitted.
+type yamlWriter struct {
+	strings.Builder
+}
+
+func (w *yamlWriter) line(indent int, format string, args ...interface{}) {
+	w.WriteString(strings.Repeat("  ", indent))
+	fmt.Fprintf(w, format, args...)
+	w.WriteString("\n")
+}
+
+func (w *yamlWriter) scalar(indent int, key, value string) {
+	if value != "" {
+		w.line(indent, "%s: %s", key, yamlString(value))
+	}
+}
+
+func (w *yamlWriter) duration(indent int, key string, nanos int64) {
+	if nanos > 0 {
+		w.line(indent, "%s: %s", key, time.Duration(nanos).String())
+	}
+}
+
+func (w *yamlWriter) list(indent int, key string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	w.line(indent, "%s:", key)
+	for _, item := range items {
+		w.line(indent+1, "- %s", yamlString(item))
+	}
+}
+
+func (w *yamlWriter) mapping(indent int, key string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	w.line(indent, "%s:", key)
+	for _, k := range sortedKeys(m) {
+		w.line(indent+1, "%s: %s", yamlString(k), yamlString(m[k]))
+	}
+}
+
+// yamlString writes s as a plain scalar when that is unambiguous and as a
+// double quoted scalar otherwise. JSON string escapes are valid YAML. Dollar
+// signs are doubled so compose does not interpolate them.
+func yamlString(s string) string {
+	s = strings.ReplaceAll(s, "$", "$$")
+	if yamlPlainPattern.MatchString(s) && !yamlReservedPattern.MatchString(s) {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestYAMLString(t *testing.T) {
+	tests := map[string]string{
+		"nginx:1.25": `"nginx:1.25"`,
+		"web":        "web",
+		"yes":        `"yes"`,
+		"8080":       `"8080"`,
+		"PASS=a$b":   `"PASS=a$$b"`,
+		"/data/app":  "/data/app",
+		"say \"hi\"": `"say \"hi\""`,
+	}
+	for in, want := range tests {
+		if got := yamlString(in); got != want {
+			t.Errorf("yamlString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestBuildComposeYAML(t *testing.T) {
+	var raw containerInspectJSON
+	err := json.Unmarshal([]byte(`{
+		"Id": "abcdef1234567890",
+		"Name": "/My_App",
+		"Image": "sha256:img",
+		"Config": {"Image": "myapp:1", "Env": ["PATH=/usr/bin", "MODE=prod"], "Cmd": ["serve"]},
+		"HostConfig": {
+			"NetworkMode": "backend",
+			"RestartPolicy": {"Name": "unless-stopped"},
+			"PortBindings": {"80/tcp": [{"HostIp": "127.0.0.1", "HostPort": "8080"}]}
+		},
+		"Mounts": [{"Type": "volume", "Name": "appdata", "Destination": "/data", "RW": true}],
+		"NetworkSettings": {"Networks": {"backend": {"Aliases": ["abcdef123456", "api"]}}}
+	}`), &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var image imageInspectJSON
+	image.ID = "sha256:img"
+	image.Config.Env = []string{"PATH=/usr/bin"}
+	image.Config.Cmd = []string{"serve"}
+
+	yaml, services, _ := buildComposeYAML("", []containerInspectJSON{raw}, map[string]imageInspectJSON{image.ID: image})
+	if len(services) != 1 || services[0] != "my_app" {
+		t.Fatalf("services = %v", services)
+	}
+	for _, want := range []string{
+		"  my_app:\n    image: \"myapp:1\"\n",
+		"    environment:\n      - \"MODE=prod\"\n",
+		"    ports:\n      - \"127.0.0.1:8080:80/tcp\"\n",
+		"    volumes:\n      - \"appdata:/data\"\n",
+		"    networks:\n      backend:\n        aliases:\n          - api\n",
+		"    restart: unless-stopped\n",
+		"volumes:\n  appdata:\n    external: true\n",
+		"networks:\n  backend:\n    external: true\n",
+	} {
+		if !strings.Contains(yaml, want) {
+			t.Errorf("missing %q in:\n%s", want, yaml)
+		}
+	}
+	if strings.Contains(yaml, "PATH=") || strings.Contains(yaml, "command:") {
+		t.Errorf("image defaults should be omitted:\n%s", yaml)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Filesystem changes of a container compared to its image
+type ContainerDiffResponse struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Deleted []string `json:"deleted"`
+}
+
+// Get the filesystem changes of a container (docker diff)
+func getContainerDiff(ctx echo.Context) error {
+	var req ContainerRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateContainerRequest(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dockerCommand := utils.BuildDockerCommand("diff", req.ContainerId)
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	if err != nil {
+		logger.Errorf("Error reading container diff: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to read filesystem changes: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, parseDiffOutput(string(output)))
+}
+
+// parseDiffOutput parses lines like "A /tmp/new", "C /etc" and "D /var/old"
+func parseDiffOutput(output string) ContainerDiffResponse {
+	diff := ContainerDiffResponse{
+		Added:   []string{},
+		Changed: []string{},
+		Deleted: []string{},
+	}
+	for _, line := range strings.Split(output, "\n") {
+		kind, path, found := strings.Cut(strings.TrimRight(line, "\r"), " ")
+		if !found || path == "" {
+			continue
+		}
+		switch kind {
+		case "A":
+			diff.Added = append(diff.Added, path)
+		case "C":
+			diff.Changed = append(diff.Changed, path)
+		case "D":
+			diff.Deleted = append(diff.Deleted, path)
+		}
+	}
+	return diff
+}
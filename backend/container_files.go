@@ -0,0 +1,505 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// stat format used for directory listings: path|type|size|mode|mtime|owner|group
+const containerStatFormat = "%n|%F|%s|%a|%Y|%U|%G"
+
+// Request for container file operations
+type ContainerFilesRequest struct {
+	Hostname    string `json:"hostname"`
+	Username    string `json:"username"`
+	ContainerId string `json:"containerId"`
+	Path        string `json:"path"`
+	Format      string `json:"format"` // download only: tar (default), zip or raw for a single file
+}
+
+// A file or directory inside a container
+type ContainerFileEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Type    string `json:"type"` // file, directory, symlink, other
+	Size    int64  `json:"size"`
+	Mode    string `json:"mode"`    // octal permission bits, e.g. "644"
+	ModTime int64  `json:"modTime"` // unix seconds
+	Owner   string `json:"owner,omitempty"`
+	Group   string `json:"group,omitempty"`
+}
+
+// Directory listing response
+type ContainerFilesResponse struct {
+	Path      string               `json:"path"`
+	Entries   []ContainerFileEntry `json:"entries"`
+	Truncated bool                 `json:"truncated,omitempty"` // fallback listing stopped early, entries may be missing
+}
+
+// Upper bound for the archive read by the docker cp listing fallback. The
+// direct children are spread over the whole archive, so a large tree is
+// listed partially instead of being transferred completely.
+const fallbackListingLimit int64 = 64 << 20
+
+var errListingTruncated = errors.New("listing truncated")
+
+// validateContainerFilesRequest checks the common fields of file requests and
+// returns the cleaned path
+func validateContainerFilesRequest(req ContainerFilesRequest) (string, error) {
+	if req.Path == "" {
+		return "", fmt.Errorf("Missing required fields")
+	}
+	if err := validateContainerRequest(ContainerRequest{Hostname: req.Hostname, Username: req.Username, ContainerId: req.ContainerId}); err != nil {
+		return "", err
+	}
+	cleanPath, err := utils.CleanContainerPath(req.Path)
+	if err != nil {
+		return "", fmt.Errorf("Invalid path: %v", err)
+	}
+	return cleanPath, nil
+}
+
+// List a directory inside a container
+func listContainerFiles(ctx echo.Context) error {
+	var req ContainerFilesRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	dir, err := validateContainerFilesRequest(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	entries, truncated, err := listContainerDir(ctx.Request().Context(), req.Username, req.Hostname, req.ContainerId, dir)
+	if err != nil {
+		logger.Errorf("Error listing %s in container %s: %v", dir, req.ContainerId, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to list directory: %v", err),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, ContainerFilesResponse{Path: dir, Entries: entries, Truncated: truncated})
+}
+
+// Download a file or directory from a container as a tar or zip stream
+func downloadContainerFiles(ctx echo.Context) error {
+	var req ContainerFilesRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	srcPath, err := validateContainerFilesRequest(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	name := path.Base(srcPath)
+	if name == "/" {
+		name = "root"
+	}
+
+	var out *deferredResponse
+	switch req.Format {
+	case "", "tar":
+		out = newDeferredResponse(ctx, "application/x-tar", name+".tar")
+	case "zip":
+		out = newDeferredResponse(ctx, "application/zip", name+".zip")
+	case "raw":
+		out = newDeferredResponse(ctx, "application/octet-stream", name)
+	default:
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format, expected tar, zip or raw"})
+	}
+
+	// docker cp writes a tar archive of the path to stdout, nothing touches the remote disk
+	dockerCommand := utils.BuildDockerCommand("cp", req.ContainerId+":"+srcPath, "-")
+	logger.Infof("Executing download command: %s", dockerCommand)
+
+	err = streamTar(ctx.Request().Context(), req.Username, req.Hostname, dockerCommand, utils.MaxDownloadSize, func(tr io.Reader) error {
+		switch req.Format {
+		case "zip":
+			return tarToZip(tr, out)
+		case "raw":
+			return extractSingleFile(tr, out)
+		default:
+			_, err := io.Copy(out, tr)
+			return err
+		}
+	})
+	if err != nil {
+		logger.Errorf("Error downloading %s from container %s: %v", srcPath, req.ContainerId, err)
+		if !out.started {
+			status := http.StatusInternalServerError
+			if errors.Is(err, utils.ErrSizeLimitExceeded) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			return ctx.JSON(status, map[string]string{
+				"error": fmt.Sprintf("Failed to download: %v", err),
+			})
+		}
+		// Headers are already sent, all we can do is cut the stream short
+		return nil
+	}
+
+	out.start()
+	return nil
+}
+
+// Upload a file into a directory inside a container
+func uploadContainerFile(ctx echo.Context) error {
+	ctx.Request().Body = http.MaxBytesReader(ctx.Response(), ctx.Request().Body, utils.MaxUploadSize+1<<20)
+
+	req := ContainerFilesRequest{
+		Hostname:    ctx.FormValue("hostname"),
+		Username:    ctx.FormValue("username"),
+		ContainerId: ctx.FormValue("containerId"),
+		Path:        ctx.FormValue("path"),
+	}
+	destDir, err := validateContainerFilesRequest(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing file"})
+	}
+	if err := utils.ValidateFileName(fileHeader.Filename); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid file name: %v", err)})
+	}
+	if err := utils.ValidateTransferSize(fileHeader.Size, utils.MaxUploadSize); err != nil {
+		return ctx.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read uploaded file"})
+	}
+	defer src.Close()
+
+	// docker cp extracts a tar archive from stdin into the directory
+	dockerCommand := utils.BuildDockerCommand("cp", "-", req.ContainerId+":"+destDir)
+	logger.Infof("Executing upload command: %s", dockerCommand)
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     fileHeader.Filename,
+			Mode:     0644,
+			Size:     fileHeader.Size,
+			ModTime:  time.Now(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, io.LimitReader(src, fileHeader.Size))
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = tunnelManager.StreamCommand(ctx.Request().Context(), req.Username, req.Hostname, dockerCommand, pr, io.Discard)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		logger.Errorf("Error uploading %s to container %s: %v", fileHeader.Filename, req.ContainerId, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to upload file: %v", err),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"success": "true",
+		"message": fmt.Sprintf("Uploaded %s to %s", fileHeader.Filename, path.Join(destDir, fileHeader.Filename)),
+	})
+}
+
+// listContainerDir lists the direct children of dir. It prefers find/stat
+// inside the container and falls back to reading the headers of a docker cp
+// tar stream, which also works for stopped or shell-less containers. The
+// fallback reads at most fallbackListingLimit bytes and reports whether it
+// stopped early.
+func listContainerDir(ctx context.Context, username, hostname, containerID, dir string) ([]ContainerFileEntry, bool, error) {
+	statCommand := utils.BuildDockerCommand("exec", containerID,
+		"find", dir, "-mindepth", "1", "-maxdepth", "1", "-exec", "stat", "-c", containerStatFormat, "{}", "+")
+	output, err := tunnelManager.ExecuteCommand(username, hostname, statCommand)
+	if err == nil {
+		return parseStatOutput(string(output), dir), false, nil
+	}
+	logger.Warnf("find/stat listing failed in container %s, falling back to docker cp: %v, output: %s", containerID, err, string(output))
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var entries []ContainerFileEntry
+	cpCommand := utils.BuildDockerCommand("cp", containerID+":"+dir, "-")
+	err = streamTar(listCtx, username, hostname, cpCommand, utils.MaxDownloadSize, func(r io.Reader) error {
+		lr := &io.LimitedReader{R: r, N: fallbackListingLimit}
+		var err error
+		entries, err = listTarChildren(lr, dir)
+		if err != nil && lr.N == 0 {
+			// Stop the transfer instead of draining the rest of the tree
+			cancel()
+			return errListingTruncated
+		}
+		return err
+	})
+	if errors.Is(err, errListingTruncated) {
+		logger.Warnf("Listing %s in container %s via docker cp stopped after %d bytes", dir, containerID, fallbackListingLimit)
+		return entries, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return entries, false, nil
+}
+
+// parseStatOutput parses lines produced with containerStatFormat
+func parseStatOutput(output, dir string) []ContainerFileEntry {
+	entries := []ContainerFileEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "|")
+		if len(parts) < 7 {
+			logger.Warnf("Invalid stat line: %s", line)
+			continue
+		}
+		// The name may contain the separator, the trailing fields never do
+		fields := parts[len(parts)-6:]
+		fullPath := strings.Join(parts[:len(parts)-6], "|")
+
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		modTime, _ := strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, ContainerFileEntry{
+			Name:    path.Base(fullPath),
+			Path:    path.Join(dir, path.Base(fullPath)),
+			Type:    statFileType(fields[0]),
+			Size:    size,
+			Mode:    fields[2],
+			ModTime: modTime,
+			Owner:   fields[4],
+			Group:   fields[5],
+		})
+	}
+	sortFileEntries(entries)
+	return entries
+}
+
+// statFileType maps stat's %F output to our entry types
+func statFileType(t string) string {
+	switch {
+	case strings.Contains(t, "directory"):
+		return "directory"
+	case strings.Contains(t, "symbolic link"):
+		return "symlink"
+	case strings.Contains(t, "regular"):
+		return "file"
+	}
+	return "other"
+}
+
+// listTarChildren returns the direct children of the archived directory. docker
+// cp prefixes every entry with the base name of the copied path. On a read
+// error the children found so far are returned along with it.
+func listTarChildren(r io.Reader, dir string) ([]ContainerFileEntry, error) {
+	entries := []ContainerFileEntry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sortFileEntries(entries)
+			return entries, err
+		}
+
+		name := strings.TrimPrefix(strings.TrimSuffix(hdr.Name, "/"), "./")
+		_, rest, found := strings.Cut(name, "/")
+		if !found || rest == "" || strings.Contains(rest, "/") {
+			continue
+		}
+
+		entry := ContainerFileEntry{
+			Name:    rest,
+			Path:    path.Join(dir, rest),
+			Size:    hdr.Size,
+			Mode:    strconv.FormatInt(hdr.Mode&0o7777, 8),
+			ModTime: hdr.ModTime.Unix(),
+			Owner:   hdr.Uname,
+			Group:   hdr.Gname,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.Type = "directory"
+		case tar.TypeSymlink:
+			entry.Type = "symlink"
+		case tar.TypeReg:
+			entry.Type = "file"
+		default:
+			entry.Type = "other"
+		}
+		if entry.Owner == "" {
+			entry.Owner = strconv.Itoa(hdr.Uid)
+		}
+		if entry.Group == "" {
+			entry.Group = strconv.Itoa(hdr.Gid)
+		}
+		entries = append(entries, entry)
+	}
+	sortFileEntries(entries)
+	return entries, nil
+}
+
+// sortFileEntries puts directories first, then sorts by name
+func sortFileEntries(entries []ContainerFileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		di, dj := entries[i].Type == "directory", entries[j].Type == "directory"
+		if di != dj {
+			return di
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// streamTar runs a remote command producing a tar stream and hands the stream
+// to consume while it is still being transferred. At most limit bytes are read.
+func streamTar(ctx context.Context, username, hostname, command string, limit int64, consume func(io.Reader) error) error {
+	pr, pw := io.Pipe()
+	streamErr := make(chan error, 1)
+	go func() {
+		err := tunnelManager.StreamCommand(ctx, username, hostname, command, nil, utils.LimitWriter(pw, limit))
+		pw.CloseWithError(err)
+		streamErr <- err
+	}()
+
+	consumeErr := consume(pr)
+	if consumeErr == nil {
+		// Drain what is left so the remote command can finish cleanly
+		_, consumeErr = io.Copy(io.Discard, pr)
+	}
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	// A failed transfer surfaces in the reader as well, report the root cause
+	err := <-streamErr
+	if err != nil && (consumeErr == nil || errors.Is(consumeErr, err) || errors.Is(consumeErr, io.ErrUnexpectedEOF)) {
+		return err
+	}
+	return consumeErr
+}
+
+// tarToZip re-packs a tar stream as a zip archive on the fly
+func tarToZip(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	zw := zip.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		fh, err := zip.FileInfoHeader(hdr.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = strings.TrimPrefix(hdr.Name, "./")
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fh.Name = strings.TrimSuffix(fh.Name, "/") + "/"
+			if _, err := zw.CreateHeader(fh); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			fh.Method = zip.Deflate
+			fw, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fw, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// zip stores the link target as the file content
+			fw, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(fw, hdr.Linkname); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// extractSingleFile copies the content of the only regular file in a tar stream
+func extractSingleFile(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("archive contains no regular file")
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			_, err := io.Copy(w, tr)
+			return err
+		case tar.TypeDir:
+			return fmt.Errorf("path is a directory, use the tar or zip format")
+		}
+	}
+}
+
+// deferredResponse sends the response headers only once the first byte of the
+// body is written, so a command failing before producing output can still be
+// reported as a JSON error
+type deferredResponse struct {
+	ctx         echo.Context
+	contentType string
+	filename    string
+	started     bool
+}
+
+func newDeferredResponse(ctx echo.Context, contentType, filename string) *deferredResponse {
+	return &deferredResponse{ctx: ctx, contentType: contentType, filename: filename}
+}
+
+func (d *deferredResponse) start() {
+	if d.started {
+		return
+	}
+	d.started = true
+	header := d.ctx.Response().Header()
+	header.Set(echo.HeaderContentType, d.contentType)
+	if d.filename != "" {
+		header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", d.filename))
+	}
+	d.ctx.Response().WriteHeader(http.StatusOK)
+}
+
+func (d *deferredResponse) Write(p []byte) (int, error) {
+	d.start()
+	return d.ctx.Response().Write(p)
+}
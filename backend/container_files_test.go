@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func buildTar(t *testing.T, entries map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"conf/", "conf/nginx.conf", "conf/sites/", "conf/sites/default"} {
+		content, ok := entries[name]
+		if !ok {
+			continue
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestParseStatOutput(t *testing.T) {
+	output := "/etc/b.conf|regular file|12|644|1700000000|root|root\n" +
+		"/etc/a|b|directory|4096|755|1700000000|root|root\n" +
+		"/etc/link|symbolic link|7|777|1700000000|root|root\n"
+	entries := parseStatOutput(output, "/etc")
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+	if entries[0].Name != "a|b" || entries[0].Type != "directory" {
+		t.Errorf("directories should come first and keep their name: %+v", entries[0])
+	}
+	if entries[1].Name != "b.conf" || entries[1].Size != 12 || entries[1].Path != "/etc/b.conf" {
+		t.Errorf("unexpected file entry: %+v", entries[1])
+	}
+	if entries[2].Type != "symlink" {
+		t.Errorf("unexpected link entry: %+v", entries[2])
+	}
+}
+
+func TestListTarChildren(t *testing.T) {
+	buf := buildTar(t, map[string]string{
+		"conf/":              "",
+		"conf/nginx.conf":    "worker_processes 1;",
+		"conf/sites/":        "",
+		"conf/sites/default": "server {}",
+	})
+	entries, err := listTarChildren(buf, "/etc/conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "sites" || entries[1].Name != "nginx.conf" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	// A cut-off archive still yields the children read before the cut
+	buf = buildTar(t, map[string]string{
+		"conf/":              "",
+		"conf/nginx.conf":    "worker_processes 1;",
+		"conf/sites/":        "",
+		"conf/sites/default": "server {}",
+	})
+	entries, err = listTarChildren(io.LimitReader(buf, 3*512+100), "/etc/conf")
+	if err == nil || len(entries) != 1 || entries[0].Name != "nginx.conf" {
+		t.Errorf("cut-off archive: got %+v, %v", entries, err)
+	}
+}
+
+func TestTarConversions(t *testing.T) {
+	buf := buildTar(t, map[string]string{
+		"conf/":           "",
+		"conf/nginx.conf": "worker_processes 1;",
+	})
+	var zipped bytes.Buffer
+	if err := tarToZip(bytes.NewReader(buf.Bytes()), &zipped); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[1].Name != "conf/nginx.conf" {
+		t.Errorf("unexpected zip content: %+v", zr.File)
+	}
+
+	if err := extractSingleFile(bytes.NewReader(buf.Bytes()), &bytes.Buffer{}); err == nil {
+		t.Error("extracting a directory should fail")
+	}
+
+	single := buildTar(t, map[string]string{"conf/nginx.conf": "worker_processes 1;"})
+	var raw bytes.Buffer
+	if err := extractSingleFile(single, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.String() != "worker_processes 1;" {
+		t.Errorf("unexpected file content %q", raw.String())
+	}
+}
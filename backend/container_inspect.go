@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Label docker compose puts on every container it manages
+const composeProjectLabel = "com.docker.compose.project"
+
+// Env variable names whose values are masked before they reach the UI. The
+// short forms only count as whole name parts, and pwd not on its own, so the
+// shell's PWD and OLDPWD or AUTHOR pass.
+var secretEnvPattern = regexp.MustCompile(`(?i)(passw(or)?d|secret|token|api_?key|private_?key|access_?key|credential|_pwd($|_)|(^|_)auth($|_))`)
+
+// Placeholder returned instead of a masked env value
+const maskedValue = "********"
+
+// PortBinding is a single published or exposed container port
+type PortBinding struct {
+	HostIP        string `json:"hostIp,omitempty"`
+	HostPort      string `json:"hostPort,omitempty"`
+	ContainerPort string `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// Raw `docker inspect` output for a container. Only the fields we use are mapped.
+type containerInspectJSON struct {
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	Created      string `json:"Created"`
+	Image        string `json:"Image"`
+	RestartCount int    `json:"RestartCount"`
+	State        struct {
+		Status     string `json:"Status"`
+		Running    bool   `json:"Running"`
+		Paused     bool   `json:"Paused"`
+		Restarting bool   `json:"Restarting"`
+		OOMKilled  bool   `json:"OOMKilled"`
+		Dead       bool   `json:"Dead"`
+		Pid        int    `json:"Pid"`
+		ExitCode   int    `json:"ExitCode"`
+		Error      string `json:"Error"`
+		StartedAt  string `json:"StartedAt"`
+		FinishedAt string `json:"FinishedAt"`
+		Health     *struct {
+			Status        string `json:"Status"`
+			FailingStreak int    `json:"FailingStreak"`
+			Log           []struct {
+				Start    string `json:"Start"`
+				End      string `json:"End"`
+				ExitCode int    `json:"ExitCode"`
+				Output   string `json:"Output"`
+			} `json:"Log"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Hostname     string              `json:"Hostname"`
+		User         string              `json:"User"`
+		Env          []string            `json:"Env"`
+		Cmd          []string            `json:"Cmd"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		Image        string              `json:"Image"`
+		WorkingDir   string              `json:"WorkingDir"`
+		Labels       map[string]string   `json:"Labels"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		StopSignal   string              `json:"StopSignal"`
+		Healthcheck  *inspectHealthcheck `json:"Healthcheck"`
+	} `json:"Config"`
+	HostConfig struct {
+		Binds         []string `json:"Binds"`
+		NetworkMode   string   `json:"NetworkMode"`
+		RestartPolicy struct {
+			Name              string `json:"Name"`
+			MaximumRetryCount int    `json:"MaximumRetryCount"`
+		} `json:"RestartPolicy"`
+		PortBindings      map[string][]inspectPortBinding `json:"PortBindings"`
+		Memory            int64                           `json:"Memory"`
+		MemorySwap        int64                           `json:"MemorySwap"`
+		MemoryReservation int64                           `json:"MemoryReservation"`
+		NanoCpus          int64                           `json:"NanoCpus"`
+		CpuShares         int64                           `json:"CpuShares"`
+		CpuPeriod         int64                           `json:"CpuPeriod"`
+		CpuQuota          int64                           `json:"CpuQuota"`
+		CpusetCpus        string                          `json:"CpusetCpus"`
+		PidsLimit         *int64                          `json:"PidsLimit"`
+		Privileged        bool                            `json:"Privileged"`
+		CapAdd            []string                        `json:"CapAdd"`
+		CapDrop           []string                        `json:"CapDrop"`
+		ExtraHosts        []string                        `json:"ExtraHosts"`
+		Tmpfs             map[string]string               `json:"Tmpfs"`
+		LogConfig         struct {
+			Type   string            `json:"Type"`
+			Config map[string]string `json:"Config"`
+		} `json:"LogConfig"`
+	} `json:"HostConfig"`
+	Mounts []struct {
+		Type        string `json:"Type"`
+		Name        string `json:"Name"`
+		Source      string `json:"Source"`
+		Destination string `json:"Destination"`
+		Driver      string `json:"Driver"`
+		Mode        string `json:"Mode"`
+		RW          bool   `json:"RW"`
+		Propagation string `json:"Propagation"`
+	} `json:"Mounts"`
+	NetworkSettings struct {
+		Ports    map[string][]inspectPortBinding `json:"Ports"`
+		Networks map[string]struct {
+			NetworkID         string   `json:"NetworkID"`
+			IPAddress         string   `json:"IPAddress"`
+			IPPrefixLen       int      `json:"IPPrefixLen"`
+			Gateway           string   `json:"Gateway"`
+			GlobalIPv6Address string   `json:"GlobalIPv6Address"`
+			MacAddress        string   `json:"MacAddress"`
+			Aliases           []string `json:"Aliases"`
+			IPAMConfig        *struct {
+				IPv4Address string `json:"IPv4Address"`
+				IPv6Address string `json:"IPv6Address"`
+			} `json:"IPAMConfig"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Healthcheck as stored in container and image configs, durations in nanoseconds
+type inspectHealthcheck struct {
+	Test        []string `json:"Test"`
+	Interval    int64    `json:"Interval"`
+	Timeout     int64    `json:"Timeout"`
+	StartPeriod int64    `json:"StartPeriod"`
+	Retries     int      `json:"Retries"`
+}
+
+type inspectPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// ContainerMount describes a volume, bind or tmpfs mount
+type ContainerMount struct {
+	Type        string `json:"type"` // volume, bind, tmpfs
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Driver      string `json:"driver,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	ReadOnly    bool   `json:"readOnly"`
+}
+
+// ContainerNetwork is a network the container is attached to
+type ContainerNetwork struct {
+	Name        string   `json:"name"`
+	NetworkID   string   `json:"networkId"`
+	IPAddress   string   `json:"ipAddress,omitempty"`
+	IPPrefixLen int      `json:"ipPrefixLen,omitempty"`
+	IPv6Address string   `json:"ipv6Address,omitempty"`
+	Gateway     string   `json:"gateway,omitempty"`
+	MacAddress  string   `json:"macAddress,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+}
+
+// RestartPolicy of a container, e.g. "unless-stopped" or "on-failure:3"
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximumRetryCount"`
+}
+
+// HealthCheckResult is one probe run from the health log
+type HealthCheckResult struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	ExitCode int    `json:"exitCode"`
+	Output   string `json:"output"`
+}
+
+// ContainerHealth is the healthcheck state, nil if the container has no healthcheck
+type ContainerHealth struct {
+	Status        string              `json:"status"` // starting, healthy, unhealthy
+	FailingStreak int                 `json:"failingStreak"`
+	Log           []HealthCheckResult `json:"log"`
+}
+
+// ResourceLimits as configured on the container. Zero means unlimited.
+type ResourceLimits struct {
+	Memory            int64  `json:"memory"`            // bytes
+	MemorySwap        int64  `json:"memorySwap"`        // bytes, -1 for unlimited swap
+	MemoryReservation int64  `json:"memoryReservation"` // bytes
+	NanoCPUs          int64  `json:"nanoCpus"`          // 1e9 = one CPU
+	CPUShares         int64  `json:"cpuShares"`
+	CPUPeriod         int64  `json:"cpuPeriod"`
+	CPUQuota          int64  `json:"cpuQuota"`
+	CpusetCpus        string `json:"cpusetCpus"`
+	PidsLimit         int64  `json:"pidsLimit"`
+}
+
+// ContainerDetails is the UI friendly view of `docker inspect`
+type ContainerDetails struct {
+	ID            string             `json:"id"`
+	Name          string             `json:"name"`
+	Image         string             `json:"image"`
+	ImageID       string             `json:"imageId"`
+	Created       string             `json:"created"`
+	State         string             `json:"state"` // running, exited, paused, restarting, dead, created
+	ExitCode      int                `json:"exitCode"`
+	Error         string             `json:"error,omitempty"`
+	OOMKilled     bool               `json:"oomKilled"`
+	StartedAt     string             `json:"startedAt"`
+	FinishedAt    string             `json:"finishedAt"`
+	RestartCount  int                `json:"restartCount"`
+	Ports         []PortBinding      `json:"ports"`
+	Labels        map[string]string  `json:"labels"`
+	Env           []string           `json:"env"` // KEY=VALUE, secret values masked
+	Mounts        []ContainerMount   `json:"mounts"`
+	Networks      []ContainerNetwork `json:"networks"`
+	RestartPolicy RestartPolicy      `json:"restartPolicy"`
+	Health        *ContainerHealth   `json:"health,omitempty"`
+	Limits        ResourceLimits     `json:"limits"`
+	Entrypoint    []string           `json:"entrypoint"`
+	Cmd           []string           `json:"cmd"`
+	WorkingDir    string             `json:"workingDir,omitempty"`
+	User          string             `json:"user,omitempty"`
+}
+
+// Inspect a single container
+func getContainerInspect(ctx echo.Context) error {
+	var req ContainerRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateContainerRequest(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	inspected, output, err := inspectContainers(req.Username, req.Hostname, req.ContainerId)
+	if err != nil {
+		logger.Errorf("Error inspecting container: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect container: %v", err),
+			"output": string(output),
+		})
+	}
+	if len(inspected) == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Container not found"})
+	}
+
+	return ctx.JSON(http.StatusOK, newContainerDetails(inspected[0]))
+}
+
+// inspectContainers runs a single `docker inspect` for all given containers.
+// The raw command output is returned alongside so callers can surface it on errors.
+func inspectContainers(username, hostname string, ids ...string) ([]containerInspectJSON, []byte, error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	args := append([]string{"inspect", "--type", "container"}, ids...)
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		return nil, output, err
+	}
+
+	var inspected []containerInspectJSON
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, output, fmt.Errorf("failed to parse inspect output: %v", err)
+	}
+	return inspected, output, nil
+}
+
+// newContainerDetails converts raw inspect output into the API model
+func newContainerDetails(raw containerInspectJSON) ContainerDetails {
+	details := ContainerDetails{
+		ID:           raw.ID,
+		Name:         strings.TrimPrefix(raw.Name, "/"),
+		Image:        raw.Config.Image,
+		ImageID:      raw.Image,
+		Created:      raw.Created,
+		State:        raw.State.Status,
+		ExitCode:     raw.State.ExitCode,
+		Error:        raw.State.Error,
+		OOMKilled:    raw.State.OOMKilled,
+		StartedAt:    raw.State.StartedAt,
+		FinishedAt:   raw.State.FinishedAt,
+		RestartCount: raw.RestartCount,
+		Ports:        inspectPortBindings(raw),
+		Labels:       raw.Config.Labels,
+		Env:          maskEnv(raw.Config.Env),
+		Mounts:       make([]ContainerMount, 0, len(raw.Mounts)),
+		Networks:     make([]ContainerNetwork, 0, len(raw.NetworkSettings.Networks)),
+		RestartPolicy: RestartPolicy{
+			Name:              raw.HostConfig.RestartPolicy.Name,
+			MaximumRetryCount: raw.HostConfig.RestartPolicy.MaximumRetryCount,
+		},
+		Limits:     inspectLimits(raw),
+		Entrypoint: raw.Config.Entrypoint,
+		Cmd:        raw.Config.Cmd,
+		WorkingDir: raw.Config.WorkingDir,
+		User:       raw.Config.User,
+	}
+	if details.Labels == nil {
+		details.Labels = map[string]string{}
+	}
+
+	for _, m := range raw.Mounts {
+		details.Mounts = append(details.Mounts, ContainerMount{
+			Type:        m.Type,
+			Name:        m.Name,
+			Source:      m.Source,
+			Destination: m.Destination,
+			Driver:      m.Driver,
+			Mode:        m.Mode,
+			ReadOnly:    !m.RW,
+		})
+	}
+
+	for name, n := range raw.NetworkSettings.Networks {
+		details.Networks = append(details.Networks, ContainerNetwork{
+			Name:        name,
+			NetworkID:   n.NetworkID,
+			IPAddress:   n.IPAddress,
+			IPPrefixLen: n.IPPrefixLen,
+			IPv6Address: n.GlobalIPv6Address,
+			Gateway:     n.Gateway,
+			MacAddress:  n.MacAddress,
+			Aliases:     n.Aliases,
+		})
+	}
+	sort.Slice(details.Networks, func(i, j int) bool {
+		return details.Networks[i].Name < details.Networks[j].Name
+	})
+
+	if h := raw.State.Health; h != nil {
+		health := &ContainerHealth{
+			Status:        h.Status,
+			FailingStreak: h.FailingStreak,
+			Log:           make([]HealthCheckResult, 0, len(h.Log)),
+		}
+		for _, l := range h.Log {
+			health.Log = append(health.Log, HealthCheckResult{
+				Start:    l.Start,
+				End:      l.End,
+				ExitCode: l.ExitCode,
+				Output:   l.Output,
+			})
+		}
+		details.Health = health
+	}
+
+	return details
+}
+
+// inspectLimits extracts the configured resource limits
+func inspectLimits(raw containerInspectJSON) ResourceLimits {
+	limits := ResourceLimits{
+		Memory:            raw.HostConfig.Memory,
+		MemorySwap:        raw.HostConfig.MemorySwap,
+		MemoryReservation: raw.HostConfig.MemoryReservation,
+		NanoCPUs:          raw.HostConfig.NanoCpus,
+		CPUShares:         raw.HostConfig.CpuShares,
+		CPUPeriod:         raw.HostConfig.CpuPeriod,
+		CPUQuota:          raw.HostConfig.CpuQuota,
+		CpusetCpus:        raw.HostConfig.CpusetCpus,
+	}
+	if raw.HostConfig.PidsLimit != nil {
+		limits.PidsLimit = *raw.HostConfig.PidsLimit
+	}
+	return limits
+}
+
+// inspectPortBindings flattens the port map from inspect. Running containers
+// report their live bindings in NetworkSettings, stopped ones only in HostConfig.
+func inspectPortBindings(raw containerInspectJSON) []PortBinding {
+	ports := raw.NetworkSettings.Ports
+	if len(ports) == 0 {
+		ports = raw.HostConfig.PortBindings
+	}
+
+	bindings := make([]PortBinding, 0, len(ports))
+	for spec, hostBindings := range ports {
+		containerPort, protocol := splitPortProtocol(spec)
+		if len(hostBindings) == 0 {
+			bindings = append(bindings, PortBinding{ContainerPort: containerPort, Protocol: protocol})
+			continue
+		}
+		for _, hb := range hostBindings {
+			bindings = append(bindings, PortBinding{
+				HostIP:        hb.HostIP,
+				HostPort:      hb.HostPort,
+				ContainerPort: containerPort,
+				Protocol:      protocol,
+			})
+		}
+	}
+	sortPortBindings(bindings)
+	return bindings
+}
+
+// parsePortsString parses the Ports column of `docker ps`, e.g.
+// "0.0.0.0:8080->80/tcp, :::8080->80/tcp, 443/tcp"
+func parsePortsString(ports string) []PortBinding {
+	bindings := []PortBinding{}
+	for _, mapping := range strings.Split(ports, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+
+		binding := PortBinding{}
+		containerPart := mapping
+		if idx := strings.Index(mapping, "->"); idx >= 0 {
+			hostPart := mapping[:idx]
+			containerPart = mapping[idx+2:]
+			// The host port follows the last colon, IPv6 addresses contain colons themselves
+			if colon := strings.LastIndex(hostPart, ":"); colon >= 0 {
+				binding.HostIP = strings.Trim(hostPart[:colon], "[]")
+				binding.HostPort = hostPart[colon+1:]
+			} else {
+				binding.HostPort = hostPart
+			}
+		}
+		binding.ContainerPort, binding.Protocol = splitPortProtocol(containerPart)
+		bindings = append(bindings, binding)
+	}
+	return bindings
+}
+
+// splitPortProtocol splits "80/tcp" into "80" and "tcp", defaulting to tcp
+func splitPortProtocol(spec string) (string, string) {
+	port, protocol, found := strings.Cut(spec, "/")
+	if !found || protocol == "" {
+		protocol = "tcp"
+	}
+	return port, protocol
+}
+
+func sortPortBindings(bindings []PortBinding) {
+	sort.Slice(bindings, func(i, j int) bool {
+		pi, _ := strconv.Atoi(strings.Split(bindings[i].ContainerPort, "-")[0])
+		pj, _ := strconv.Atoi(strings.Split(bindings[j].ContainerPort, "-")[0])
+		if pi != pj {
+			return pi < pj
+		}
+		if bindings[i].Protocol != bindings[j].Protocol {
+			return bindings[i].Protocol < bindings[j].Protocol
+		}
+		return bindings[i].HostIP < bindings[j].HostIP
+	})
+}
+
+// parseLabelsString parses the Labels column of `docker ps`, a comma joined
+// list of key=value pairs. Values may themselves contain commas, so a segment
+// without "=" is treated as a continuation of the previous value.
+func parseLabelsString(labels string) map[string]string {
+	result := make(map[string]string)
+	lastKey := ""
+	for _, pair := range strings.Split(labels, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			if lastKey != "" {
+				result[lastKey] += "," + pair
+			}
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		result[key] = value
+		lastKey = key
+	}
+	return result
+}
+
+// maskEnv hides the values of env variables whose name looks like a secret
+func maskEnv(env []string) []string {
+	masked := make([]string, 0, len(env))
+	for _, kv := range env {
+		key, value, found := strings.Cut(kv, "=")
+		if found && value != "" && secretEnvPattern.MatchString(key) {
+			kv = key + "=" + maskedValue
+		}
+		masked = append(masked, kv)
+	}
+	return masked
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePortsString(t *testing.T) {
+	got := parsePortsString("0.0.0.0:8080->80/tcp, :::8080->80/tcp, 443/tcp, 127.0.0.1:5353->53/udp")
+	want := []PortBinding{
+		{HostIP: "0.0.0.0", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"},
+		{HostIP: "::", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"},
+		{ContainerPort: "443", Protocol: "tcp"},
+		{HostIP: "127.0.0.1", HostPort: "5353", ContainerPort: "53", Protocol: "udp"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePortsString() = %+v, want %+v", got, want)
+	}
+
+	if got := parsePortsString(""); len(got) != 0 {
+		t.Errorf("parsePortsString(\"\") = %+v, want empty", got)
+	}
+}
+
+func TestParseLabelsString(t *testing.T) {
+	got := parseLabelsString("com.docker.compose.project=helios,traefik.rule=Host(`a`,`b`),empty=")
+	want := map[string]string{
+		"com.docker.compose.project": "helios",
+		"traefik.rule":               "Host(`a`,`b`)",
+		"empty":                      "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLabelsString() = %v, want %v", got, want)
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	got := maskEnv([]string{"PATH=/usr/bin", "PWD=/app", "OLDPWD=/", "AUTHOR=me", "DB_PASSWORD=hunter2", "GITHUB_TOKEN=abc",
+		"MYSQL_PWD=x", "BASIC_AUTH=u:p", "API_KEY=", "NOVALUE"})
+	want := []string{"PATH=/usr/bin", "PWD=/app", "OLDPWD=/", "AUTHOR=me", "DB_PASSWORD=" + maskedValue, "GITHUB_TOKEN=" + maskedValue,
+		"MYSQL_PWD=" + maskedValue, "BASIC_AUTH=" + maskedValue, "API_KEY=", "NOVALUE"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("maskEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestNewContainerDetails(t *testing.T) {
+	raw := `{
+		"Id": "abc123",
+		"Name": "/web",
+		"Image": "sha256:deadbeef",
+		"State": {"Status": "running", "StartedAt": "2024-01-01T00:00:00Z",
+			"Health": {"Status": "healthy", "FailingStreak": 0, "Log": [{"ExitCode": 0, "Output": "ok"}]}},
+		"Config": {"Image": "nginx:latest", "Env": ["SECRET_KEY=x"], "Labels": {"a": "b"}, "Cmd": ["nginx"]},
+		"HostConfig": {"RestartPolicy": {"Name": "always"}, "Memory": 536870912, "PidsLimit": 100},
+		"Mounts": [{"Type": "volume", "Name": "data", "Source": "/var/lib/docker/volumes/data/_data", "Destination": "/data", "RW": false}],
+		"NetworkSettings": {
+			"Ports": {"80/tcp": [{"HostIp": "0.0.0.0", "HostPort": "8080"}], "443/tcp": null},
+			"Networks": {"bridge": {"NetworkID": "n1", "IPAddress": "172.17.0.2"}}
+		}
+	}`
+	var inspected containerInspectJSON
+	if err := json.Unmarshal([]byte(raw), &inspected); err != nil {
+		t.Fatal(err)
+	}
+
+	details := newContainerDetails(inspected)
+	if details.Name != "web" || details.Image != "nginx:latest" || details.State != "running" {
+		t.Errorf("unexpected basic fields: %+v", details)
+	}
+	if details.Env[0] != "SECRET_KEY="+maskedValue {
+		t.Errorf("env not masked: %v", details.Env)
+	}
+	wantPorts := []PortBinding{
+		{HostIP: "0.0.0.0", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"},
+		{ContainerPort: "443", Protocol: "tcp"},
+	}
+	if !reflect.DeepEqual(details.Ports, wantPorts) {
+		t.Errorf("ports = %+v, want %+v", details.Ports, wantPorts)
+	}
+	if len(details.Mounts) != 1 || !details.Mounts[0].ReadOnly {
+		t.Errorf("unexpected mounts: %+v", details.Mounts)
+	}
+	if len(details.Networks) != 1 || details.Networks[0].IPAddress != "172.17.0.2" {
+		t.Errorf("unexpected networks: %+v", details.Networks)
+	}
+	if details.Health == nil || details.Health.Status != "healthy" || len(details.Health.Log) != 1 {
+		t.Errorf("unexpected health: %+v", details.Health)
+	}
+	if details.Limits.Memory != 536870912 || details.Limits.PidsLimit != 100 {
+		t.Errorf("unexpected limits: %+v", details.Limits)
+	}
+	if details.RestartPolicy.Name != "always" {
+		t.Errorf("unexpected restart policy: %+v", details.RestartPolicy)
+	}
+}
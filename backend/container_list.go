@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"remote-docker/utils"
+)
+
+// Container states accepted by `docker ps --filter status=`
+var containerStates = map[string]bool{
+	"created":    true,
+	"restarting": true,
+	"running":    true,
+	"removing":   true,
+	"paused":     true,
+	"exited":     true,
+	"dead":       true,
+}
+
+// Health states accepted by `docker ps --filter health=`
+var containerHealthStates = map[string]bool{
+	"starting":  true,
+	"healthy":   true,
+	"unhealthy": true,
+	"none":      true,
+}
+
+// Request for the container listing
+type ContainerListRequest struct {
+	Hostname       string   `json:"hostname"`
+	Username       string   `json:"username"`
+	RunningOnly    bool     `json:"runningOnly"`    // Only list running containers, like plain `docker ps`
+	States         []string `json:"states"`         // Any of containerStates
+	Name           string   `json:"name"`           // Name substring
+	Image          string   `json:"image"`          // Image or ancestor image
+	Labels         []string `json:"labels"`         // "key" or "key=value", all must match
+	ComposeProject string   `json:"composeProject"` // Only containers of this project
+	Health         string   `json:"health"`         // Any of containerHealthStates
+	SortBy         string   `json:"sortBy"`         // name, image, state, created, project
+	SortDesc       bool     `json:"sortDesc"`
+	Page           int      `json:"page"`     // 1-based, ignored when PageSize is 0
+	PageSize       int      `json:"pageSize"` // 0 returns all containers
+}
+
+// Per-state container counts of a compose group
+type GroupStateCounts struct {
+	Running    int `json:"running"`
+	Restarting int `json:"restarting"`
+	Paused     int `json:"paused"`
+	Dead       int `json:"dead"`
+	Stopped    int `json:"stopped"` // exited or created
+}
+
+// buildContainerListCommand validates the filters of a listing request and
+// turns them into a `docker ps` command
+func buildContainerListCommand(req ContainerListRequest) (string, error) {
+	args := []string{"ps", "--no-trunc"}
+	if !req.RunningOnly {
+		args = append(args, "-a")
+	}
+
+	for _, state := range req.States {
+		if !containerStates[state] {
+			return "", fmt.Errorf("invalid state filter: %s", state)
+		}
+		args = append(args, "--filter", "status="+state)
+	}
+	if req.Name != "" {
+		if err := utils.ValidateContainerID(req.Name); err != nil {
+			return "", fmt.Errorf("invalid name filter: %v", err)
+		}
+		args = append(args, "--filter", "name="+req.Name)
+	}
+	if req.Image != "" {
+		if err := utils.ValidateImageName(req.Image); err != nil {
+			return "", fmt.Errorf("invalid image filter: %v", err)
+		}
+		args = append(args, "--filter", "ancestor="+req.Image)
+	}
+	for _, label := range req.Labels {
+		if err := utils.ValidateLabelFilter(label); err != nil {
+			return "", fmt.Errorf("invalid label filter: %v", err)
+		}
+		args = append(args, "--filter", "label="+label)
+	}
+	if req.ComposeProject != "" {
+		if err := utils.ValidateComposeProjectName(req.ComposeProject); err != nil {
+			return "", fmt.Errorf("invalid compose project filter: %v", err)
+		}
+		args = append(args, "--filter", "label="+composeProjectLabel+"="+req.ComposeProject)
+	}
+	if req.Health != "" {
+		if !containerHealthStates[req.Health] {
+			return "", fmt.Errorf("invalid health filter: %s", req.Health)
+		}
+		args = append(args, "--filter", "health="+req.Health)
+	}
+
+	// Labels go last, they are the only column that may contain the separator
+	args = append(args, "--format", "{{.ID}}|{{.Names}}|{{.Image}}|{{.Status}}|{{.State}}|{{.CreatedAt}}|{{.Ports}}|{{.Labels}}")
+	return utils.BuildDockerCommand(args...), nil
+}
+
+// healthFromStatus extracts the health state docker ps appends to the status,
+// e.g. "Up 2 hours (healthy)" or "Up 5 seconds (health: starting)"
+func healthFromStatus(status string) string {
+	switch {
+	case strings.Contains(status, "(healthy)"):
+		return "healthy"
+	case strings.Contains(status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.Contains(status, "(health: starting)"):
+		return "starting"
+	}
+	return ""
+}
+
+// sortContainers orders containers by the requested key, name by default
+func sortContainers(containers []DockerContainer, sortBy string, desc bool) error {
+	var key func(c DockerContainer) string
+	switch sortBy {
+	case "", "name":
+		key = func(c DockerContainer) string { return c.Name }
+	case "image":
+		key = func(c DockerContainer) string { return c.Image }
+	case "state":
+		key = func(c DockerContainer) string { return c.State }
+	case "created":
+		key = func(c DockerContainer) string { return c.CreatedAt }
+	case "project":
+		key = func(c DockerContainer) string { return c.ComposeProject }
+	default:
+		return fmt.Errorf("invalid sort key: %s", sortBy)
+	}
+
+	sort.SliceStable(containers, func(i, j int) bool {
+		ki, kj := key(containers[i]), key(containers[j])
+		if ki == kj {
+			return containers[i].Name < containers[j].Name
+		}
+		if desc {
+			return ki > kj
+		}
+		return ki < kj
+	})
+	return nil
+}
+
+// paginateContainers returns the requested page of an already sorted list
+func paginateContainers(containers []DockerContainer, page, pageSize int) []DockerContainer {
+	if pageSize <= 0 {
+		return containers
+	}
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * pageSize
+	if start >= len(containers) {
+		return []DockerContainer{}
+	}
+	end := start + pageSize
+	if end > len(containers) {
+		end = len(containers)
+	}
+	return containers[start:end]
+}
+
+// countGroupStates tallies the containers of a group by state
+func countGroupStates(containers []DockerContainer) GroupStateCounts {
+	var counts GroupStateCounts
+	for _, c := range containers {
+		switch c.State {
+		case "running":
+			counts.Running++
+		case "restarting":
+			counts.Restarting++
+		case "paused":
+			counts.Paused++
+		case "dead":
+			counts.Dead++
+		default:
+			counts.Stopped++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildContainerListCommand(t *testing.T) {
+	cmd, err := buildContainerListCommand(ContainerListRequest{
+		States:         []string{"running", "exited"},
+		ComposeProject: "helios",
+		Labels:         []string{"tier=web"},
+		Health:         "unhealthy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{" -a ", "status=running", "status=exited", "label=com.docker.compose.project=helios", "label=tier=web", "health=unhealthy"} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("command %q does not contain %q", cmd, want)
+		}
+	}
+
+	cmd, err = buildContainerListCommand(ContainerListRequest{RunningOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cmd, " -a ") {
+		t.Errorf("running only command should not list all containers: %q", cmd)
+	}
+
+	invalid := []ContainerListRequest{
+		{States: []string{"sleeping"}},
+		{Health: "fine"},
+		{Name: "x; rm -rf /"},
+		{Labels: []string{"=value"}},
+		{ComposeProject: "../etc"},
+	}
+	for _, req := range invalid {
+		if _, err := buildContainerListCommand(req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+}
+
+func TestSortAndPaginateContainers(t *testing.T) {
+	containers := []DockerContainer{
+		{Name: "c", State: "running"},
+		{Name: "a", State: "exited"},
+		{Name: "b", State: "running"},
+	}
+	if err := sortContainers(containers, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if containers[0].Name != "a" || containers[2].Name != "c" {
+		t.Errorf("unexpected order: %+v", containers)
+	}
+	if err := sortContainers(containers, "state", true); err != nil {
+		t.Fatal(err)
+	}
+	if containers[0].State != "running" || containers[2].State != "exited" {
+		t.Errorf("unexpected order: %+v", containers)
+	}
+	if err := sortContainers(containers, "bogus", false); err == nil {
+		t.Error("expected error for invalid sort key")
+	}
+
+	page := paginateContainers(containers, 2, 2)
+	if len(page) != 1 {
+		t.Errorf("expected 1 container on page 2, got %d", len(page))
+	}
+	if got := paginateContainers(containers, 5, 2); len(got) != 0 {
+		t.Errorf("expected empty page, got %+v", got)
+	}
+	if got := paginateContainers(containers, 0, 0); len(got) != 3 {
+		t.Errorf("expected all containers without page size, got %d", len(got))
+	}
+}
+
+func TestComputeGroupStatus(t *testing.T) {
+	tests := []struct {
+		states []string
+		want   string
+	}{
+		{[]string{"running", "running"}, "Running(2)"},
+		{[]string{"exited", "created"}, "Stopped(2)"},
+		{[]string{"restarting"}, "Restarting(1)"},
+		{[]string{"running", "exited", "dead"}, "Partial(1/3)"},
+		{[]string{"paused", "exited"}, "Partial(0/2)"},
+	}
+	for _, tt := range tests {
+		containers := make([]DockerContainer, 0, len(tt.states))
+		for _, s := range tt.states {
+			containers = append(containers, DockerContainer{State: s})
+		}
+		if got := computeGroupStatus(containers); got != tt.want {
+			t.Errorf("computeGroupStatus(%v) = %s, want %s", tt.states, got, tt.want)
+		}
+	}
+
+	counts := countGroupStates([]DockerContainer{{State: "running"}, {State: "paused"}, {State: "dead"}, {State: "restarting"}, {State: "exited"}})
+	if counts != (GroupStateCounts{Running: 1, Restarting: 1, Paused: 1, Dead: 1, Stopped: 1}) {
+		t.Errorf("unexpected counts: %+v", counts)
+	}
+}
@@ -0,0 +1,748 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Recreate operation states
+const (
+	recreateVerifying      = "verifying"       // new container started, old one kept for rollback
+	recreateCompleted      = "completed"       // new container passed verification, old one removed
+	recreateFailed         = "failed"          // new container failed verification, old one kept
+	recreateRollingBack    = "rolling-back"    // rollback in progress
+	recreateRolledBack     = "rolled-back"     // old container restored
+	recreateRollbackFailed = "rollback-failed" // rollback did not finish, see message
+)
+
+// Full container ID as printed by docker create
+var containerIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Request to recreate a container with a new image
+type RecreateRequest struct {
+	Hostname    string `json:"hostname"`
+	Username    string `json:"username"`
+	ContainerId string `json:"containerId"`
+	Image       string `json:"image"`       // new image reference, defaults to the current one
+	Pull        bool   `json:"pull"`        // pull the image before recreating
+	GracePeriod int    `json:"gracePeriod"` // seconds the new container must stay up (or become healthy), default 30
+}
+
+// Request referring to a recreate operation
+type RecreateOperationRequest struct {
+	OperationId string `json:"operationId"`
+}
+
+// A recreate and its rollback state
+type RecreateOperation struct {
+	ID             string `json:"id"`
+	Hostname       string `json:"hostname"`
+	Username       string `json:"username"`
+	ContainerName  string `json:"containerName"`
+	OldContainerID string `json:"oldContainerId"`
+	RollbackName   string `json:"rollbackName"` // name of the old container while it is kept
+	NewContainerID string `json:"newContainerId"`
+	OldImage       string `json:"oldImage"`
+	NewImage       string `json:"newImage"`
+	WasRunning     bool   `json:"wasRunning"`
+	Status         string `json:"status"`
+	Message        string `json:"message,omitempty"`
+	StartedAt      string `json:"startedAt"`
+	FinishedAt     string `json:"finishedAt,omitempty"`
+
+	cancel   context.CancelFunc
+	finished time.Time
+}
+
+// In-memory registry of recreate operations
+type recreateStore struct {
+	mu  sync.Mutex
+	ops map[string]*RecreateOperation
+}
+
+var recreateOps = &recreateStore{ops: make(map[string]*RecreateOperation)}
+
+// get returns a copy of an operation
+func (s *recreateStore) get(id string) (RecreateOperation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.ops[id]
+	if !ok {
+		return RecreateOperation{}, false
+	}
+	return *op, true
+}
+
+// transition moves an operation to a new status if it is currently in one of from
+func (s *recreateStore) transition(id string, from []string, to, message string) (RecreateOperation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.ops[id]
+	if !ok {
+		return RecreateOperation{}, false
+	}
+	for _, status := range from {
+		if op.Status == status {
+			op.Status = to
+			op.Message = message
+			if to != recreateVerifying && to != recreateRollingBack {
+				op.finished = time.Now()
+				op.FinishedAt = op.finished.Format(time.RFC3339)
+			}
+			return *op, true
+		}
+	}
+	return *op, false
+}
+
+// pruneLocked drops operations that finished longer than the retention ago
+func (s *recreateStore) pruneLocked() {
+	for id, op := range s.ops {
+		if !op.finished.IsZero() && time.Since(op.finished) > operationRetention {
+			delete(s.ops, id)
+		}
+	}
+}
+
+// Recreate a standalone container with a new image, keeping its configuration
+func recreateContainer(ctx echo.Context) error {
+	var req RecreateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateContainerRequest(ContainerRequest{Hostname: req.Hostname, Username: req.Username, ContainerId: req.ContainerId}); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Image != "" {
+		if err := utils.ValidateImageName(req.Image); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid image name: %v", err)})
+		}
+	}
+	grace := 30 * time.Second
+	if req.GracePeriod > 0 {
+		if req.GracePeriod > 600 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Grace period must be at most 600 seconds"})
+		}
+		grace = time.Duration(req.GracePeriod) * time.Second
+	}
+
+	inspected, output, err := inspectContainers(req.Username, req.Hostname, req.ContainerId)
+	if err != nil || len(inspected) == 0 {
+		logger.Errorf("Error inspecting container: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect container: %v", err),
+			"output": string(output),
+		})
+	}
+	raw := inspected[0]
+	if project := raw.Config.Labels[composeProjectLabel]; project != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Container belongs to compose project %s, update it through compose instead", project),
+		})
+	}
+
+	name := strings.TrimPrefix(raw.Name, "/")
+	newImage := req.Image
+	if newImage == "" {
+		newImage = raw.Config.Image
+	}
+
+	if req.Pull {
+		pullCommand := utils.BuildDockerCommand("pull", newImage)
+		logger.Infof("Executing pull command: %s", pullCommand)
+		if output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, pullCommand); err != nil {
+			logger.Errorf("Error pulling image: %v, output: %s", err, string(output))
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error":  fmt.Sprintf("Failed to pull image: %v", err),
+				"output": string(output),
+			})
+		}
+	}
+
+	// Settings inherited from the old image must not be pinned on the new container
+	images, output, err := inspectImages(req.Username, req.Hostname, raw.Image)
+	if err != nil || len(images) == 0 {
+		logger.Errorf("Error inspecting image: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect current image: %v", err),
+			"output": string(output),
+		})
+	}
+
+	createArgs, cmdArgs, connects, err := buildRecreateArgs(raw, images[0])
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Cannot recreate container: %v", err)})
+	}
+	op := &RecreateOperation{
+		ID:             fmt.Sprintf("recreate-%d", time.Now().UnixNano()),
+		Hostname:       req.Hostname,
+		Username:       req.Username,
+		ContainerName:  name,
+		OldContainerID: raw.ID,
+		RollbackName:   rollbackName(name, time.Now()),
+		OldImage:       raw.Config.Image,
+		NewImage:       newImage,
+		WasRunning:     raw.State.Running,
+		StartedAt:      time.Now().Format(time.RFC3339),
+	}
+
+	if err := swapContainer(op, createArgs, cmdArgs, connects); err != nil {
+		logger.Errorf("Error recreating container %s: %v", name, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to recreate container: %v", err),
+		})
+	}
+
+	verifyCtx, cancel := context.WithCancel(context.Background())
+	op.cancel = cancel
+	op.Status = recreateVerifying
+	recreateOps.mu.Lock()
+	recreateOps.pruneLocked()
+	recreateOps.ops[op.ID] = op
+	snapshot := *op
+	recreateOps.mu.Unlock()
+
+	utils.SafeGo(logger, "verifyRecreate "+op.ID, func() {
+		defer cancel()
+		verifyRecreate(verifyCtx, op.ID, grace)
+	})
+
+	return ctx.JSON(http.StatusOK, snapshot)
+}
+
+// Get the state of a recreate operation
+func getRecreateStatus(ctx echo.Context) error {
+	var req RecreateOperationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	op, ok := recreateOps.get(req.OperationId)
+	if !ok {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Operation not found"})
+	}
+	return ctx.JSON(http.StatusOK, op)
+}
+
+// Roll a recreate back to the old container
+func rollbackRecreate(ctx echo.Context) error {
+	var req RecreateOperationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	op, ok := recreateOps.transition(req.OperationId, []string{recreateVerifying, recreateFailed}, recreateRollingBack, "")
+	if !ok {
+		if op.ID == "" {
+			return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Operation not found"})
+		}
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Operation is %s, the old container is no longer available", op.Status),
+		})
+	}
+	if op.cancel != nil {
+		op.cancel()
+	}
+
+	steps := [][]string{
+		{"rm", "-f", op.NewContainerID},
+		{"rename", op.RollbackName, op.ContainerName},
+	}
+	if op.WasRunning {
+		steps = append(steps, []string{"start", op.ContainerName})
+	}
+	for _, step := range steps {
+		dockerCommand := utils.BuildDockerCommand(step...)
+		logger.Infof("Executing rollback command: %s", dockerCommand)
+		if output, err := tunnelManager.ExecuteCommand(op.Username, op.Hostname, dockerCommand); err != nil {
+			message := fmt.Sprintf("%s failed: %v: %s", step[0], err, strings.TrimSpace(string(output)))
+			recreateOps.transition(op.ID, []string{recreateRollingBack}, recreateRollbackFailed, message)
+			logger.Errorf("Error rolling back %s: %s", op.ContainerName, message)
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error":  fmt.Sprintf("Failed to roll back: %v", err),
+				"output": string(output),
+			})
+		}
+	}
+
+	op, _ = recreateOps.transition(op.ID, []string{recreateRollingBack}, recreateRolledBack, "Old container restored")
+	return ctx.JSON(http.StatusOK, op)
+}
+
+// rollbackName is the name the old container is kept under until the new one is verified
+func rollbackName(name string, now time.Time) string {
+	return fmt.Sprintf("%s-rollback-%d", name, now.Unix())
+}
+
+// swapContainer stops and renames the old container and starts its
+// replacement. If the replacement cannot be started the old container is
+// restored before returning the error.
+func swapContainer(op *RecreateOperation, createArgs, cmdArgs []string, connects [][]string) error {
+	run := func(args ...string) (string, error) {
+		dockerCommand := utils.BuildDockerCommand(args...)
+		logger.Infof("Executing recreate command: %s", dockerCommand)
+		output, err := tunnelManager.ExecuteCommand(op.Username, op.Hostname, dockerCommand)
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if op.WasRunning {
+		if _, err := run("stop", op.OldContainerID); err != nil {
+			return err
+		}
+	}
+	if _, err := run("rename", op.OldContainerID, op.RollbackName); err != nil {
+		if op.WasRunning {
+			run("start", op.OldContainerID)
+		}
+		return err
+	}
+
+	restore := func(cause error) error {
+		// Without an ID the replacement may still exist under the original
+		// name, which is free otherwise since the old container was renamed
+		leftover := op.NewContainerID
+		if leftover == "" {
+			leftover = op.ContainerName
+		}
+		if _, err := run("rm", "-f", leftover); err != nil {
+			logger.Warnf("Removing new container %s failed: %v", leftover, err)
+		}
+		if _, err := run("rename", op.OldContainerID, op.ContainerName); err != nil {
+			return fmt.Errorf("%v; restoring the old container failed as well: %v", cause, err)
+		}
+		if op.WasRunning {
+			if _, err := run("start", op.OldContainerID); err != nil {
+				return fmt.Errorf("%v; restarting the old container failed as well: %v", cause, err)
+			}
+		}
+		return cause
+	}
+
+	// Pulling is up to the explicit pull step, a pull here would mix its
+	// progress into the output the ID is read from
+	args := append([]string{"create", "--pull", "never", "--name", op.ContainerName}, createArgs...)
+	args = append(append(args, op.NewImage), cmdArgs...)
+	output, err := run(args...)
+	if err != nil {
+		return restore(err)
+	}
+	newID, err := parseCreatedContainerID(output)
+	if err != nil {
+		return restore(err)
+	}
+	op.NewContainerID = newID
+
+	for _, connect := range connects {
+		if _, err := run(append(connect, op.NewContainerID)...); err != nil {
+			return restore(err)
+		}
+	}
+	if _, err := run("start", op.NewContainerID); err != nil {
+		return restore(err)
+	}
+	return nil
+}
+
+// parseCreatedContainerID reads the ID docker create prints last. Warnings,
+// e.g. about a platform mismatch, end up in the same output before it.
+func parseCreatedContainerID(output string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	id := strings.TrimSpace(lines[len(lines)-1])
+	if !containerIDPattern.MatchString(id) {
+		return "", fmt.Errorf("create printed no container ID: %s", output)
+	}
+	return id, nil
+}
+
+// verifyRecreate waits for the new container to become healthy, or to stay
+// up for the grace period, and then removes the old container
+func verifyRecreate(ctx context.Context, id string, grace time.Duration) {
+	op, ok := recreateOps.get(id)
+	if !ok {
+		return
+	}
+
+	deadline := time.Now().Add(grace)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		state, health, err := containerStateAndHealth(op.Username, op.Hostname, op.NewContainerID)
+		failure := ""
+		passed := false
+		switch {
+		case err != nil:
+			// transient errors are retried until the deadline
+		case health == "healthy":
+			passed = true
+		case health == "unhealthy":
+			failure = "New container reported unhealthy"
+		case state == "exited" || state == "dead":
+			failure = fmt.Sprintf("New container is %s", state)
+		case time.Now().After(deadline) && state == "running" && health == "":
+			passed = true
+		}
+		if !passed && failure == "" && time.Now().After(deadline) {
+			failure = "New container did not pass verification within the grace period"
+		}
+
+		if failure != "" {
+			recreateOps.transition(id, []string{recreateVerifying}, recreateFailed, failure+", old container kept for rollback")
+			logger.Warnf("Recreate %s failed verification: %s", id, failure)
+			return
+		}
+		if passed {
+			if _, ok := recreateOps.transition(id, []string{recreateVerifying}, recreateCompleted, "New container verified"); !ok {
+				return
+			}
+			dockerCommand := utils.BuildDockerCommand("rm", op.OldContainerID)
+			if output, err := tunnelManager.ExecuteCommand(op.Username, op.Hostname, dockerCommand); err != nil {
+				logger.Warnf("Failed to remove old container %s: %v, output: %s", op.RollbackName, err, string(output))
+				recreateOps.mu.Lock()
+				if current, ok := recreateOps.ops[id]; ok {
+					current.Message = fmt.Sprintf("New container verified, but removing %s failed: %v", op.RollbackName, err)
+				}
+				recreateOps.mu.Unlock()
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// containerStateAndHealth returns the state and health status (empty without healthcheck)
+func containerStateAndHealth(username, hostname, containerID string) (string, string, error) {
+	inspected, _, err := inspectContainers(username, hostname, containerID)
+	if err != nil {
+		return "", "", err
+	}
+	if len(inspected) == 0 {
+		return "", "", fmt.Errorf("container %s not found", containerID)
+	}
+	health := ""
+	if inspected[0].State.Health != nil {
+		health = inspected[0].State.Health.Status
+	}
+	return inspected[0].State.Status, health, nil
+}
+
+// buildRecreateArgs turns the inspected configuration back into docker create
+// flags. Settings the container inherited from its image are left out so the
+// new image can provide its own. Returns the create flags, the trailing
+// command arguments and the `docker network connect` calls for additional networks.
+func buildRecreateArgs(raw containerInspectJSON, image imageInspectJSON) ([]string, []string, [][]string, error) {
+	var args []string
+
+	for _, kv := range containerOnlyEnv(raw, image) {
+		args = append(args, "-e", kv)
+	}
+	labels := containerOnlyLabels(raw, image)
+	for _, key := range sortedKeys(labels) {
+		args = append(args, "-l", key+"="+labels[key])
+	}
+	for _, spec := range publishSpecs(raw) {
+		args = append(args, "-p", spec)
+	}
+	for _, m := range raw.Mounts {
+		args = append(args, "--mount", mountSpec(m.Type, m.Name, m.Source, m.Destination, m.Driver, !m.RW, m.Propagation))
+	}
+	for _, dest := range sortedKeys(raw.HostConfig.Tmpfs) {
+		spec := dest
+		if opts := raw.HostConfig.Tmpfs[dest]; opts != "" {
+			spec += ":" + opts
+		}
+		args = append(args, "--tmpfs", spec)
+	}
+
+	if policy := raw.HostConfig.RestartPolicy; policy.Name != "" && policy.Name != "no" {
+		value := policy.Name
+		if policy.Name == "on-failure" && policy.MaximumRetryCount > 0 {
+			value += ":" + strconv.Itoa(policy.MaximumRetryCount)
+		}
+		args = append(args, "--restart", value)
+	}
+
+	limits := inspectLimits(raw)
+	if limits.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(limits.Memory, 10))
+	}
+	if limits.MemorySwap != 0 {
+		args = append(args, "--memory-swap", strconv.FormatInt(limits.MemorySwap, 10))
+	}
+	if limits.MemoryReservation > 0 {
+		args = append(args, "--memory-reservation", strconv.FormatInt(limits.MemoryReservation, 10))
+	}
+	if limits.NanoCPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(float64(limits.NanoCPUs)/1e9, 'f', -1, 64))
+	}
+	if limits.CPUShares > 0 {
+		args = append(args, "--cpu-shares", strconv.FormatInt(limits.CPUShares, 10))
+	}
+	if limits.CPUPeriod > 0 {
+		args = append(args, "--cpu-period", strconv.FormatInt(limits.CPUPeriod, 10))
+	}
+	if limits.CPUQuota > 0 {
+		args = append(args, "--cpu-quota", strconv.FormatInt(limits.CPUQuota, 10))
+	}
+	if limits.CpusetCpus != "" {
+		args = append(args, "--cpuset-cpus", limits.CpusetCpus)
+	}
+	if limits.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(limits.PidsLimit, 10))
+	}
+
+	if raw.Config.User != "" && raw.Config.User != image.Config.User {
+		args = append(args, "--user", raw.Config.User)
+	}
+	if raw.Config.WorkingDir != "" && raw.Config.WorkingDir != image.Config.WorkingDir {
+		args = append(args, "--workdir", raw.Config.WorkingDir)
+	}
+	if raw.HostConfig.Privileged {
+		args = append(args, "--privileged")
+	}
+	for _, capability := range raw.HostConfig.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	for _, capability := range raw.HostConfig.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, host := range raw.HostConfig.ExtraHosts {
+		args = append(args, "--add-host", host)
+	}
+	if logConfig := raw.HostConfig.LogConfig; logConfig.Type != "" {
+		args = append(args, "--log-driver", logConfig.Type)
+		for _, key := range sortedKeys(logConfig.Config) {
+			args = append(args, "--log-opt", key+"="+logConfig.Config[key])
+		}
+	}
+	if hc := raw.Config.Healthcheck; hc != nil && !reflect.DeepEqual(hc, image.Config.Healthcheck) {
+		healthArgs, err := healthcheckArgs(*hc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		args = append(args, healthArgs...)
+	}
+
+	networkArgs, connects := networkArgs(raw)
+	args = append(args, networkArgs...)
+
+	// docker create only takes the first entrypoint element as a flag, the
+	// rest is passed in front of the command
+	var cmdArgs []string
+	if !reflect.DeepEqual(raw.Config.Entrypoint, image.Config.Entrypoint) {
+		if len(raw.Config.Entrypoint) > 0 {
+			args = append(args, "--entrypoint", raw.Config.Entrypoint[0])
+			cmdArgs = append(cmdArgs, raw.Config.Entrypoint[1:]...)
+		} else {
+			args = append(args, "--entrypoint", "")
+		}
+		cmdArgs = append(cmdArgs, raw.Config.Cmd...)
+	} else if !reflect.DeepEqual(raw.Config.Cmd, image.Config.Cmd) {
+		cmdArgs = append(cmdArgs, raw.Config.Cmd...)
+	}
+
+	return args, cmdArgs, connects, nil
+}
+
+// containerOnlyEnv returns the env entries that were set on the container
+// rather than inherited unchanged from its image
+func containerOnlyEnv(raw containerInspectJSON, image imageInspectJSON) []string {
+	fromImage := make(map[string]bool, len(image.Config.Env))
+	for _, kv := range image.Config.Env {
+		fromImage[kv] = true
+	}
+	env := []string{}
+	for _, kv := range raw.Config.Env {
+		if !fromImage[kv] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// containerOnlyLabels returns the labels that were set on the container
+// rather than inherited unchanged from its image
+func containerOnlyLabels(raw containerInspectJSON, image imageInspectJSON) map[string]string {
+	labels := make(map[string]string)
+	for key, value := range raw.Config.Labels {
+		if imageValue, ok := image.Config.Labels[key]; ok && imageValue == value {
+			continue
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+// publishSpecs formats the configured port bindings as `-p` values, e.g.
+// "127.0.0.1:8080:80/tcp". The same format is valid in compose files.
+func publishSpecs(raw containerInspectJSON) []string {
+	specs := []string{}
+	for _, port := range sortedKeys(raw.HostConfig.PortBindings) {
+		for _, binding := range raw.HostConfig.PortBindings[port] {
+			spec := port
+			switch {
+			case binding.HostIP != "":
+				hostIP := binding.HostIP
+				if strings.Contains(hostIP, ":") {
+					hostIP = "[" + hostIP + "]"
+				}
+				spec = hostIP + ":" + binding.HostPort + ":" + port
+			case binding.HostPort != "":
+				spec = binding.HostPort + ":" + port
+			}
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// mountSpec formats a mount as a `--mount` value
+func mountSpec(mountType, name, source, destination, driver string, readOnly bool, propagation string) string {
+	parts := []string{"type=" + mountType}
+	switch mountType {
+	case "volume":
+		parts = append(parts, "src="+name)
+		if driver != "" && driver != "local" {
+			parts = append(parts, "volume-driver="+driver)
+		}
+	case "bind":
+		parts = append(parts, "src="+source)
+		if propagation != "" && propagation != "rprivate" {
+			parts = append(parts, "bind-propagation="+propagation)
+		}
+	}
+	parts = append(parts, "dst="+destination)
+	if readOnly {
+		parts = append(parts, "readonly")
+	}
+	return strings.Join(parts, ",")
+}
+
+// healthcheckArgs formats a healthcheck as docker create flags. --health-cmd
+// always creates a CMD-SHELL check, so an exec-form CMD check cannot be
+// carried over: it would start needing /bin/sh, which distroless and scratch
+// images do not have.
+func healthcheckArgs(hc inspectHealthcheck) ([]string, error) {
+	if len(hc.Test) == 0 {
+		return nil, nil
+	}
+	var args []string
+	switch hc.Test[0] {
+	case "NONE":
+		return []string{"--no-healthcheck"}, nil
+	case "CMD-SHELL":
+		args = append(args, "--health-cmd", strings.Join(hc.Test[1:], " "))
+	case "CMD":
+		return nil, fmt.Errorf("the container has an exec-form healthcheck (%s), which docker create can only set as a shell command; recreate it with compose or docker run instead", strings.Join(hc.Test[1:], " "))
+	default:
+		return nil, fmt.Errorf("unsupported healthcheck type %s", hc.Test[0])
+	}
+	if hc.Interval > 0 {
+		args = append(args, "--health-interval", time.Duration(hc.Interval).String())
+	}
+	if hc.Timeout > 0 {
+		args = append(args, "--health-timeout", time.Duration(hc.Timeout).String())
+	}
+	if hc.StartPeriod > 0 {
+		args = append(args, "--health-start-period", time.Duration(hc.StartPeriod).String())
+	}
+	if hc.Retries > 0 {
+		args = append(args, "--health-retries", strconv.Itoa(hc.Retries))
+	}
+	return args, nil
+}
+
+// networkArgs returns the flags for the primary network and the connect
+// commands for every other network the container is attached to
+func networkArgs(raw containerInspectJSON) ([]string, [][]string) {
+	mode := raw.HostConfig.NetworkMode
+	if mode == "" || mode == "default" {
+		mode = "bridge"
+	}
+
+	var args []string
+	if mode != "bridge" {
+		args = append(args, "--network", mode)
+	}
+	if mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:") {
+		return args, nil
+	}
+
+	attachment := func(name string) (aliases []string, ipv4, ipv6 string) {
+		n, ok := raw.NetworkSettings.Networks[name]
+		if !ok {
+			return nil, "", ""
+		}
+		for _, alias := range n.Aliases {
+			// docker adds the short container ID itself
+			if !strings.HasPrefix(raw.ID, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+		if n.IPAMConfig != nil {
+			ipv4, ipv6 = n.IPAMConfig.IPv4Address, n.IPAMConfig.IPv6Address
+		}
+		return aliases, ipv4, ipv6
+	}
+
+	aliases, ipv4, ipv6 := attachment(mode)
+	for _, alias := range aliases {
+		args = append(args, "--network-alias", alias)
+	}
+	if ipv4 != "" {
+		args = append(args, "--ip", ipv4)
+	}
+	if ipv6 != "" {
+		args = append(args, "--ip6", ipv6)
+	}
+
+	var connects [][]string
+	for _, name := range sortedKeys(raw.NetworkSettings.Networks) {
+		if name == mode {
+			continue
+		}
+		connect := []string{"network", "connect"}
+		aliases, ipv4, ipv6 := attachment(name)
+		for _, alias := range aliases {
+			connect = append(connect, "--alias", alias)
+		}
+		if ipv4 != "" {
+			connect = append(connect, "--ip", ipv4)
+		}
+		if ipv6 != "" {
+			connect = append(connect, "--ip6", ipv6)
+		}
+		connects = append(connects, append(connect, name))
+	}
+	return args, connects
+}
+
+// sortedKeys returns the keys of a string keyed map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Trimmed `docker inspect` output of a standalone container
+const recreateContainerFixture = `{
+	"Id": "f00dfeed00000000000000000000000000000000000000000000000000000001",
+	"Name": "/api",
+	"Image": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	"State": {"Status": "running", "Running": true},
+	"Config": {
+		"User": "app",
+		"WorkingDir": "/srv",
+		"Env": ["PATH=/usr/local/bin:/usr/bin", "LANG=C.UTF-8", "DB_HOST=db", "LOG_LEVEL=debug"],
+		"Cmd": ["serve", "--port", "8080"],
+		"Entrypoint": ["/app/api"],
+		"Image": "registry.local/api:1.4",
+		"Labels": {"org.opencontainers.image.version": "1.4", "team": "payments"},
+		"Healthcheck": {"Test": ["CMD-SHELL", "curl -fs localhost:8080/health || exit 1"], "Interval": 10000000000, "Retries": 3}
+	},
+	"HostConfig": {
+		"NetworkMode": "backend",
+		"RestartPolicy": {"Name": "on-failure", "MaximumRetryCount": 5},
+		"PortBindings": {
+			"8080/tcp": [{"HostIp": "127.0.0.1", "HostPort": "18080"}],
+			"9090/tcp": [{"HostIp": "", "HostPort": "9090"}]
+		},
+		"Memory": 268435456,
+		"NanoCpus": 1500000000,
+		"PidsLimit": 200,
+		"CapAdd": ["NET_ADMIN"],
+		"ExtraHosts": ["db.internal:10.0.0.5"],
+		"Tmpfs": {"/tmp": "rw,size=64m"},
+		"LogConfig": {"Type": "json-file", "Config": {"max-size": "10m", "max-file": "3"}}
+	},
+	"Mounts": [
+		{"Type": "volume", "Name": "api_data", "Source": "/var/lib/docker/volumes/api_data/_data", "Destination": "/var/lib/api", "Driver": "local", "RW": true},
+		{"Type": "bind", "Source": "/etc/api", "Destination": "/etc/api", "RW": false, "Propagation": "rprivate"}
+	],
+	"NetworkSettings": {
+		"Networks": {
+			"backend": {"NetworkID": "n1", "Aliases": ["f00dfeed0000", "api"], "IPAMConfig": {"IPv4Address": "172.30.0.10"}},
+			"monitoring": {"NetworkID": "n2", "Aliases": ["api-metrics"]}
+		}
+	}
+}`
+
+// Trimmed `docker image inspect` output of the container's image
+const recreateImageFixture = `{
+	"Id": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	"Config": {
+		"User": "app",
+		"WorkingDir": "/srv",
+		"Env": ["PATH=/usr/local/bin:/usr/bin", "LANG=C.UTF-8"],
+		"Cmd": ["serve"],
+		"Entrypoint": ["/app/api"],
+		"Labels": {"org.opencontainers.image.version": "1.4"}
+	}
+}`
+
+func loadRecreateFixtures(t *testing.T) (containerInspectJSON, imageInspectJSON) {
+	var raw containerInspectJSON
+	if err := json.Unmarshal([]byte(recreateContainerFixture), &raw); err != nil {
+		t.Fatal(err)
+	}
+	var image imageInspectJSON
+	if err := json.Unmarshal([]byte(recreateImageFixture), &image); err != nil {
+		t.Fatal(err)
+	}
+	return raw, image
+}
+
+func TestBuildRecreateArgs(t *testing.T) {
+	raw, image := loadRecreateFixtures(t)
+
+	args, cmdArgs, connects, err := buildRecreateArgs(raw, image)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"-e", "DB_HOST=db",
+		"-e", "LOG_LEVEL=debug",
+		"-l", "team=payments",
+		"-p", "127.0.0.1:18080:8080/tcp",
+		"-p", "9090:9090/tcp",
+		"--mount", "type=volume,src=api_data,dst=/var/lib/api",
+		"--mount", "type=bind,src=/etc/api,dst=/etc/api,readonly",
+		"--tmpfs", "/tmp:rw,size=64m",
+		"--restart", "on-failure:5",
+		"--memory", "268435456",
+		"--cpus", "1.5",
+		"--pids-limit", "200",
+		"--cap-add", "NET_ADMIN",
+		"--add-host", "db.internal:10.0.0.5",
+		"--log-driver", "json-file",
+		"--log-opt", "max-file=3",
+		"--log-opt", "max-size=10m",
+		"--health-cmd", "curl -fs localhost:8080/health || exit 1",
+		"--health-interval", "10s",
+		"--health-retries", "3",
+		"--network", "backend",
+		"--network-alias", "api",
+		"--ip", "172.30.0.10",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("create args:\n got  %s\n want %s", strings.Join(args, " "), strings.Join(want, " "))
+	}
+	if want := []string{"serve", "--port", "8080"}; !reflect.DeepEqual(cmdArgs, want) {
+		t.Errorf("cmd args = %v, want %v", cmdArgs, want)
+	}
+	if want := [][]string{{"network", "connect", "--alias", "api-metrics", "monitoring"}}; !reflect.DeepEqual(connects, want) {
+		t.Errorf("connects = %v, want %v", connects, want)
+	}
+}
+
+func TestBuildRecreateArgsEntrypointOverride(t *testing.T) {
+	raw, image := loadRecreateFixtures(t)
+	raw.Config.Entrypoint = []string{"/bin/tini", "--", "/app/api"}
+	raw.Config.Cmd = []string{"serve"}
+
+	args, cmdArgs, _, err := buildRecreateArgs(raw, image)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(args, " "), "--entrypoint /bin/tini") {
+		t.Errorf("missing entrypoint override in %v", args)
+	}
+	if want := []string{"--", "/app/api", "serve"}; !reflect.DeepEqual(cmdArgs, want) {
+		t.Errorf("cmd args = %v, want %v", cmdArgs, want)
+	}
+}
+
+func TestBuildRecreateArgsExecHealthcheck(t *testing.T) {
+	raw, image := loadRecreateFixtures(t)
+	execCheck := &inspectHealthcheck{Test: []string{"CMD", "/app/api", "healthcheck"}, Interval: 5e9}
+
+	// Inherited from the image: left to the new image, no shell needed
+	raw.Config.Healthcheck = execCheck
+	image.Config.Healthcheck = execCheck
+	args, _, _, err := buildRecreateArgs(raw, image)
+	if err != nil {
+		t.Fatalf("unexpected error for an inherited healthcheck: %v", err)
+	}
+	if strings.Contains(strings.Join(args, " "), "--health-cmd") {
+		t.Errorf("inherited healthcheck pinned on the new container: %v", args)
+	}
+
+	// Set on the container: --health-cmd would turn it into CMD-SHELL
+	image.Config.Healthcheck = nil
+	if _, _, _, err := buildRecreateArgs(raw, image); err == nil || !strings.Contains(err.Error(), "exec-form") {
+		t.Errorf("expected an exec-form healthcheck error, got %v", err)
+	}
+}
+
+func TestHealthcheckArgs(t *testing.T) {
+	args, err := healthcheckArgs(inspectHealthcheck{Test: []string{"NONE"}})
+	if err != nil || !reflect.DeepEqual(args, []string{"--no-healthcheck"}) {
+		t.Errorf("NONE: got %v, %v", args, err)
+	}
+	args, err = healthcheckArgs(inspectHealthcheck{Test: []string{"CMD-SHELL", "pg_isready"}, Timeout: 3e9, StartPeriod: 30e9})
+	want := []string{"--health-cmd", "pg_isready", "--health-timeout", "3s", "--health-start-period", "30s"}
+	if err != nil || !reflect.DeepEqual(args, want) {
+		t.Errorf("CMD-SHELL: got %v, %v", args, err)
+	}
+}
+
+func TestNetworkArgsHostMode(t *testing.T) {
+	raw, _ := loadRecreateFixtures(t)
+	raw.HostConfig.NetworkMode = "host"
+	args, connects := networkArgs(raw)
+	if !reflect.DeepEqual(args, []string{"--network", "host"}) || connects != nil {
+		t.Errorf("host mode: got %v, %v", args, connects)
+	}
+}
+
+func TestRollbackName(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if got := rollbackName("api", now); got != "api-rollback-1714564800" {
+		t.Errorf("rollbackName = %s", got)
+	}
+}
+
+func TestParseCreatedContainerID(t *testing.T) {
+	id := strings.Repeat("ab12", 16)
+	outputs := []string{
+		id,
+		"WARNING: The requested image's platform (linux/arm64) does not match the detected host platform (linux/amd64)\n" + id + "\n",
+	}
+	for _, output := range outputs {
+		if got, err := parseCreatedContainerID(output); err != nil || got != id {
+			t.Errorf("%q: got %q, %v", output, got, err)
+		}
+	}
+	for _, output := range []string{"", "Unable to find image 'api:2' locally", id + "\nsomething else"} {
+		if got, err := parseCreatedContainerID(output); err == nil {
+			t.Errorf("%q: expected error, got %q", output, got)
+		}
+	}
+}
+
+func TestRecreateStorePrune(t *testing.T) {
+	store := &recreateStore{ops: map[string]*RecreateOperation{
+		"old":       {Status: recreateCompleted, finished: time.Now().Add(-2 * operationRetention)},
+		"recent":    {Status: recreateFailed, finished: time.Now()},
+		"verifying": {Status: recreateVerifying},
+	}}
+	store.pruneLocked()
+	if _, ok := store.ops["old"]; ok || len(store.ops) != 2 {
+		t.Errorf("remaining operations: %v", store.ops)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// ps columns requested from docker top, COMMAND must stay last since it contains spaces
+const topPsOptions = "pid,ppid,user,pcpu,pmem,rss,etime,args"
+
+// Request for the process list, Interval only applies to the stream
+type ContainerTopRequest struct {
+	Hostname    string `json:"hostname"`
+	Username    string `json:"username"`
+	ContainerId string `json:"containerId"`
+	Interval    int    `json:"interval"` // seconds between refreshes, default 2
+}
+
+// A process running inside a container
+type ContainerProcess struct {
+	PID     int     `json:"pid"`
+	PPID    int     `json:"ppid"`
+	User    string  `json:"user"`
+	CPU     float64 `json:"cpu"` // percent
+	Mem     float64 `json:"mem"` // percent
+	RSS     int64   `json:"rss"` // KiB
+	Elapsed string  `json:"elapsed"`
+	Command string  `json:"command"`
+}
+
+// Process list response, also used as stream event
+type ContainerTopResponse struct {
+	Time      int64              `json:"time"`
+	Processes []ContainerProcess `json:"processes"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// Get the process table of a container
+func getContainerTop(ctx echo.Context) error {
+	var req ContainerTopRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateContainerRequest(ContainerRequest{Hostname: req.Hostname, Username: req.Username, ContainerId: req.ContainerId}); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	processes, output, err := fetchContainerTop(req.Username, req.Hostname, req.ContainerId)
+	if err != nil {
+		logger.Errorf("Error listing processes: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to list processes: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, ContainerTopResponse{Time: time.Now().Unix(), Processes: processes})
+}
+
+// Stream the process table of a container, refreshed every interval until the client disconnects
+func streamContainerTop(ctx echo.Context) error {
+	var req ContainerTopRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateContainerRequest(ContainerRequest{Hostname: req.Hostname, Username: req.Username, ContainerId: req.ContainerId}); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	interval := 2 * time.Second
+	if req.Interval > 0 {
+		if req.Interval > 60 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Interval must be at most 60 seconds"})
+		}
+		interval = time.Duration(req.Interval) * time.Second
+	}
+
+	stream := newEventStream(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		event := ContainerTopResponse{Time: time.Now().Unix(), Processes: []ContainerProcess{}}
+		processes, output, err := fetchContainerTop(req.Username, req.Hostname, req.ContainerId)
+		if err != nil {
+			// Keep the stream alive, the container may just be restarting
+			event.Error = fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(output)))
+		} else {
+			event.Processes = processes
+		}
+
+		if err := stream.Send(event); err != nil {
+			logger.Infof("Process stream for %s closed: %v", req.ContainerId, err)
+			return nil
+		}
+
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchContainerTop runs docker top and parses its table
+func fetchContainerTop(username, hostname, containerID string) ([]ContainerProcess, []byte, error) {
+	dockerCommand := utils.BuildDockerCommand("top", containerID, "-o", topPsOptions)
+	output, err := tunnelManager.ExecuteCommand(username, hostname, dockerCommand)
+	if err != nil {
+		return nil, output, err
+	}
+	return parseTopOutput(string(output)), output, nil
+}
+
+// parseTopOutput maps the columns of docker top by their header, so it works
+// with both our ps options and the default `docker top` layout
+func parseTopOutput(output string) []ContainerProcess {
+	processes := []ContainerProcess{}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return processes
+	}
+
+	headers := strings.Fields(lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < len(headers) {
+			continue
+		}
+		// The last column is the command line and may contain spaces
+		last := len(headers) - 1
+		fields = append(fields[:last], strings.Join(fields[last:], " "))
+
+		var p ContainerProcess
+		for i, header := range headers {
+			value := fields[i]
+			switch header {
+			case "PID":
+				p.PID, _ = strconv.Atoi(value)
+			case "PPID":
+				p.PPID, _ = strconv.Atoi(value)
+			case "USER", "UID":
+				p.User = value
+			case "%CPU", "C":
+				p.CPU, _ = strconv.ParseFloat(value, 64)
+			case "%MEM":
+				p.Mem, _ = strconv.ParseFloat(value, 64)
+			case "RSS":
+				p.RSS, _ = strconv.ParseInt(value, 10, 64)
+			case "ELAPSED", "TIME":
+				p.Elapsed = value
+			case "COMMAND", "CMD":
+				p.Command = value
+			}
+		}
+		processes = append(processes, p)
+	}
+	return processes
+}
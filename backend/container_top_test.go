@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseTopOutput(t *testing.T) {
+	output := `PID                 PPID                USER                %CPU                %MEM                RSS                 ELAPSED             COMMAND
+1234                1200                root                0.5                 1.2                 10240               01:02:03            nginx: master process nginx -g daemon off;
+1300                1234                101                 0.0                 0.3                 2048                01:02:01            nginx: worker process
+`
+	processes := parseTopOutput(output)
+	if len(processes) != 2 {
+		t.Fatalf("expected 2 processes, got %+v", processes)
+	}
+	p := processes[0]
+	if p.PID != 1234 || p.PPID != 1200 || p.User != "root" || p.CPU != 0.5 || p.Mem != 1.2 || p.RSS != 10240 || p.Elapsed != "01:02:03" {
+		t.Errorf("unexpected process: %+v", p)
+	}
+	if p.Command != "nginx: master process nginx -g daemon off;" {
+		t.Errorf("unexpected command %q", p.Command)
+	}
+
+	// Default docker top layout
+	defaults := parseTopOutput("UID PID PPID C STIME TTY TIME CMD\nroot 42 1 0 10:00 ? 00:00:01 sleep infinity\n")
+	if len(defaults) != 1 || defaults[0].PID != 42 || defaults[0].Command != "sleep infinity" {
+		t.Errorf("unexpected default layout parse: %+v", defaults)
+	}
+}
+
+func TestParseDiffOutput(t *testing.T) {
+	diff := parseDiffOutput("C /etc\nA /etc/app.conf\nD /var/cache/old\n\n")
+	if len(diff.Added) != 1 || diff.Added[0] != "/etc/app.conf" {
+		t.Errorf("unexpected added: %v", diff.Added)
+	}
+	if len(diff.Changed) != 1 || len(diff.Deleted) != 1 {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+var (
+	// Memory sizes as accepted by docker, e.g. "512m" or "2g"
+	memorySizePattern = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+	// CPU sets like "0-3" or "0,2,4"
+	cpusetPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+	// Restart policies: no, always, unless-stopped, on-failure[:max-retries]
+	restartPolicyPattern = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:[0-9]+)?)$`)
+)
+
+// Request for a live resource limit update. Nil fields are left unchanged.
+// The engine treats 0 as "unchanged" for every limit, so 0 is rejected: a
+// memory, CPU or shares limit can only be removed by recreating the container.
+type ContainerUpdateRequest struct {
+	Hostname          string  `json:"hostname"`
+	Username          string  `json:"username"`
+	ContainerId       string  `json:"containerId"`
+	Memory            *string `json:"memory"`            // e.g. "512m"
+	MemorySwap        *string `json:"memorySwap"`        // e.g. "1g", "-1" for unlimited swap
+	MemoryReservation *string `json:"memoryReservation"` // soft limit
+	CPUs              *string `json:"cpus"`              // e.g. "1.5"
+	CPUShares         *int64  `json:"cpuShares"`
+	CPUPeriod         *int64  `json:"cpuPeriod"` // microseconds
+	CPUQuota          *int64  `json:"cpuQuota"`  // microseconds, -1 removes the limit
+	CpusetCpus        *string `json:"cpusetCpus"`
+	PidsLimit         *int64  `json:"pidsLimit"` // -1 removes the limit
+	RestartPolicy     *string `json:"restartPolicy"`
+}
+
+// Limits and restart policy of a container at one point in time
+type ContainerLimitsSnapshot struct {
+	Limits        ResourceLimits `json:"limits"`
+	RestartPolicy RestartPolicy  `json:"restartPolicy"`
+}
+
+// Result of a limit update, read back from inspect
+type ContainerUpdateResponse struct {
+	Success string                  `json:"success"`
+	Before  ContainerLimitsSnapshot `json:"before"`
+	After   ContainerLimitsSnapshot `json:"after"`
+}
+
+// Configured limits next to the live usage of a running container
+type ContainerLimitsUsage struct {
+	ID              string             `json:"id"`
+	Name            string             `json:"name"`
+	Limits          ResourceLimits     `json:"limits"`
+	RestartPolicy   RestartPolicy      `json:"restartPolicy"`
+	Usage           *ContainerResource `json:"usage,omitempty"`
+	MemoryUnlimited bool               `json:"memoryUnlimited"`
+	CPUUnlimited    bool               `json:"cpuUnlimited"`
+	PidsUnlimited   bool               `json:"pidsUnlimited"`
+}
+
+// Update resource limits of a running container (docker update)
+func updateContainerResources(ctx echo.Context) error {
+	var req ContainerUpdateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateContainerRequest(ContainerRequest{Hostname: req.Hostname, Username: req.Username, ContainerId: req.ContainerId}); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	updateArgs, err := buildUpdateArgs(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	before, output, err := inspectContainers(req.Username, req.Hostname, req.ContainerId)
+	if err != nil || len(before) == 0 {
+		logger.Errorf("Error inspecting container before update: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect container: %v", err),
+			"output": string(output),
+		})
+	}
+
+	dockerCommand := utils.BuildDockerCommand(append(append([]string{"update"}, updateArgs...), req.ContainerId)...)
+	logger.Infof("Executing update command: %s", dockerCommand)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	if err != nil {
+		logger.Errorf("Error updating container: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to update container: %v", err),
+			"output": string(output),
+		})
+	}
+
+	after, output, err := inspectContainers(req.Username, req.Hostname, req.ContainerId)
+	if err != nil || len(after) == 0 {
+		logger.Errorf("Error inspecting container after update: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Container updated but failed to read back limits: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, ContainerUpdateResponse{
+		Success: "true",
+		Before:  limitsSnapshot(before[0]),
+		After:   limitsSnapshot(after[0]),
+	})
+}
+
+// List configured limits next to live usage for all running containers
+func getContainerLimits(ctx echo.Context) error {
+	var req DashboardRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand("ps", "-q", "--no-trunc"))
+	if err != nil {
+		logger.Errorf("Error listing running containers: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to list containers: %v", err),
+			"output": string(output),
+		})
+	}
+
+	ids := strings.Fields(string(output))
+	inspected, output, err := inspectContainers(req.Username, req.Hostname, ids...)
+	if err != nil {
+		logger.Errorf("Error inspecting containers: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect containers: %v", err),
+			"output": string(output),
+		})
+	}
+
+	// Usage is best effort, limits are still useful without it
+	stats, err := fetchContainerStats(req.Username, req.Hostname)
+	if err != nil {
+		logger.Warnf("Error getting resource stats: %v", err)
+	}
+
+	result := make([]ContainerLimitsUsage, 0, len(inspected))
+	for _, raw := range inspected {
+		snapshot := limitsSnapshot(raw)
+		entry := ContainerLimitsUsage{
+			ID:              raw.ID,
+			Name:            strings.TrimPrefix(raw.Name, "/"),
+			Limits:          snapshot.Limits,
+			RestartPolicy:   snapshot.RestartPolicy,
+			MemoryUnlimited: snapshot.Limits.Memory == 0,
+			CPUUnlimited:    snapshot.Limits.NanoCPUs == 0 && snapshot.Limits.CPUQuota <= 0 && snapshot.Limits.CpusetCpus == "",
+			PidsUnlimited:   snapshot.Limits.PidsLimit <= 0,
+		}
+		for i := range stats {
+			if stats[i].ID != "" && strings.HasPrefix(raw.ID, stats[i].ID) {
+				entry.Usage = &stats[i]
+				break
+			}
+		}
+		result = append(result, entry)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// buildUpdateArgs validates the requested changes and turns them into docker update flags
+func buildUpdateArgs(req ContainerUpdateRequest) ([]string, error) {
+	var args []string
+
+	memoryFlag := func(flag string, value *string, allowUnlimited bool) error {
+		if value == nil {
+			return nil
+		}
+		if !memorySizePattern.MatchString(*value) && !(allowUnlimited && *value == "-1") {
+			return fmt.Errorf("invalid %s value: %s", flag, *value)
+		}
+		if strings.TrimLeft(strings.TrimRight(*value, "bkmgBKMG"), "0") == "" {
+			return fmt.Errorf("%s cannot be 0: docker update leaves the limit unchanged, removing it requires recreating the container", flag)
+		}
+		args = append(args, "--"+flag, *value)
+		return nil
+	}
+	if err := memoryFlag("memory", req.Memory, false); err != nil {
+		return nil, err
+	}
+	if err := memoryFlag("memory-swap", req.MemorySwap, true); err != nil {
+		return nil, err
+	}
+	if err := memoryFlag("memory-reservation", req.MemoryReservation, false); err != nil {
+		return nil, err
+	}
+
+	if req.CPUs != nil {
+		cpus, err := strconv.ParseFloat(*req.CPUs, 64)
+		if err != nil || cpus < 0 {
+			return nil, fmt.Errorf("invalid cpus value: %s", *req.CPUs)
+		}
+		if cpus == 0 {
+			return nil, fmt.Errorf("cpus cannot be 0: docker update leaves the limit unchanged, removing it requires recreating the container")
+		}
+		args = append(args, "--cpus", *req.CPUs)
+	}
+	if req.CPUShares != nil {
+		if *req.CPUShares < 2 || *req.CPUShares > 262144 {
+			return nil, fmt.Errorf("cpu shares must be between 2 and 262144")
+		}
+		args = append(args, "--cpu-shares", strconv.FormatInt(*req.CPUShares, 10))
+	}
+	if req.CPUPeriod != nil {
+		if *req.CPUPeriod < 1000 || *req.CPUPeriod > 1000000 {
+			return nil, fmt.Errorf("cpu period must be between 1000 and 1000000 microseconds")
+		}
+		args = append(args, "--cpu-period", strconv.FormatInt(*req.CPUPeriod, 10))
+	}
+	if req.CPUQuota != nil {
+		if *req.CPUQuota != -1 && *req.CPUQuota < 1000 {
+			return nil, fmt.Errorf("cpu quota must be at least 1000 microseconds, or -1 to remove it")
+		}
+		args = append(args, "--cpu-quota", strconv.FormatInt(*req.CPUQuota, 10))
+	}
+	if req.CpusetCpus != nil {
+		if !cpusetPattern.MatchString(*req.CpusetCpus) {
+			return nil, fmt.Errorf("invalid cpuset value: %s", *req.CpusetCpus)
+		}
+		args = append(args, "--cpuset-cpus", *req.CpusetCpus)
+	}
+	if req.PidsLimit != nil {
+		if *req.PidsLimit < -1 || *req.PidsLimit == 0 {
+			return nil, fmt.Errorf("pids limit must be positive, or -1 to remove it")
+		}
+		args = append(args, "--pids-limit", strconv.FormatInt(*req.PidsLimit, 10))
+	}
+	if req.RestartPolicy != nil {
+		if !restartPolicyPattern.MatchString(*req.RestartPolicy) {
+			return nil, fmt.Errorf("invalid restart policy: %s", *req.RestartPolicy)
+		}
+		args = append(args, "--restart", *req.RestartPolicy)
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no changes requested")
+	}
+	return args, nil
+}
+
+// limitsSnapshot extracts limits and restart policy from inspect output
+func limitsSnapshot(raw containerInspectJSON) ContainerLimitsSnapshot {
+	return ContainerLimitsSnapshot{
+		Limits: inspectLimits(raw),
+		RestartPolicy: RestartPolicy{
+			Name:              raw.HostConfig.RestartPolicy.Name,
+			MaximumRetryCount: raw.HostConfig.RestartPolicy.MaximumRetryCount,
+		},
+	}
+}
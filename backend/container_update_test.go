@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildUpdateArgs(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int64) *int64 { return &n }
+
+	args, err := buildUpdateArgs(ContainerUpdateRequest{
+		Memory:        str("512m"),
+		MemorySwap:    str("-1"),
+		CPUs:          str("1.5"),
+		CPUQuota:      num(-1),
+		CpusetCpus:    str("0-3"),
+		PidsLimit:     num(-1),
+		RestartPolicy: str("on-failure:3"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "--memory 512m --memory-swap -1 --cpus 1.5 --cpu-quota -1 --cpuset-cpus 0-3 --pids-limit -1 --restart on-failure:3"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	for name, req := range map[string]ContainerUpdateRequest{
+		"no changes":         {},
+		"memory 0":           {Memory: str("0")},
+		"memory 0m":          {Memory: str("0m")},
+		"reservation 0":      {MemoryReservation: str("00g")},
+		"memory unlimited":   {Memory: str("-1")},
+		"memory with suffix": {Memory: str("512mb")},
+		"cpus 0":             {CPUs: str("0")},
+		"cpu shares 0":       {CPUShares: num(0)},
+		"cpu period 0":       {CPUPeriod: num(0)},
+		"cpu quota 0":        {CPUQuota: num(0)},
+		"empty cpuset":       {CpusetCpus: str("")},
+		"pids 0":             {PidsLimit: num(0)},
+		"restart policy":     {RestartPolicy: str("sometimes")},
+	} {
+		if _, err := buildUpdateArgs(req); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Stopped containers are reclaim candidates once they have been stopped this long
+const defaultReclaimOlderThanDays = 7
+
+// Measures the Docker root dir from a helper container, which can read it
+// without the SSH user needing root. Sections are separated by ---. du stays
+// on the root dir's filesystem, otherwise the overlay2 merged mounts of
+// running containers are counted on top of their layers.
+const diskUsageScript = `df -kP /docker-root | tail -n 1; echo ---; ` +
+	`du -skx /docker-root 2>/dev/null | cut -f 1; echo ---; ` +
+	`du -k /docker-root/containers/*/*-json.log 2>/dev/null; true`
+
+// Reclaim candidate types
+const (
+	reclaimImage      = "image"
+	reclaimContainer  = "container"
+	reclaimVolume     = "volume"
+	reclaimBuildCache = "build-cache"
+)
+
+// Where the disk space of a host goes. Sizes are in bytes, -1 when unknown.
+type DiskUsageReport struct {
+	RootDir     string              `json:"rootDir"`
+	Filesystem  *DiskFilesystem     `json:"filesystem,omitempty"` // filesystem holding the root dir
+	RootDirSize int64               `json:"rootDirSize"`
+	HostError   string              `json:"hostError,omitempty"` // why the host measurements are missing
+	Images      ImageDiskUsage      `json:"images"`
+	Containers  ContainerDiskUsage  `json:"containers"`
+	Volumes     VolumeDiskUsage     `json:"volumes"`
+	BuildCache  BuildCacheDiskUsage `json:"buildCache"`
+}
+
+type DiskFilesystem struct {
+	Total     int64 `json:"total"`
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+}
+
+type ImageDiskUsage struct {
+	Total int64 `json:"total"`
+	// Layers used by more than one image, counted once
+	Shared      int64           `json:"shared"`
+	Unique      int64           `json:"unique"`
+	Reclaimable int64           `json:"reclaimable"`
+	Items       []DiskUsageItem `json:"items"`
+}
+
+type ContainerDiskUsage struct {
+	Writable    int64           `json:"writable"` // writable layers
+	Logs        int64           `json:"logs"`     // json-file logs, -1 when the root dir could not be read
+	Reclaimable int64           `json:"reclaimable"`
+	Items       []DiskUsageItem `json:"items"`
+}
+
+type VolumeDiskUsage struct {
+	Total       int64           `json:"total"`
+	Reclaimable int64           `json:"reclaimable"` // unused volumes
+	Items       []DiskUsageItem `json:"items"`
+}
+
+type BuildCacheDiskUsage struct {
+	Total       int64 `json:"total"`
+	Reclaimable int64 `json:"reclaimable"`
+	Count       int   `json:"count"`
+}
+
+// Image, container or volume in the breakdown
+type DiskUsageItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	State string `json:"state,omitempty"` // containers only
+	// Images: layers shared with other images and size only this image uses
+	SharedSize int64 `json:"sharedSize,omitempty"`
+	UniqueSize int64 `json:"uniqueSize,omitempty"`
+	LogSize    int64 `json:"logSize,omitempty"` // containers only
+	// Number of containers using the image or volume
+	Links    int  `json:"links"`
+	Dangling bool `json:"dangling,omitempty"` // untagged image
+}
+
+// Something that can be removed to free space
+type ReclaimCandidate struct {
+	ID     string `json:"id"` // <type>:<id or name>, build-cache for the cache
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+type ReclaimPlan struct {
+	Candidates []ReclaimCandidate `json:"candidates"`
+	Total      int64              `json:"total"`
+}
+
+// Request for the reclaim planner and executor
+type ReclaimRequest struct {
+	Hostname      string   `json:"hostname"`
+	Username      string   `json:"username"`
+	OlderThanDays int      `json:"olderThanDays"` // stopped containers, defaults to 7
+	Candidates    []string `json:"candidates"`    // execute only, candidate IDs from the plan
+}
+
+// Outcome of a reclaim run
+type ReclaimResult struct {
+	Removed []ReclaimCandidate `json:"removed"`
+	Failed  []ReclaimFailure   `json:"failed"`
+	// Requested IDs that are no longer candidates, e.g. a container started since
+	Skipped []string `json:"skipped"`
+	Freed   int64    `json:"freed"` // estimated from the planned sizes
+}
+
+// Progress of a reclaim run
+type ReclaimProgress struct {
+	Current string `json:"current,omitempty"`
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+}
+
+type ReclaimFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// Host measurements taken from the helper container
+type hostDiskUsage struct {
+	filesystem  *DiskFilesystem
+	rootDirSize int64
+	logs        map[string]int64 // container ID -> log size
+}
+
+// Get the disk usage breakdown of a host
+func getDiskUsage(ctx echo.Context) error {
+	var req ReclaimRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+	if err := validateReclaimRequest(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	report, output, err := collectDiskUsage(req.Username, req.Hostname)
+	if err != nil {
+		logger.Errorf("Error reading disk usage: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to read disk usage: %v", err),
+			"output": string(output),
+		})
+	}
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// List what could be removed to free space
+func planReclaim(ctx echo.Context) error {
+	var req ReclaimRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+	if err := validateReclaimRequest(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	plan, output, err := collectReclaimPlan(req)
+	if err != nil {
+		logger.Errorf("Error planning reclaim: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to plan reclaim: %v", err),
+			"output": string(output),
+		})
+	}
+	return ctx.JSON(http.StatusOK, plan)
+}
+
+// Remove the chosen candidates. The plan is computed again so only items
+// that still qualify are removed.
+func executeReclaim(ctx echo.Context) error {
+	var req ReclaimRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+	if err := validateReclaimRequest(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(req.Candidates) == 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+
+	op := operations.start("reclaim", req.Username, req.Hostname, fmt.Sprintf("%d items", len(req.Candidates)), func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runReclaim(opCtx, req, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+func validateReclaimRequest(req *ReclaimRequest) error {
+	if req.Hostname == "" || req.Username == "" {
+		return fmt.Errorf("Missing required fields")
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return err
+	}
+	if req.OlderThanDays < 0 {
+		return fmt.Errorf("olderThanDays cannot be negative")
+	}
+	if req.OlderThanDays == 0 {
+		req.OlderThanDays = loadSettingInt("reclaimOlderThanDays", defaultReclaimOlderThanDays)
+	}
+	return nil
+}
+
+// collectDiskUsage combines both forms of `docker system df` with the host
+// measurements. Host measurements are optional, their failure is reported in
+// the result.
+func collectDiskUsage(username, hostname string) (*DiskUsageReport, []byte, error) {
+	summary, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("system", "df", "--format", "{{json .}}"))
+	if err != nil {
+		return nil, summary, fmt.Errorf("failed to read disk usage summary: %v", err)
+	}
+	verbose, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("system", "df", "-v", "--format", "{{json .}}"))
+	if err != nil {
+		return nil, verbose, fmt.Errorf("failed to read disk usage: %v", err)
+	}
+
+	rootDir, host, hostErr := measureDockerRoot(username, hostname)
+	report, err := buildDiskUsageReport(summary, verbose, host)
+	if err != nil {
+		return nil, verbose, err
+	}
+	report.RootDir = rootDir
+	if hostErr != nil {
+		logger.Warnf("Failed to measure the Docker root dir on %s: %v", hostname, hostErr)
+		report.HostError = hostErr.Error()
+	}
+	return report, nil, nil
+}
+
+// measureDockerRoot runs df and du on the Docker root dir in a helper container
+func measureDockerRoot(username, hostname string) (string, *hostDiskUsage, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("info", "--format", "{{.DockerRootDir}}"))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read the Docker root dir: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	rootDir, err := utils.CleanContainerPath(strings.TrimSpace(string(output)))
+	if err != nil {
+		return "", nil, fmt.Errorf("unexpected Docker root dir %q: %v", strings.TrimSpace(string(output)), err)
+	}
+
+	output, err = tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(
+		"run", "--rm", "--network", "none", "--label", volumeHelperLabel+"=true",
+		"-v", rootDir+":/docker-root:ro", volumeHelperImage, "sh", "-c", diskUsageScript))
+	if err != nil {
+		return rootDir, nil, fmt.Errorf("failed to measure %s: %v: %s", rootDir, err, strings.TrimSpace(string(output)))
+	}
+	host, err := parseHostDiskUsage(string(output))
+	return rootDir, host, err
+}
+
+// parseHostDiskUsage reads the output of diskUsageScript
+func parseHostDiskUsage(output string) (*hostDiskUsage, error) {
+	sections := strings.Split(output, "---\n")
+	if len(sections) != 3 {
+		return nil, fmt.Errorf("unexpected disk usage output: %q", output)
+	}
+	host := &hostDiskUsage{rootDirSize: -1, logs: make(map[string]int64)}
+
+	// Filesystem 1024-blocks Used Available Capacity Mounted-on
+	if fields := strings.Fields(sections[0]); len(fields) >= 4 {
+		total, err1 := strconv.ParseInt(fields[1], 10, 64)
+		used, err2 := strconv.ParseInt(fields[2], 10, 64)
+		available, err3 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 == nil && err2 == nil && err3 == nil {
+			host.filesystem = &DiskFilesystem{Total: total << 10, Used: used << 10, Available: available << 10}
+		}
+	}
+	if size, err := strconv.ParseInt(strings.TrimSpace(sections[1]), 10, 64); err == nil {
+		host.rootDirSize = size << 10
+	}
+	// <KiB>\t/docker-root/containers/<id>/<id>-json.log
+	for _, line := range strings.Split(strings.TrimSpace(sections[2]), "\n") {
+		size, file, ok := strings.Cut(line, "\t")
+		kb, err := strconv.ParseInt(size, 10, 64)
+		parts := strings.Split(file, "/")
+		if !ok || err != nil || len(parts) < 2 {
+			continue
+		}
+		host.logs[shortContainerID(parts[len(parts)-2])] = kb << 10
+	}
+	return host, nil
+}
+
+// Rows of `docker system df -v --format '{{json .}}'`, every value is a string
+type dockerDiskUsageJSON struct {
+	Images []struct {
+		ID         string `json:"ID"`
+		Repository string `json:"Repository"`
+		Tag        string `json:"Tag"`
+		Size       string `json:"Size"`
+		SharedSize string `json:"SharedSize"`
+		UniqueSize string `json:"UniqueSize"`
+		Containers string `json:"Containers"`
+	} `json:"Images"`
+	Containers []struct {
+		ID    string `json:"ID"`
+		Names string `json:"Names"`
+		Image string `json:"Image"`
+		Size  string `json:"Size"` // e.g. "2B (virtual 187MB)"
+		State string `json:"State"`
+	} `json:"Containers"`
+	Volumes []struct {
+		Name  string `json:"Name"`
+		Size  string `json:"Size"`
+		Links string `json:"Links"`
+	} `json:"Volumes"`
+	BuildCache []struct {
+		ID     string `json:"ID"`
+		Size   string `json:"Size"`
+		InUse  string `json:"InUse"`
+		Shared string `json:"Shared"`
+	} `json:"BuildCache"`
+}
+
+// buildDiskUsageReport combines the totals of `docker system df` with the
+// per item rows of `docker system df -v`. host may be nil.
+func buildDiskUsageReport(summary, verbose []byte, host *hostDiskUsage) (*DiskUsageReport, error) {
+	var usage dockerDiskUsageJSON
+	if err := json.Unmarshal(verbose, &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse disk usage: %v", err)
+	}
+	report := &DiskUsageReport{
+		RootDirSize: -1,
+		Images:      ImageDiskUsage{Items: []DiskUsageItem{}},
+		Containers:  ContainerDiskUsage{Items: []DiskUsageItem{}},
+		Volumes:     VolumeDiskUsage{Items: []DiskUsageItem{}},
+	}
+	if host != nil {
+		report.Filesystem = host.filesystem
+		report.RootDirSize = host.rootDirSize
+	} else {
+		report.Containers.Logs = -1
+	}
+
+	// One JSON object per line: Type, TotalCount, Active, Size, Reclaimable
+	for _, line := range strings.Split(strings.TrimSpace(string(summary)), "\n") {
+		var row struct {
+			Type        string `json:"Type"`
+			Size        string `json:"Size"`
+			Reclaimable string `json:"Reclaimable"` // e.g. "1.2GB (50%)"
+		}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, fmt.Errorf("failed to parse disk usage summary: %v", err)
+		}
+		size := max(parseDockerSize(row.Size), 0)
+		reclaimable, _, _ := strings.Cut(row.Reclaimable, " ")
+		switch row.Type {
+		case "Images":
+			report.Images.Total, report.Images.Reclaimable = size, max(parseDockerSize(reclaimable), 0)
+		case "Containers":
+			report.Containers.Writable, report.Containers.Reclaimable = size, max(parseDockerSize(reclaimable), 0)
+		case "Local Volumes":
+			report.Volumes.Total, report.Volumes.Reclaimable = size, max(parseDockerSize(reclaimable), 0)
+		case "Build Cache":
+			report.BuildCache.Total, report.BuildCache.Reclaimable = size, max(parseDockerSize(reclaimable), 0)
+		}
+	}
+
+	for _, i := range usage.Images {
+		item := DiskUsageItem{
+			ID:         i.ID,
+			Name:       i.Repository + ":" + i.Tag,
+			Size:       parseDockerSize(i.Size),
+			SharedSize: max(parseDockerSize(i.SharedSize), 0),
+			UniqueSize: max(parseDockerSize(i.UniqueSize), 0),
+			Links:      parseDiskUsageCount(i.Containers),
+			Dangling:   i.Repository == "<none>" && i.Tag == "<none>",
+		}
+		if item.Dangling {
+			item.Name = "<none>"
+		}
+		report.Images.Unique += item.UniqueSize
+		report.Images.Items = append(report.Images.Items, item)
+	}
+	report.Images.Shared = max(report.Images.Total-report.Images.Unique, 0)
+
+	for _, c := range usage.Containers {
+		writable, _, _ := strings.Cut(c.Size, " ")
+		item := DiskUsageItem{
+			ID:    c.ID,
+			Name:  c.Names,
+			Size:  parseDockerSize(writable),
+			State: c.State,
+		}
+		if host != nil {
+			item.LogSize = host.logs[shortContainerID(c.ID)]
+			report.Containers.Logs += item.LogSize
+		}
+		report.Containers.Items = append(report.Containers.Items, item)
+	}
+
+	for _, v := range usage.Volumes {
+		report.Volumes.Items = append(report.Volumes.Items, DiskUsageItem{
+			ID:    v.Name,
+			Name:  v.Name,
+			Size:  parseDockerSize(v.Size),
+			Links: parseDiskUsageCount(v.Links),
+		})
+	}
+	report.BuildCache.Count = len(usage.BuildCache)
+
+	for _, items := range [][]DiskUsageItem{report.Images.Items, report.Containers.Items, report.Volumes.Items} {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Size > items[j].Size })
+	}
+	return report, nil
+}
+
+// parseDiskUsageCount parses container counts, which are N/A when unknown
+func parseDiskUsageCount(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// collectReclaimPlan reads the disk usage and the stop times of stopped containers
+func collectReclaimPlan(req ReclaimRequest) (*ReclaimPlan, []byte, error) {
+	report, output, err := collectDiskUsage(req.Username, req.Hostname)
+	if err != nil {
+		return nil, output, err
+	}
+
+	var stoppedIDs []string
+	for _, c := range report.Containers.Items {
+		if isStoppedState(c.State) {
+			stoppedIDs = append(stoppedIDs, c.ID)
+		}
+	}
+	stopped, output, err := inspectContainers(req.Username, req.Hostname, stoppedIDs...)
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to inspect stopped containers: %v", err)
+	}
+
+	olderThan := time.Duration(req.OlderThanDays) * 24 * time.Hour
+	return buildReclaimPlan(report, stopped, olderThan, time.Now()), nil, nil
+}
+
+func isStoppedState(state string) bool {
+	return state == "exited" || state == "created" || state == "dead"
+}
+
+// buildReclaimPlan lists dangling images, containers stopped for longer than
+// olderThan, unused volumes and the unused build cache
+func buildReclaimPlan(report *DiskUsageReport, stopped []containerInspectJSON, olderThan time.Duration, now time.Time) *ReclaimPlan {
+	plan := &ReclaimPlan{Candidates: []ReclaimCandidate{}}
+	add := func(c ReclaimCandidate) {
+		plan.Candidates = append(plan.Candidates, c)
+		plan.Total += c.Size
+	}
+
+	for _, i := range report.Images.Items {
+		if i.Dangling && i.Links <= 0 {
+			add(ReclaimCandidate{ID: reclaimImage + ":" + i.ID, Type: reclaimImage, Name: i.ID, Size: i.UniqueSize, Reason: "dangling image"})
+		}
+	}
+
+	sizes := make(map[string]DiskUsageItem, len(report.Containers.Items))
+	for _, c := range report.Containers.Items {
+		sizes[shortContainerID(c.ID)] = c
+	}
+	for _, c := range stopped {
+		if !isStoppedState(c.State.Status) {
+			continue
+		}
+		// Containers that never ran have no finish time
+		since, err := time.Parse(time.RFC3339Nano, c.State.FinishedAt)
+		if err != nil || since.Year() <= 1 {
+			if since, err = time.Parse(time.RFC3339Nano, c.Created); err != nil {
+				continue
+			}
+		}
+		age := now.Sub(since)
+		if age < olderThan {
+			continue
+		}
+		id := shortContainerID(c.ID)
+		item := sizes[id]
+		add(ReclaimCandidate{
+			ID:     reclaimContainer + ":" + id,
+			Type:   reclaimContainer,
+			Name:   strings.TrimPrefix(c.Name, "/"),
+			Size:   max(item.Size, 0) + item.LogSize,
+			Reason: fmt.Sprintf("%s for %d days", c.State.Status, int(age.Hours()/24)),
+		})
+	}
+
+	for _, v := range report.Volumes.Items {
+		if v.Links == 0 {
+			add(ReclaimCandidate{ID: reclaimVolume + ":" + v.Name, Type: reclaimVolume, Name: v.Name, Size: max(v.Size, 0), Reason: "not used by any container, its data is lost"})
+		}
+	}
+
+	if report.BuildCache.Reclaimable > 0 {
+		add(ReclaimCandidate{ID: reclaimBuildCache, Type: reclaimBuildCache, Name: "build cache", Size: report.BuildCache.Reclaimable, Reason: "unused build cache"})
+	}
+
+	sort.SliceStable(plan.Candidates, func(i, j int) bool { return plan.Candidates[i].Size > plan.Candidates[j].Size })
+	return plan
+}
+
+func runReclaim(ctx context.Context, req ReclaimRequest, update func(interface{})) (interface{}, error) {
+	plan, output, err := collectReclaimPlan(req)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	candidates := make(map[string]ReclaimCandidate, len(plan.Candidates))
+	for _, c := range plan.Candidates {
+		candidates[c.ID] = c
+	}
+
+	result := ReclaimResult{Removed: []ReclaimCandidate{}, Failed: []ReclaimFailure{}, Skipped: []string{}}
+	for i, id := range req.Candidates {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		candidate, ok := candidates[id]
+		if !ok {
+			result.Skipped = append(result.Skipped, id)
+			continue
+		}
+		update(ReclaimProgress{Current: candidate.Name, Done: i, Total: len(req.Candidates)})
+
+		var args []string
+		switch candidate.Type {
+		case reclaimImage:
+			args = []string{"image", "rm", candidate.Name}
+		case reclaimContainer:
+			args = []string{"rm", strings.TrimPrefix(candidate.ID, reclaimContainer+":")}
+		case reclaimVolume:
+			args = []string{"volume", "rm", candidate.Name}
+		case reclaimBuildCache:
+			args = []string{"builder", "prune", "--force"}
+		}
+		output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(args...))
+		if err != nil {
+			result.Failed = append(result.Failed, ReclaimFailure{ID: id, Error: fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(output)))})
+			continue
+		}
+		result.Removed = append(result.Removed, candidate)
+		result.Freed += candidate.Size
+	}
+	update(ReclaimProgress{Done: len(req.Candidates), Total: len(req.Candidates)})
+	return result, nil
+}
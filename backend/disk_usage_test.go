@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const diskUsageSummaryOutput = `{"Active":"1","Reclaimable":"50MB (25%)","Size":"200MB","TotalCount":"3","Type":"Images"}
+{"Active":"1","Reclaimable":"2MB (66%)","Size":"3MB","TotalCount":"2","Type":"Containers"}
+{"Active":"1","Reclaimable":"1GB (90%)","Size":"1.1GB","TotalCount":"2","Type":"Local Volumes"}
+{"Active":"0","Reclaimable":"300MB","Size":"400MB","TotalCount":"4","Type":"Build Cache"}`
+
+const diskUsageVerboseOutput = `{
+	"Images": [
+		{"ID": "aaaaaaaaaaaa", "Repository": "nginx", "Tag": "1.25", "Size": "150MB", "SharedSize": "80MB", "UniqueSize": "70MB", "Containers": "1"},
+		{"ID": "bbbbbbbbbbbb", "Repository": "<none>", "Tag": "<none>", "Size": "130MB", "SharedSize": "80MB", "UniqueSize": "50MB", "Containers": "0"}
+	],
+	"Containers": [
+		{"ID": "c1c1c1c1c1c1", "Names": "web", "Image": "nginx:1.25", "Size": "1MB (virtual 151MB)", "State": "running"},
+		{"ID": "c2c2c2c2c2c2", "Names": "old-job", "Image": "nginx:1.25", "Size": "2MB (virtual 152MB)", "State": "exited"}
+	],
+	"Volumes": [
+		{"Name": "data", "Size": "100MB", "Links": "1"},
+		{"Name": "leftover", "Size": "1GB", "Links": "0"}
+	],
+	"BuildCache": [{"ID": "x", "Size": "100MB", "InUse": "false", "Shared": "false"}]
+}`
+
+const diskUsageHostOutput = `/dev/sda1 102400 51200 51200 50% /docker-root
+---
+2048000
+---
+4	/docker-root/containers/c1c1c1c1c1c1d00d/c1c1c1c1c1c1d00d-json.log
+1024	/docker-root/containers/c2c2c2c2c2c2beef/c2c2c2c2c2c2beef-json.log
+`
+
+func TestDiskUsageReportAndPlan(t *testing.T) {
+	host, err := parseHostDiskUsage(diskUsageHostOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.filesystem.Total != 100<<20 || host.rootDirSize != 2048000<<10 || host.logs["c2c2c2c2c2c2"] != 1<<20 {
+		t.Errorf("unexpected host usage: %+v %+v", host, host.filesystem)
+	}
+
+	report, err := buildDiskUsageReport([]byte(diskUsageSummaryOutput), []byte(diskUsageVerboseOutput), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Images.Total != 200e6 || report.Images.Unique != 120e6 || report.Images.Shared != 80e6 {
+		t.Errorf("unexpected image usage: %+v", report.Images)
+	}
+	if report.Containers.Logs != 1<<20+4<<10 || report.Volumes.Reclaimable != 1e9 || report.BuildCache.Reclaimable != 300e6 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	stopped := []containerInspectJSON{{ID: "c2c2c2c2c2c2beef", Name: "/old-job"}}
+	stopped[0].State.Status = "exited"
+	stopped[0].State.FinishedAt = now.Add(-10 * 24 * time.Hour).Format(time.RFC3339Nano)
+
+	plan := buildReclaimPlan(report, stopped, 7*24*time.Hour, now)
+	want := map[string]int64{
+		"volume:leftover":        1e9,
+		"build-cache":            300e6,
+		"image:bbbbbbbbbbbb":     50e6,
+		"container:c2c2c2c2c2c2": 2e6 + 1<<20,
+	}
+	if len(plan.Candidates) != len(want) {
+		t.Fatalf("unexpected candidates: %+v", plan.Candidates)
+	}
+	for _, c := range plan.Candidates {
+		if size, ok := want[c.ID]; !ok || size != c.Size {
+			t.Errorf("unexpected candidate %+v", c)
+		}
+	}
+	if plan.Candidates[0].ID != "volume:leftover" {
+		t.Errorf("expected candidates sorted by size, got %s first", plan.Candidates[0].ID)
+	}
+
+	// Recently stopped containers are kept
+	if plan := buildReclaimPlan(report, stopped, 30*24*time.Hour, now); len(plan.Candidates) != 3 {
+		t.Errorf("expected 3 candidates, got %+v", plan.Candidates)
+	}
+}
+
+func TestParseHostDiskUsage(t *testing.T) {
+	if !strings.Contains(diskUsageScript, "du -skx /docker-root") {
+		t.Error("root dir size must not cross into overlay2 merged mounts")
+	}
+
+	tests := []struct {
+		name        string
+		output      string
+		rootDirSize int64
+		logs        int
+	}{
+		{"full output", diskUsageHostOutput, 2048000 << 10, 2},
+		// du exits non-zero on unreadable dirs but still prints the total
+		{"partial du", "/dev/sda1 102400 51200 51200 50% /docker-root\n---\n1024\n---\n", 1 << 20, 0},
+		{"du failed", "/dev/sda1 102400 51200 51200 50% /docker-root\n---\n\n---\n", -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := parseHostDiskUsage(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host.filesystem == nil || host.rootDirSize != tt.rootDirSize || len(host.logs) != tt.logs {
+				t.Errorf("unexpected host usage: %+v", host)
+			}
+		})
+	}
+
+	if _, err := parseHostDiskUsage("df: /docker-root: No such file or directory\n"); err == nil {
+		t.Error("expected an error for output without sections")
+	}
+}
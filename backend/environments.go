@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Environment is a saved remote host from the settings file. The UI owns the
+// file, the backend only reads it for operations spanning all environments.
+type Environment struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+}
+
+// loadEnvironments reads the saved environments, skipping invalid entries
+func loadEnvironments() ([]Environment, error) {
+	data, err := os.ReadFile(settingsFilePath)
+	if os.IsNotExist(err) {
+		return []Environment{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %v", err)
+	}
+
+	var settings struct {
+		Environments []Environment `json:"environments"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %v", err)
+	}
+
+	environments := make([]Environment, 0, len(settings.Environments))
+	for _, env := range settings.Environments {
+		if err := validateConnection(env.Username, env.Hostname); err != nil {
+			logger.Warnf("Skipping environment %s: %v", env.Name, err)
+			continue
+		}
+		environments = append(environments, env)
+	}
+	return environments, nil
+}
+
+// findEnvironment returns the saved environment with the given ID
+func findEnvironment(id string) (*Environment, error) {
+	environments, err := loadEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range environments {
+		if env.ID == id {
+			return &env, nil
+		}
+	}
+	return nil, fmt.Errorf("environment %s not found", id)
+}
+
+// forEachEnvironment runs fn for every environment in parallel and waits for all of them
+func forEachEnvironment(environments []Environment, fn func(i int, env Environment)) {
+	var wg sync.WaitGroup
+	for i, env := range environments {
+		wg.Add(1)
+		go func(i int, env Environment) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorf("Panic while processing environment %s: %v", env.Name, r)
+				}
+			}()
+			fn(i, env)
+		}(i, env)
+	}
+	wg.Wait()
+}
+
+// loadSettingInt reads a numeric top level setting, returning def when it is
+// missing or invalid
+func loadSettingInt(key string, def int) int {
+	data, err := os.ReadFile(settingsFilePath)
+	if err != nil {
+		return def
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return def
+	}
+	if value, ok := settings[key].(float64); ok && value > 0 {
+		return int(value)
+	}
+	return def
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+const (
+	// Largest uploaded build context accepted
+	maxBuildContextSize int64 = 1 << 30
+	// Marks the line carrying the ID of the built image in the build output
+	buildImageIDMarker = "@@remote-docker-image-id@@"
+)
+
+var (
+	buildArgNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	buildTargetPattern  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Options of a build, sent as form values next to the context archive
+type ImageBuildOptions struct {
+	Dockerfile string            `json:"dockerfile"` // path inside the context, defaults to Dockerfile
+	BuildArgs  map[string]string `json:"buildArgs"`
+	Target     string            `json:"target"`
+	Tags       []string          `json:"tags"`
+	Platform   string            `json:"platform"`
+	NoCache    bool              `json:"noCache"`
+	Pull       bool              `json:"pull"`
+}
+
+// Event streamed while an image builds
+type ImageBuildEvent struct {
+	Type    string `json:"type"` // start, output, done or error
+	Line    string `json:"line,omitempty"`
+	Error   string `json:"error,omitempty"`
+	ImageID string `json:"imageId,omitempty"`
+	// Files of the context left out by .dockerignore
+	Excluded int `json:"excluded,omitempty"`
+}
+
+// Build an image on the remote host from an uploaded context archive (tar or
+// tar.gz), streaming the build output
+func buildImage(ctx echo.Context) error {
+	ctx.Request().Body = http.MaxBytesReader(ctx.Response(), ctx.Request().Body, maxBuildContextSize+1<<20)
+
+	hostname := ctx.FormValue("hostname")
+	username := ctx.FormValue("username")
+	if hostname == "" || username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(username, hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var opts ImageBuildOptions
+	if raw := ctx.FormValue("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid build options"})
+		}
+	}
+	args, err := buildImageArgs(&opts)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	fileHeader, err := ctx.FormFile("context")
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing build context"})
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read uploaded build context"})
+	}
+	defer src.Close()
+
+	// First pass: the ignore rules and the Dockerfile must be known before
+	// the context is streamed
+	ignore, found, err := scanBuildContext(src, opts.Dockerfile)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid build context: %v", err)})
+	}
+	if !found {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Build context does not contain %s", opts.Dockerfile)})
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read uploaded build context"})
+	}
+
+	// docker build only writes the image ID to a file, print it with a marker
+	// after the build output
+	command := `iid=$(mktemp) && ` + utils.BuildDockerCommand(args...) + ` --iidfile "$iid" - 2>&1 && echo "` +
+		buildImageIDMarker + `$(cat "$iid")"; status=$?; rm -f "$iid"; exit $status`
+	logger.Infof("Building image on %s with tags %v", hostname, opts.Tags)
+
+	stream := newEventStream(ctx)
+	if err := stream.Send(ImageBuildEvent{Type: "start"}); err != nil {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	excluded := 0
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		var err error
+		excluded, err = writeBuildContext(pw, src, ignore, opts.Dockerfile)
+		pw.CloseWithError(err)
+	}()
+
+	var imageID string
+	lines := newLineWriter(func(line string) error {
+		if id, ok := strings.CutPrefix(line, buildImageIDMarker); ok {
+			imageID = strings.TrimSpace(id)
+			return nil
+		}
+		return stream.Send(ImageBuildEvent{Type: "output", Line: line})
+	})
+	err = tunnelManager.StreamCommand(ctx.Request().Context(), username, hostname, command, pr, lines)
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-written
+	if flushErr := lines.Flush(); err == nil {
+		err = flushErr
+	}
+	if err == nil && imageID == "" {
+		err = fmt.Errorf("build did not report an image ID")
+	}
+	if err != nil {
+		logger.Errorf("Image build on %s failed: %v", hostname, err)
+		stream.Send(ImageBuildEvent{Type: "error", Error: fmt.Sprintf("Build failed: %v", err)})
+		return nil
+	}
+	stream.Send(ImageBuildEvent{Type: "done", ImageID: imageID, Excluded: excluded})
+	return nil
+}
+
+// buildImageArgs validates the options and turns them into docker build
+// arguments, without the context argument
+func buildImageArgs(opts *ImageBuildOptions) ([]string, error) {
+	if opts.Dockerfile == "" {
+		opts.Dockerfile = "Dockerfile"
+	}
+	dockerfile, err := cleanContextPath(opts.Dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Dockerfile path: %v", err)
+	}
+	opts.Dockerfile = dockerfile
+
+	args := []string{"build", "--progress=plain", "-f", dockerfile}
+	for _, name := range sortedKeys(opts.BuildArgs) {
+		if !buildArgNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("Invalid build argument name: %s", name)
+		}
+		args = append(args, "--build-arg", name+"="+opts.BuildArgs[name])
+	}
+	if opts.Target != "" {
+		if len(opts.Target) > 128 || !buildTargetPattern.MatchString(opts.Target) {
+			return nil, fmt.Errorf("Invalid target: %s", opts.Target)
+		}
+		args = append(args, "--target", opts.Target)
+	}
+	for _, tag := range opts.Tags {
+		if err := utils.ValidateImageName(tag); err != nil || strings.Contains(tag, "@") {
+			return nil, fmt.Errorf("Invalid tag: %s", tag)
+		}
+		args = append(args, "-t", tag)
+	}
+	if opts.Platform != "" {
+		if err := utils.ValidatePlatform(opts.Platform); err != nil {
+			return nil, fmt.Errorf("Invalid platform: %v", err)
+		}
+		args = append(args, "--platform", opts.Platform)
+	}
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if opts.Pull {
+		args = append(args, "--pull")
+	}
+	return args, nil
+}
+
+// cleanContextPath normalizes a path inside the build context
+func cleanContextPath(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "./"))
+	if cleaned == "." || strings.HasPrefix(cleaned, "/") || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%s: path must stay inside the build context", name)
+	}
+	return cleaned, nil
+}
+
+// openContextArchive returns a tar reader for a tar or tar.gz archive
+func openContextArchive(r io.Reader) (*tar.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(gz), nil
+	}
+	return tar.NewReader(br), nil
+}
+
+// scanBuildContext reads the .dockerignore rules of the context and checks
+// that the Dockerfile is there
+func scanBuildContext(r io.Reader, dockerfile string) (*dockerIgnore, bool, error) {
+	tr, err := openContextArchive(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ignore := &dockerIgnore{}
+	found := false
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		name, err := cleanContextPath(header.Name)
+		if err != nil {
+			if strings.Trim(header.Name, "./") == "" {
+				continue
+			}
+			return nil, false, err
+		}
+
+		total += header.Size
+		if total > maxBuildContextSize {
+			return nil, false, utils.ErrSizeLimitExceeded
+		}
+		switch {
+		case name == ".dockerignore" && header.Typeflag == tar.TypeReg:
+			data, err := io.ReadAll(io.LimitReader(tr, 1<<20))
+			if err != nil {
+				return nil, false, err
+			}
+			ignore = parseDockerIgnore(string(data))
+		case name == dockerfile && header.Typeflag == tar.TypeReg:
+			found = true
+		}
+	}
+	return ignore, found, nil
+}
+
+// writeBuildContext writes the context as tar.gz to w, leaving out what
+// .dockerignore excludes. Like docker itself it always keeps the Dockerfile
+// and .dockerignore. Returns the number of excluded entries.
+func writeBuildContext(w io.Writer, r io.Reader, ignore *dockerIgnore, dockerfile string) (int, error) {
+	tr, err := openContextArchive(r)
+	if err != nil {
+		return 0, err
+	}
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	tw := tar.NewWriter(gz)
+
+	excluded := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return excluded, err
+		}
+		name, err := cleanContextPath(header.Name)
+		if err != nil {
+			continue
+		}
+		if name != dockerfile && name != ".dockerignore" && ignore.excludes(name) {
+			excluded++
+			continue
+		}
+
+		header.Name = name
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return excluded, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return excluded, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return excluded, err
+	}
+	return excluded, gz.Close()
+}
+
+// dockerIgnore holds the rules of a .dockerignore file
+type dockerIgnore struct {
+	rules []dockerIgnoreRule
+}
+
+type dockerIgnoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+// parseDockerIgnore parses .dockerignore content. Invalid patterns are skipped.
+func parseDockerIgnore(content string) *dockerIgnore {
+	ignore := &dockerIgnore{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := false
+		if strings.HasPrefix(line, "!") {
+			negate = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(path.Clean("/"+line), "/")
+		if line == "" {
+			continue
+		}
+		re, err := regexp.Compile(dockerIgnoreRegexp(line))
+		if err != nil {
+			logger.Warnf("Skipping invalid .dockerignore pattern %q: %v", line, err)
+			continue
+		}
+		ignore.rules = append(ignore.rules, dockerIgnoreRule{pattern: re, negate: negate})
+	}
+	return ignore
+}
+
+// dockerIgnoreRegexp translates a pattern: * and ? stay within a path
+// segment, ** spans any number of segments
+func dockerIgnoreRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(pattern[i:], ']'); end > 0 {
+				class := pattern[i+1 : i+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + class + "]")
+				i += end
+			} else {
+				sb.WriteString(`\[`)
+			}
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// excludes reports whether a path is excluded. A path is excluded when it or
+// one of its parent directories matches, and the last matching rule wins.
+func (d *dockerIgnore) excludes(name string) bool {
+	if d == nil {
+		return false
+	}
+	excluded := false
+	for _, rule := range d.rules {
+		if rule.matches(name) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+func (r dockerIgnoreRule) matches(name string) bool {
+	for {
+		if r.pattern.MatchString(name) {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
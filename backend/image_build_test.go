@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDockerIgnore(t *testing.T) {
+	ignore := parseDockerIgnore("# comment\nnode_modules\n*.log\n**/tmp\n!keep.log\n/build/\n")
+
+	for name, want := range map[string]bool{
+		"node_modules/a/b.js": true,
+		"app.log":             true,
+		"keep.log":            false,
+		"logs/app.log":        false,
+		"src/tmp/x":           true,
+		"tmp":                 true,
+		"build/out.bin":       true,
+		"src/main.go":         false,
+	} {
+		if got := ignore.excludes(name); got != want {
+			t.Errorf("excludes(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWriteBuildContext(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"./.dockerignore": "*.md\nDockerfile\n",
+		"./Dockerfile":    "FROM scratch\n",
+		"./README.md":     "readme",
+		"./main.go":       "package main",
+	} {
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	ignore, found, err := scanBuildContext(bytes.NewReader(buf.Bytes()), "Dockerfile")
+	if err != nil || !found {
+		t.Fatalf("scanBuildContext: found %v, err %v", found, err)
+	}
+
+	var out bytes.Buffer
+	excluded, err := writeBuildContext(&out, bytes.NewReader(buf.Bytes()), ignore, "Dockerfile")
+	if err != nil || excluded != 1 {
+		t.Fatalf("writeBuildContext: excluded %d, err %v", excluded, err)
+	}
+	tr, err := openContextArchive(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[header.Name] = true
+	}
+	if len(names) != 3 || !names["Dockerfile"] || !names[".dockerignore"] || !names["main.go"] {
+		t.Errorf("unexpected context entries: %v", names)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"remote-docker/utils"
+)
+
+// Raw `docker image inspect` output. Only the fields we use are mapped.
+type imageInspectJSON struct {
+	ID          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"`
+	Created     string   `json:"Created"`
+	Config      struct {
+		User         string              `json:"User"`
+		Env          []string            `json:"Env"`
+		Cmd          []string            `json:"Cmd"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		WorkingDir   string              `json:"WorkingDir"`
+		Labels       map[string]string   `json:"Labels"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Healthcheck  *inspectHealthcheck `json:"Healthcheck"`
+		Volumes      map[string]struct{} `json:"Volumes"` // VOLUME declarations
+	} `json:"Config"`
+	Architecture string `json:"Architecture"`
+	Os           string `json:"Os"`
+	Size         int64  `json:"Size"`
+}
+
+// inspectImages runs a single `docker image inspect` for all given references.
+// The raw command output is returned alongside so callers can surface it on errors.
+func inspectImages(username, hostname string, refs ...string) ([]imageInspectJSON, []byte, error) {
+	if len(refs) == 0 {
+		return nil, nil, nil
+	}
+
+	args := append([]string{"image", "inspect"}, refs...)
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		return nil, output, err
+	}
+
+	var inspected []imageInspectJSON
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, output, fmt.Errorf("failed to parse image inspect output: %v", err)
+	}
+	return inspected, output, nil
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Summary line of `docker image prune`
+var reclaimedSpacePattern = regexp.MustCompile(`Total reclaimed space:\s*(.+)`)
+
+// Entry of the image list
+type ImageSummary struct {
+	ID         string      `json:"id"`
+	Repository string      `json:"repository"`
+	Tag        string      `json:"tag"`
+	Created    string      `json:"created"`
+	Size       string      `json:"size"`
+	Dangling   bool        `json:"dangling"`
+	Containers []ImageUser `json:"containers,omitempty"`
+	// Counts of the last vulnerability scan, if the image has been scanned
+	Vulnerabilities *VulnerabilitySummary `json:"vulnerabilities,omitempty"`
+}
+
+// Container created from an image
+type ImageUser struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Request for image actions
+type ImageRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Image    string `json:"image"`
+	Force    bool   `json:"force"` // remove: also remove images used by stopped containers
+}
+
+// Request to tag an image
+type ImageTagRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+}
+
+// Request to prune images
+type ImagePruneRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	All      bool   `json:"all"`    // all unused images, not only dangling ones
+	DryRun   bool   `json:"dryRun"` // only report what would be removed
+}
+
+// Image that would be or was pruned
+type PrunedImage struct {
+	ID       string   `json:"id"`
+	RepoTags []string `json:"repoTags"`
+	Size     int64    `json:"size"`
+}
+
+// Result of a prune or its preview
+type ImagePruneResponse struct {
+	DryRun bool          `json:"dryRun"`
+	Images []PrunedImage `json:"images"`
+	// Sum of the image sizes. Layers shared with other images are not freed,
+	// so this is an upper bound.
+	ReclaimableBytes int64  `json:"reclaimableBytes"`
+	Reclaimed        string `json:"reclaimed,omitempty"` // as reported by docker
+	Output           string `json:"output,omitempty"`
+}
+
+// Result of an image removal
+type ImageRemoveResponse struct {
+	Success  string   `json:"success"`
+	Untagged []string `json:"untagged"`
+	Deleted  []string `json:"deleted"`
+}
+
+// Layer of the image history
+type ImageHistoryEntry struct {
+	ID        string `json:"id"`
+	Created   string `json:"created"`
+	CreatedBy string `json:"createdBy"`
+	Size      int64  `json:"size"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+// Typed `docker image inspect` result
+type ImageDetails struct {
+	ID           string   `json:"id"`
+	RepoTags     []string `json:"repoTags"`
+	RepoDigests  []string `json:"repoDigests"`
+	Created      string   `json:"created"`
+	Architecture string   `json:"architecture"`
+	Os           string   `json:"os"`
+	Size         int64    `json:"size"`
+	Config       struct {
+		User         string            `json:"user,omitempty"`
+		Entrypoint   []string          `json:"entrypoint"`
+		Cmd          []string          `json:"cmd"`
+		WorkingDir   string            `json:"workingDir,omitempty"`
+		Env          []string          `json:"env"`
+		ExposedPorts []string          `json:"exposedPorts"`
+		Labels       map[string]string `json:"labels"`
+	} `json:"config"`
+	History    []ImageHistoryEntry `json:"history"`
+	Containers []ImageUser         `json:"containers"`
+}
+
+// Inspect an image, including its layer history and the containers using it
+func inspectImage(ctx echo.Context) error {
+	var req ImageRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateImageRequest(req.Username, req.Hostname, req.Image); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	images, output, err := inspectImages(req.Username, req.Hostname, req.Image)
+	if err != nil || len(images) == 0 {
+		logger.Errorf("Error inspecting image: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect image: %v", err),
+			"output": string(output),
+		})
+	}
+	raw := images[0]
+
+	dockerCommand := utils.BuildDockerCommand("history", "--no-trunc", "--human=false", "--format", "{{json .}}", raw.ID)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	if err != nil {
+		logger.Errorf("Error reading image history: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to read image history: %v", err),
+			"output": string(output),
+		})
+	}
+
+	details := ImageDetails{
+		ID:           raw.ID,
+		RepoTags:     nonNilStrings(raw.RepoTags),
+		RepoDigests:  nonNilStrings(raw.RepoDigests),
+		Created:      raw.Created,
+		Architecture: raw.Architecture,
+		Os:           raw.Os,
+		Size:         raw.Size,
+		History:      parseImageHistory(string(output)),
+		Containers:   []ImageUser{},
+	}
+	details.Config.User = raw.Config.User
+	details.Config.Entrypoint = nonNilStrings(raw.Config.Entrypoint)
+	details.Config.Cmd = nonNilStrings(raw.Config.Cmd)
+	details.Config.WorkingDir = raw.Config.WorkingDir
+	details.Config.Env = maskEnv(raw.Config.Env)
+	details.Config.ExposedPorts = sortedKeys(raw.Config.ExposedPorts)
+	details.Config.Labels = raw.Config.Labels
+	if details.Config.Labels == nil {
+		details.Config.Labels = map[string]string{}
+	}
+
+	users, output, err := imageUsers(req.Username, req.Hostname)
+	if err != nil {
+		logger.Warnf("Error listing image users: %v, output: %s", err, string(output))
+	} else if u := users[raw.ID]; u != nil {
+		details.Containers = u
+	}
+
+	return ctx.JSON(http.StatusOK, details)
+}
+
+// Remove an image. Images used by running containers are never removed,
+// images used by stopped containers only with force.
+func removeImage(ctx echo.Context) error {
+	var req ImageRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateImageRequest(req.Username, req.Hostname, req.Image); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	images, output, err := inspectImages(req.Username, req.Hostname, req.Image)
+	if err != nil || len(images) == 0 {
+		logger.Errorf("Error inspecting image: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect image: %v", err),
+			"output": string(output),
+		})
+	}
+
+	users, output, err := imageUsers(req.Username, req.Hostname)
+	if err != nil {
+		logger.Errorf("Error listing image users: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to check containers using the image: %v", err),
+			"output": string(output),
+		})
+	}
+	if message := imageRemoveConflict(req.Image, images[0], users[images[0].ID], req.Force); message != "" {
+		return ctx.JSON(http.StatusConflict, map[string]interface{}{
+			"error":      message,
+			"containers": users[images[0].ID],
+		})
+	}
+
+	args := []string{"rmi"}
+	if req.Force {
+		args = append(args, "--force")
+	}
+	dockerCommand := utils.BuildDockerCommand(append(args, req.Image)...)
+	logger.Infof("Executing remove command: %s", dockerCommand)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	if err != nil {
+		logger.Errorf("Error removing image: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to remove image: %v", err),
+			"output": string(output),
+		})
+	}
+
+	response := ImageRemoveResponse{Success: "true", Untagged: []string{}, Deleted: []string{}}
+	for _, line := range strings.Split(string(output), "\n") {
+		if ref, ok := strings.CutPrefix(strings.TrimSpace(line), "Untagged: "); ok {
+			response.Untagged = append(response.Untagged, ref)
+		} else if id, ok := strings.CutPrefix(strings.TrimSpace(line), "Deleted: "); ok {
+			response.Deleted = append(response.Deleted, id)
+		}
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// imageRemoveConflict returns why removing ref would break containers, or ""
+// if it can go ahead
+func imageRemoveConflict(ref string, image imageInspectJSON, users []ImageUser, force bool) string {
+	// Removing one of several tags only untags, containers are not affected
+	byID := strings.HasPrefix(image.ID, "sha256:"+strings.TrimPrefix(ref, "sha256:"))
+	if len(users) == 0 || (!byID && len(image.RepoTags) > 1) {
+		return ""
+	}
+	for _, user := range users {
+		if user.State == "running" || user.State == "paused" || user.State == "restarting" {
+			return "Image is used by running containers"
+		}
+	}
+	if !force {
+		return "Image is used by containers, remove them first or use force"
+	}
+	return ""
+}
+
+// Tag an image
+func tagImage(ctx echo.Context) error {
+	var req ImageTagRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if err := validateImageRequest(req.Username, req.Hostname, req.Source); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateImageName(req.Target); err != nil || strings.Contains(req.Target, "@") {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid target: must be a name with an optional tag"})
+	}
+
+	dockerCommand := utils.BuildDockerCommand("tag", req.Source, req.Target)
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	if err != nil {
+		logger.Errorf("Error tagging image: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to tag image: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"success": "true",
+		"message": fmt.Sprintf("Tagged %s as %s", req.Source, req.Target),
+	})
+}
+
+// Prune unused images, or preview what a prune would remove
+func pruneImages(ctx echo.Context) error {
+	var req ImagePruneRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	candidates, output, err := pruneCandidates(req.Username, req.Hostname, req.All)
+	if err != nil {
+		logger.Errorf("Error listing prune candidates: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to list unused images: %v", err),
+			"output": string(output),
+		})
+	}
+
+	response := ImagePruneResponse{DryRun: req.DryRun, Images: candidates}
+	for _, image := range candidates {
+		response.ReclaimableBytes += image.Size
+	}
+	if req.DryRun {
+		return ctx.JSON(http.StatusOK, response)
+	}
+
+	args := []string{"image", "prune", "--force"}
+	if req.All {
+		args = append(args, "--all")
+	}
+	dockerCommand := utils.BuildDockerCommand(args...)
+	logger.Infof("Executing prune command: %s", dockerCommand)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	if err != nil {
+		logger.Errorf("Error pruning images: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to prune images: %v", err),
+			"output": string(output),
+		})
+	}
+	response.Output = string(output)
+	if match := reclaimedSpacePattern.FindStringSubmatch(string(output)); match != nil {
+		response.Reclaimed = strings.TrimSpace(match[1])
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// pruneCandidates lists the images `docker image prune` would remove: dangling
+// images, or with all every image, that no container (running or not) uses
+func pruneCandidates(username, hostname string, all bool) ([]PrunedImage, []byte, error) {
+	args := []string{"images", "-q", "--no-trunc"}
+	if !all {
+		args = append(args, "--filter", "dangling=true")
+	}
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		return nil, output, err
+	}
+	ids := strings.Fields(string(output))
+
+	users, output, err := imageUsers(username, hostname)
+	if err != nil {
+		return nil, output, err
+	}
+	images, output, err := inspectImages(username, hostname, unusedImageIDs(ids, users)...)
+	if err != nil {
+		return nil, output, err
+	}
+	candidates := make([]PrunedImage, 0, len(images))
+	for _, image := range images {
+		candidates = append(candidates, PrunedImage{ID: image.ID, RepoTags: nonNilStrings(image.RepoTags), Size: image.Size})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Size > candidates[j].Size })
+	return candidates, nil, nil
+}
+
+// unusedImageIDs filters out the images any container was created from
+func unusedImageIDs(ids []string, users map[string][]ImageUser) []string {
+	var unused []string
+	for _, id := range uniqueStrings(ids) {
+		if len(users[id]) == 0 {
+			unused = append(unused, id)
+		}
+	}
+	return unused
+}
+
+// imageUsers maps full image IDs to the containers created from them
+func imageUsers(username, hostname string) (map[string][]ImageUser, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("ps", "-a", "-q", "--no-trunc"))
+	if err != nil {
+		return nil, output, err
+	}
+	inspected, output, err := inspectContainers(username, hostname, strings.Fields(string(output))...)
+	if err != nil {
+		return nil, output, err
+	}
+
+	users := make(map[string][]ImageUser)
+	for _, raw := range inspected {
+		users[raw.Image] = append(users[raw.Image], ImageUser{
+			ID:    raw.ID,
+			Name:  strings.TrimPrefix(raw.Name, "/"),
+			State: raw.State.Status,
+		})
+	}
+	return users, nil, nil
+}
+
+// usersOfShortID finds the users of an image listed by its short ID
+func usersOfShortID(users map[string][]ImageUser, shortID string) []ImageUser {
+	for id, u := range users {
+		if strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), strings.TrimPrefix(shortID, "sha256:")) {
+			return u
+		}
+	}
+	return nil
+}
+
+// parseImageHistory parses `docker history --format '{{json .}}'` lines
+func parseImageHistory(output string) []ImageHistoryEntry {
+	history := []ImageHistoryEntry{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var raw struct {
+			ID        string `json:"ID"`
+			CreatedAt string `json:"CreatedAt"`
+			CreatedBy string `json:"CreatedBy"`
+			Size      string `json:"Size"`
+			Comment   string `json:"Comment"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(raw.Size, 10, 64)
+		history = append(history, ImageHistoryEntry{
+			ID:        raw.ID,
+			Created:   raw.CreatedAt,
+			CreatedBy: raw.CreatedBy,
+			Size:      size,
+			Comment:   raw.Comment,
+		})
+	}
+	return history
+}
+
+func validateImageRequest(username, hostname, image string) error {
+	if hostname == "" || username == "" || image == "" {
+		return fmt.Errorf("Missing required fields")
+	}
+	if err := validateConnection(username, hostname); err != nil {
+		return err
+	}
+	if err := utils.ValidateImageName(strings.TrimPrefix(image, "sha256:")); err != nil {
+		return fmt.Errorf("Invalid image name: %v", err)
+	}
+	return nil
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func uniqueStrings(items []string) []string {
+	seen := make(map[string]bool, len(items))
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
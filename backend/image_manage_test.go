@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseImageHistory(t *testing.T) {
+	output := `{"Comment":"","CreatedAt":"2024-05-01T10:00:00Z","CreatedBy":"/bin/sh -c #(nop)  CMD [\"nginx\"]","ID":"sha256:aaaa","Size":"0"}
+{"Comment":"buildkit.dockerfile.v0","CreatedAt":"2024-05-01T09:59:00Z","CreatedBy":"RUN apt-get update","ID":"<missing>","Size":"52428800"}
+not json
+{"Comment":"","CreatedAt":"2024-04-01T00:00:00Z","CreatedBy":"ADD file:abc in /","ID":"<missing>","Size":"77.8MB"}
+`
+	want := []ImageHistoryEntry{
+		{ID: "sha256:aaaa", Created: "2024-05-01T10:00:00Z", CreatedBy: `/bin/sh -c #(nop)  CMD ["nginx"]`, Size: 0},
+		{ID: "<missing>", Created: "2024-05-01T09:59:00Z", CreatedBy: "RUN apt-get update", Size: 52428800, Comment: "buildkit.dockerfile.v0"},
+		// Human readable sizes are not parsed, the history is requested with --human=false
+		{ID: "<missing>", Created: "2024-04-01T00:00:00Z", CreatedBy: "ADD file:abc in /", Size: 0},
+	}
+	if got := parseImageHistory(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if got := parseImageHistory(""); got == nil || len(got) != 0 {
+		t.Errorf("expected an empty, non-nil history, got %#v", got)
+	}
+}
+
+func TestUsersOfShortID(t *testing.T) {
+	web := []ImageUser{{ID: "c1", Name: "web", State: "running"}}
+	users := map[string][]ImageUser{
+		"sha256:4a5b6c7d8e9f00112233445566778899aabbccddeeff00112233445566778899": web,
+		"sha256:ffff6c7d8e9f00112233445566778899aabbccddeeff00112233445566778899": {{ID: "c2", Name: "job", State: "exited"}},
+	}
+	tests := []struct {
+		shortID string
+		want    []ImageUser
+	}{
+		{"4a5b6c7d8e9f", web},
+		{"sha256:4a5b6c7d8e9f", web},
+		{"0123456789ab", nil},
+	}
+	for _, tt := range tests {
+		if got := usersOfShortID(users, tt.shortID); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.shortID, got, tt.want)
+		}
+	}
+}
+
+func TestUnusedImageIDs(t *testing.T) {
+	users := map[string][]ImageUser{
+		"sha256:used": {{ID: "c1", Name: "web", State: "running"}},
+		"sha256:old":  {{ID: "c2", Name: "job", State: "exited"}},
+	}
+	// docker images lists an image once per tag
+	ids := []string{"sha256:used", "sha256:free", "sha256:old", "sha256:free", "sha256:dangling"}
+	want := []string{"sha256:free", "sha256:dangling"}
+	if got := unusedImageIDs(ids, users); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestImageRemoveConflict(t *testing.T) {
+	const id = "sha256:4a5b6c7d8e9f00112233445566778899aabbccddeeff00112233445566778899"
+	single := imageInspectJSON{ID: id, RepoTags: []string{"web:1"}}
+	tagged := imageInspectJSON{ID: id, RepoTags: []string{"web:1", "web:latest"}}
+	running := []ImageUser{{ID: "c1", Name: "web", State: "running"}, {ID: "c2", Name: "job", State: "exited"}}
+	stopped := []ImageUser{{ID: "c2", Name: "job", State: "exited"}}
+	restarting := []ImageUser{{ID: "c3", Name: "crashy", State: "restarting"}}
+
+	tests := []struct {
+		name  string
+		ref   string
+		image imageInspectJSON
+		users []ImageUser
+		force bool
+		want  string
+	}{
+		{"unused", "web:1", single, nil, false, ""},
+		{"last tag, running", "web:1", single, running, true, "Image is used by running containers"},
+		{"last tag, restarting", "web:1", single, restarting, true, "Image is used by running containers"},
+		{"last tag, stopped", "web:1", single, stopped, false, "Image is used by containers, remove them first or use force"},
+		{"last tag, stopped, forced", "web:1", single, stopped, true, ""},
+		{"one of several tags only untags", "web:latest", tagged, running, false, ""},
+		{"short ID of a tagged image", "4a5b6c7d8e9f", tagged, running, true, "Image is used by running containers"},
+		{"full ID of a tagged image", id, tagged, stopped, false, "Image is used by containers, remove them first or use force"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageRemoveConflict(tt.ref, tt.image, tt.users, tt.force); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Default number of concurrent pulls per environment, overridable with the
+// maxConcurrentPulls setting
+const defaultMaxConcurrentPulls = 2
+
+// Errors from the engine API that need the credentials only the docker CLI has
+var registryAuthErrorPattern = regexp.MustCompile(`(?i)(unauthorized|authentication required|access denied|denied:|no basic auth credentials)`)
+
+func init() {
+	operations.setLimit("pull", func() int {
+		return loadSettingInt("maxConcurrentPulls", defaultMaxConcurrentPulls)
+	})
+}
+
+// Request to pull an image
+type ImagePullRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Image    string `json:"image"`    // name[:tag] or name@sha256:digest
+	Platform string `json:"platform"` // e.g. linux/arm64, defaults to the host platform
+}
+
+// Progress of a single layer
+type LayerProgress struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Current int64  `json:"current"`
+	Total   int64  `json:"total"`
+}
+
+// Progress of a pull
+type PullProgress struct {
+	Status string          `json:"status"` // last overall status line
+	Layers []LayerProgress `json:"layers"`
+	// Byte counts are only available through the engine API, the CLI fallback reports states only
+	Detailed bool `json:"detailed"`
+}
+
+// Result of a finished pull
+type PullResult struct {
+	ImageID string `json:"imageId"`
+	Digest  string `json:"digest"`
+}
+
+// Start pulling an image as a tracked operation
+func pullImage(ctx echo.Context) error {
+	var req ImagePullRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.Image == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateImageName(req.Image); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid image name: %v", err)})
+	}
+	if req.Platform != "" {
+		if err := utils.ValidatePlatform(req.Platform); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid platform: %v", err)})
+		}
+	}
+
+	op := operations.start("pull", req.Username, req.Hostname, req.Image, func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runImagePull(opCtx, req, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+// Prints the endpoint of the current docker context if curl can reach it.
+// docker context inspect resolves DOCKER_HOST and DOCKER_CONTEXT like every
+// other docker command, so the API call goes to the same daemon as the CLI.
+const pullEndpointProbe = `command -v curl >/dev/null 2>&1 || exit 0; ` +
+	`host=$(docker context inspect --format '{{.Endpoints.docker.Host}}' 2>/dev/null) || exit 0; ` +
+	`case "$host" in unix://*) test -S "${host#unix://}" && echo "$host";; esac; true`
+
+// runImagePull pulls through the engine API when curl and the daemon's unix
+// socket are available, which gives byte level progress. Otherwise, or when
+// the registry needs credentials stored for the docker CLI, it falls back to
+// `docker pull`.
+func runImagePull(ctx context.Context, req ImagePullRequest, update func(interface{})) (interface{}, error) {
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, pullEndpointProbe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe host: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	progress := newPullProgressTracker(update)
+	if socket := pullAPISocket(string(output)); socket != "" {
+		progress.detailed = true
+		err = streamPull(ctx, req, pullAPICommand(socket, req.Image, req.Platform), progress)
+		if err != nil && ctx.Err() == nil && registryAuthErrorPattern.MatchString(err.Error()) {
+			logger.Infof("Pull of %s needs registry credentials, retrying with docker pull", req.Image)
+			progress = newPullProgressTracker(update)
+			err = streamPull(ctx, req, pullCLICommand(req.Image, req.Platform), progress)
+		}
+	} else {
+		err = streamPull(ctx, req, pullCLICommand(req.Image, req.Platform), progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	images, output, err := inspectImages(req.Username, req.Hostname, req.Image)
+	if err != nil || len(images) == 0 {
+		return nil, fmt.Errorf("pulled, but failed to inspect image: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	result := PullResult{ImageID: images[0].ID, Digest: progress.digest}
+	if result.Digest == "" {
+		result.Digest = repoDigest(images[0].RepoDigests, req.Image)
+	}
+	return result, nil
+}
+
+func streamPull(ctx context.Context, req ImagePullRequest, command string, progress *pullProgressTracker) error {
+	logger.Infof("Executing pull command: %s", command)
+	lines := newLineWriter(progress.handleLine)
+	err := tunnelManager.StreamCommand(ctx, req.Username, req.Hostname, command, nil, lines)
+	if flushErr := lines.Flush(); err == nil {
+		err = flushErr
+	}
+	if err == nil && progress.err != "" {
+		err = fmt.Errorf("%s", progress.err)
+	}
+	return err
+}
+
+// pullAPISocket returns the socket path printed by pullEndpointProbe, or ""
+// when the daemon is not reachable over a local unix socket (tcp://, ssh://)
+func pullAPISocket(output string) string {
+	socket, ok := strings.CutPrefix(strings.TrimSpace(output), "unix://")
+	if !ok {
+		return ""
+	}
+	cleaned, err := utils.CleanContainerPath(socket)
+	if err != nil {
+		return ""
+	}
+	return cleaned
+}
+
+// pullAPICommand calls POST /images/create on the engine socket
+func pullAPICommand(socket, image, platform string) string {
+	name, tag := splitImageReference(image)
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag)
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+	return "curl -sS --no-buffer --unix-socket " + utils.ShellEscape(socket) + " -X POST " +
+		utils.ShellEscape("http://localhost/images/create?"+query.Encode())
+}
+
+// pullCLICommand runs docker pull, which reports progress on stdout
+func pullCLICommand(image, platform string) string {
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	return utils.BuildDockerCommand(append(args, image)...) + " 2>&1"
+}
+
+// splitImageReference splits a reference into repository and tag or digest
+func splitImageReference(image string) (string, string) {
+	if name, digest, ok := strings.Cut(image, "@"); ok {
+		return name, digest
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// repoDigest picks the digest recorded for the pulled repository
+func repoDigest(repoDigests []string, image string) string {
+	name, _ := splitImageReference(image)
+	for _, rd := range repoDigests {
+		repo, digest, _ := strings.Cut(rd, "@")
+		if repo == name || strings.HasSuffix(repo, "/"+name) {
+			return digest
+		}
+	}
+	if len(repoDigests) > 0 {
+		_, digest, _ := strings.Cut(repoDigests[0], "@")
+		return digest
+	}
+	return ""
+}
+
+// pullProgressTracker turns pull output into layer progress. It understands
+// the JSON messages of the engine API as well as `docker pull` text output.
+type pullProgressTracker struct {
+	update   func(interface{})
+	layers   map[string]*LayerProgress
+	order    []string
+	status   string
+	digest   string
+	err      string
+	detailed bool
+}
+
+func newPullProgressTracker(update func(interface{})) *pullProgressTracker {
+	return &pullProgressTracker{update: update, layers: make(map[string]*LayerProgress)}
+}
+
+// Message of the engine API progress stream
+type pullMessage struct {
+	Status         string `json:"status"`
+	ID             string `json:"id"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error   string `json:"error"`
+	Message string `json:"message"` // error responses of the API itself
+}
+
+func (p *pullProgressTracker) handleLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	var msg pullMessage
+	if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &msg) == nil {
+		switch {
+		case msg.Error != "":
+			p.err = msg.Error
+		case msg.Message != "":
+			p.err = msg.Message
+		default:
+			p.apply(msg.ID, msg.Status, msg.ProgressDetail.Current, msg.ProgressDetail.Total)
+		}
+	} else if id, status, ok := strings.Cut(line, ": "); ok && !strings.Contains(id, " ") && id != "Digest" && id != "Status" {
+		p.apply(id, status, 0, 0)
+	} else {
+		if strings.HasPrefix(line, "Error") {
+			p.err = line
+		}
+		p.apply("", line, 0, 0)
+	}
+
+	p.update(p.snapshot())
+	return nil
+}
+
+func (p *pullProgressTracker) apply(id, status string, current, total int64) {
+	if strings.HasPrefix(status, "Digest: ") {
+		p.digest = strings.TrimPrefix(status, "Digest: ")
+	}
+	// Lines without an ID, or with the tag as ID, describe the whole pull
+	if id == "" || strings.HasPrefix(status, "Pulling from") || strings.HasPrefix(status, "Digest: ") || strings.HasPrefix(status, "Status: ") {
+		p.status = strings.TrimPrefix(status, "Status: ")
+		return
+	}
+
+	layer, ok := p.layers[id]
+	if !ok {
+		layer = &LayerProgress{ID: id}
+		p.layers[id] = layer
+		p.order = append(p.order, id)
+	}
+	layer.Status = status
+	switch {
+	case total > 0:
+		layer.Current, layer.Total = current, total
+	case status == "Download complete" || status == "Pull complete" || status == "Already exists":
+		layer.Current = layer.Total
+	}
+}
+
+func (p *pullProgressTracker) snapshot() PullProgress {
+	layers := make([]LayerProgress, 0, len(p.order))
+	for _, id := range p.order {
+		layers = append(layers, *p.layers[id])
+	}
+	return PullProgress{Status: p.status, Layers: layers, Detailed: p.detailed}
+}
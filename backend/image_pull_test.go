@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPullProgressTracker(t *testing.T) {
+	var last PullProgress
+	p := newPullProgressTracker(func(progress interface{}) { last = progress.(PullProgress) })
+
+	for _, line := range []string{
+		`{"status":"Pulling from library/nginx","id":"latest"}`,
+		`{"status":"Pulling fs layer","progressDetail":{},"id":"a1"}`,
+		`{"status":"Downloading","progressDetail":{"current":512,"total":2048},"id":"a1"}`,
+		`{"status":"Already exists","progressDetail":{},"id":"b2"}`,
+		`{"status":"Digest: sha256:abc"}`,
+		`{"status":"Status: Downloaded newer image for nginx:latest"}`,
+	} {
+		p.handleLine(line)
+	}
+	if len(last.Layers) != 2 || last.Layers[0].Current != 512 || last.Layers[0].Total != 2048 {
+		t.Errorf("unexpected layers: %+v", last.Layers)
+	}
+	if p.digest != "sha256:abc" || last.Status != "Downloaded newer image for nginx:latest" {
+		t.Errorf("unexpected digest %q or status %q", p.digest, last.Status)
+	}
+
+	cli := newPullProgressTracker(func(interface{}) {})
+	cli.handleLine("c3: Pull complete")
+	cli.handleLine("Error response from daemon: manifest unknown")
+	if cli.layers["c3"] == nil || cli.layers["c3"].Status != "Pull complete" || cli.err == "" {
+		t.Errorf("unexpected CLI parse result: %+v, err %q", cli.layers, cli.err)
+	}
+
+	if name, tag := splitImageReference("registry:5000/app"); name != "registry:5000/app" || tag != "latest" {
+		t.Errorf("splitImageReference = %s, %s", name, tag)
+	}
+}
+
+func TestPullAPISocket(t *testing.T) {
+	tests := map[string]string{
+		"unix:///var/run/docker.sock\n":       "/var/run/docker.sock",
+		"unix:///run/user/1000/docker.sock\n": "/run/user/1000/docker.sock",
+		"tcp://10.0.0.5:2375\n":               "",
+		"ssh://deploy@builder\n":              "",
+		"":                                    "",
+		"unix://relative.sock":                "",
+	}
+	for output, want := range tests {
+		if got := pullAPISocket(output); got != want {
+			t.Errorf("pullAPISocket(%q) = %q, want %q", output, got, want)
+		}
+	}
+
+	command := pullAPICommand("/run/user/1000/docker.sock", "nginx:1.25", "")
+	if !strings.HasPrefix(command, "curl -sS --no-buffer --unix-socket /run/user/1000/docker.sock -X POST ") {
+		t.Errorf("unexpected pull command: %s", command)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Default number of concurrent scans per environment, overridable with the
+// maxConcurrentScans setting
+const defaultMaxConcurrentScans = 1
+
+// Scanner images used when the scanner CLI is not installed on the host
+const (
+	trivyScannerImage = "aquasec/trivy:latest"
+	grypeScannerImage = "anchore/grype:latest"
+)
+
+// Hex part of a full image ID, reports are stored under it
+var imageIDHexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Normalized severities, from most to least severe
+var scanSeverities = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"}
+
+// Scan reports, stored next to the settings
+var scanReports = newScanStore(filepath.Join(filepath.Dir(settingsFilePath), "scans"))
+
+func init() {
+	operations.setLimit("scan", func() int {
+		return loadSettingInt("maxConcurrentScans", defaultMaxConcurrentScans)
+	})
+}
+
+// imageScanner adapts a vulnerability scanner CLI
+type imageScanner interface {
+	Name() string
+	// Command returns the shell command scanning an image on the remote host,
+	// printing the scanner's JSON report on stdout. With inContainer the
+	// scanner runs from its own image against the host's docker socket.
+	Command(image string, inContainer bool) string
+	// Parse normalizes the scanner's JSON report
+	Parse(output []byte) ([]Vulnerability, error)
+}
+
+var imageScanners = map[string]imageScanner{
+	"trivy": trivyScanner{},
+	"grype": grypeScanner{},
+}
+
+// Request to scan an image
+type ImageScanRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Image    string `json:"image"`
+	Scanner  string `json:"scanner"` // trivy (default) or grype
+	// host runs the installed CLI, container runs the scanner image, auto
+	// (default) prefers the installed CLI
+	Mode string `json:"mode"`
+}
+
+// Request for the stored report of an image
+type ImageScanReportRequest struct {
+	ImageId string `json:"imageId"`
+}
+
+// A known vulnerability of a package in an image
+type Vulnerability struct {
+	ID       string `json:"id"` // CVE or advisory ID
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	Severity string `json:"severity"`
+	FixedIn  string `json:"fixedIn,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// Vulnerability counts by severity, shown in the image list
+type VulnerabilitySummary struct {
+	Scanner   string `json:"scanner"`
+	ScannedAt string `json:"scannedAt"`
+	Critical  int    `json:"critical"`
+	High      int    `json:"high"`
+	Medium    int    `json:"medium"`
+	Low       int    `json:"low"`
+	Unknown   int    `json:"unknown"`
+	Fixable   int    `json:"fixable"`
+}
+
+// Scan report of an image, stored per image ID
+type ScanReport struct {
+	ImageID         string               `json:"imageId"`
+	Image           string               `json:"image"`
+	RepoDigests     []string             `json:"repoDigests"`
+	Summary         VulnerabilitySummary `json:"summary"`
+	Vulnerabilities []Vulnerability      `json:"vulnerabilities"`
+}
+
+// Start scanning an image as a tracked operation
+func scanImage(ctx echo.Context) error {
+	var req ImageScanRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.Image == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateImageName(req.Image); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid image name: %v", err)})
+	}
+	if req.Scanner == "" {
+		req.Scanner = "trivy"
+	}
+	scanner, ok := imageScanners[req.Scanner]
+	if !ok {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown scanner: %s", req.Scanner)})
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = "auto"
+	case "auto", "host", "container":
+	default:
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown scan mode: %s", req.Mode)})
+	}
+
+	op := operations.start("scan", req.Username, req.Hostname, req.Image, func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runImageScan(opCtx, req, scanner, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+func runImageScan(ctx context.Context, req ImageScanRequest, scanner imageScanner, update func(interface{})) (interface{}, error) {
+	images, output, err := inspectImages(req.Username, req.Hostname, req.Image)
+	if err != nil || len(images) == 0 {
+		return nil, fmt.Errorf("failed to inspect image: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	inContainer := req.Mode == "container"
+	if req.Mode == "auto" {
+		output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname,
+			"command -v "+scanner.Name()+" >/dev/null 2>&1 && echo host || echo container")
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe host: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		inContainer = strings.TrimSpace(string(output)) == "container"
+	}
+	update(map[string]string{"scanner": scanner.Name(), "status": "scanning"})
+
+	run := func(command string) ([]byte, error) {
+		logger.Infof("Executing scan command: %s", command)
+		var stdout bytes.Buffer
+		err := tunnelManager.StreamCommand(ctx, req.Username, req.Hostname, command, nil, &stdout)
+		return stdout.Bytes(), err
+	}
+	return runScanner(scanner, run, req.Image, inContainer, images[0], scanReports)
+}
+
+// runScanner runs a scanner through run and stores the normalized report
+func runScanner(scanner imageScanner, run func(command string) ([]byte, error), image string, inContainer bool, inspected imageInspectJSON, store *scanStore) (*ScanReport, error) {
+	output, err := run(scanner.Command(image, inContainer))
+	if err != nil {
+		return nil, fmt.Errorf("%s scan failed: %v", scanner.Name(), err)
+	}
+	vulnerabilities, err := scanner.Parse(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s report: %v", scanner.Name(), err)
+	}
+
+	report := newScanReport(scanner.Name(), image, inspected, vulnerabilities)
+	if err := store.save(report); err != nil {
+		return nil, fmt.Errorf("failed to store report: %v", err)
+	}
+	return report, nil
+}
+
+// newScanReport sorts the findings by severity and counts them
+func newScanReport(scanner, image string, inspected imageInspectJSON, vulnerabilities []Vulnerability) *ScanReport {
+	rank := make(map[string]int, len(scanSeverities))
+	for i, severity := range scanSeverities {
+		rank[severity] = i
+	}
+	sort.SliceStable(vulnerabilities, func(i, j int) bool {
+		if rank[vulnerabilities[i].Severity] != rank[vulnerabilities[j].Severity] {
+			return rank[vulnerabilities[i].Severity] < rank[vulnerabilities[j].Severity]
+		}
+		return vulnerabilities[i].ID < vulnerabilities[j].ID
+	})
+
+	summary := VulnerabilitySummary{Scanner: scanner, ScannedAt: time.Now().Format(time.RFC3339)}
+	for _, v := range vulnerabilities {
+		switch v.Severity {
+		case "CRITICAL":
+			summary.Critical++
+		case "HIGH":
+			summary.High++
+		case "MEDIUM":
+			summary.Medium++
+		case "LOW":
+			summary.Low++
+		default:
+			summary.Unknown++
+		}
+		if v.FixedIn != "" {
+			summary.Fixable++
+		}
+	}
+	return &ScanReport{
+		ImageID:         inspected.ID,
+		Image:           image,
+		RepoDigests:     nonNilStrings(inspected.RepoDigests),
+		Summary:         summary,
+		Vulnerabilities: vulnerabilities,
+	}
+}
+
+// Get the stored scan report of an image
+func getScanReport(ctx echo.Context) error {
+	var req ImageScanReportRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.ImageId == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if !isFullImageID(req.ImageId) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid image ID"})
+	}
+	report, err := scanReports.load(req.ImageId)
+	if err != nil {
+		logger.Errorf("Error reading scan report: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to read scan report: %v", err)})
+	}
+	if report == nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Image has not been scanned"})
+	}
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// normalizeSeverity maps scanner severities onto scanSeverities
+func normalizeSeverity(severity string) string {
+	severity = strings.ToUpper(severity)
+	switch severity {
+	case "CRITICAL", "HIGH", "MEDIUM", "LOW":
+		return severity
+	case "NEGLIGIBLE":
+		return "LOW"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// trivyScanner runs Trivy (https://trivy.dev)
+type trivyScanner struct{}
+
+func (trivyScanner) Name() string { return "trivy" }
+
+func (trivyScanner) Command(image string, inContainer bool) string {
+	args := []string{"image", "--quiet", "--format", "json", image}
+	if !inContainer {
+		return "trivy " + shellEscapeAll(args)
+	}
+	run := []string{"run", "--rm",
+		"-v", "/var/run/docker.sock:/var/run/docker.sock",
+		"-v", "remote-docker-trivy-cache:/root/.cache",
+		trivyScannerImage}
+	return utils.BuildDockerCommand(append(run, args...)...)
+}
+
+func (trivyScanner) Parse(output []byte) ([]Vulnerability, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string `json:"VulnerabilityID"`
+				PkgName          string `json:"PkgName"`
+				InstalledVersion string `json:"InstalledVersion"`
+				FixedVersion     string `json:"FixedVersion"`
+				Severity         string `json:"Severity"`
+				Title            string `json:"Title"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, err
+	}
+
+	vulnerabilities := []Vulnerability{}
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			vulnerabilities = append(vulnerabilities, Vulnerability{
+				ID:       v.VulnerabilityID,
+				Package:  v.PkgName,
+				Version:  v.InstalledVersion,
+				Severity: normalizeSeverity(v.Severity),
+				FixedIn:  v.FixedVersion,
+				Title:    v.Title,
+			})
+		}
+	}
+	return vulnerabilities, nil
+}
+
+// grypeScanner runs Grype (https://github.com/anchore/grype)
+type grypeScanner struct{}
+
+func (grypeScanner) Name() string { return "grype" }
+
+func (grypeScanner) Command(image string, inContainer bool) string {
+	args := []string{"docker:" + image, "-q", "-o", "json"}
+	if !inContainer {
+		return "grype " + shellEscapeAll(args)
+	}
+	run := []string{"run", "--rm",
+		"-v", "/var/run/docker.sock:/var/run/docker.sock",
+		"-v", "remote-docker-grype-cache:/.cache/grype",
+		grypeScannerImage}
+	return utils.BuildDockerCommand(append(run, args...)...)
+}
+
+func (grypeScanner) Parse(output []byte) ([]Vulnerability, error) {
+	var report struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID          string `json:"id"`
+				Severity    string `json:"severity"`
+				Description string `json:"description"`
+				Fix         struct {
+					Versions []string `json:"versions"`
+				} `json:"fix"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, err
+	}
+
+	vulnerabilities := []Vulnerability{}
+	for _, m := range report.Matches {
+		vulnerabilities = append(vulnerabilities, Vulnerability{
+			ID:       m.Vulnerability.ID,
+			Package:  m.Artifact.Name,
+			Version:  m.Artifact.Version,
+			Severity: normalizeSeverity(m.Vulnerability.Severity),
+			FixedIn:  strings.Join(m.Vulnerability.Fix.Versions, ", "),
+			Title:    m.Vulnerability.Description,
+		})
+	}
+	return vulnerabilities, nil
+}
+
+// scanStore keeps one report file per image ID and caches the summaries for
+// the image list
+type scanStore struct {
+	dir       string
+	mu        sync.Mutex
+	summaries map[string]VulnerabilitySummary // full image ID -> summary
+}
+
+func newScanStore(dir string) *scanStore {
+	return &scanStore{dir: dir}
+}
+
+// isFullImageID reports whether id is a full image ID, with or without the
+// sha256: prefix
+func isFullImageID(id string) bool {
+	return imageIDHexPattern.MatchString(strings.TrimPrefix(id, "sha256:"))
+}
+
+func (s *scanStore) path(imageID string) (string, error) {
+	if !isFullImageID(imageID) {
+		return "", fmt.Errorf("invalid image ID: %s", imageID)
+	}
+	return filepath.Join(s.dir, strings.TrimPrefix(imageID, "sha256:")+".json"), nil
+}
+
+func (s *scanStore) save(report *ScanReport) error {
+	path, err := s.path(report.ImageID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if s.summaries != nil {
+		s.summaries[report.ImageID] = report.Summary
+	}
+	return nil
+}
+
+// load returns the report of an image, nil if it has not been scanned
+func (s *scanStore) load(imageID string) (*ScanReport, error) {
+	path, err := s.path(imageID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report ScanReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// summaryOfShortID returns the summary of the image with the given short ID
+func (s *scanStore) summaryOfShortID(shortID string) *VulnerabilitySummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.summaries == nil {
+		s.loadSummariesLocked()
+	}
+	prefix := strings.TrimPrefix(shortID, "sha256:")
+	for id, summary := range s.summaries {
+		if strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), prefix) {
+			summary := summary
+			return &summary
+		}
+	}
+	return nil
+}
+
+func (s *scanStore) loadSummariesLocked() {
+	s.summaries = make(map[string]VulnerabilitySummary)
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var report struct {
+			ImageID string               `json:"imageId"`
+			Summary VulnerabilitySummary `json:"summary"`
+		}
+		if err := json.Unmarshal(data, &report); err != nil {
+			logger.Warnf("Skipping unreadable scan report %s: %v", entry.Name(), err)
+			continue
+		}
+		s.summaries[report.ImageID] = report.Summary
+	}
+}
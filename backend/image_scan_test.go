@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeScanner emits canned Trivy JSON
+type fakeScanner struct {
+	trivyScanner
+}
+
+func (fakeScanner) Name() string { return "fake" }
+
+func (fakeScanner) Command(image string, inContainer bool) string { return "fake-scan " + image }
+
+const cannedTrivyReport = `{"Results":[{"Target":"debian","Vulnerabilities":[
+	{"VulnerabilityID":"CVE-2024-0002","PkgName":"zlib","InstalledVersion":"1.2","Severity":"LOW"},
+	{"VulnerabilityID":"CVE-2024-0001","PkgName":"openssl","InstalledVersion":"3.0.1","FixedVersion":"3.0.2","Severity":"CRITICAL","Title":"overflow"}
+]}]}`
+
+func TestRunScannerStoresReport(t *testing.T) {
+	store := newScanStore(t.TempDir())
+	imageID := "sha256:" + strings.Repeat("ab", 32)
+	var ranCommand string
+	run := func(command string) ([]byte, error) {
+		ranCommand = command
+		return []byte(cannedTrivyReport), nil
+	}
+
+	report, err := runScanner(fakeScanner{}, run, "app:1", false, imageInspectJSON{ID: imageID}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranCommand != "fake-scan app:1" {
+		t.Errorf("unexpected command %q", ranCommand)
+	}
+	if len(report.Vulnerabilities) != 2 || report.Vulnerabilities[0].ID != "CVE-2024-0001" || report.Vulnerabilities[0].FixedIn != "3.0.2" {
+		t.Errorf("unexpected vulnerabilities: %+v", report.Vulnerabilities)
+	}
+	if report.Summary.Critical != 1 || report.Summary.Low != 1 || report.Summary.Fixable != 1 || report.Summary.Scanner != "fake" {
+		t.Errorf("unexpected summary: %+v", report.Summary)
+	}
+
+	stored, err := store.load(imageID)
+	if err != nil || stored == nil || len(stored.Vulnerabilities) != 2 {
+		t.Fatalf("load = %+v, %v", stored, err)
+	}
+	// A fresh store reads the summaries back from disk
+	if summary := newScanStore(store.dir).summaryOfShortID(strings.Repeat("ab", 6)); summary == nil || summary.Critical != 1 {
+		t.Errorf("unexpected summary by short ID: %+v", summary)
+	}
+	if newScanStore(store.dir).summaryOfShortID("cdcdcdcdcdcd") != nil {
+		t.Error("expected no summary for an unscanned image")
+	}
+}
+
+func TestGrypeParse(t *testing.T) {
+	output := `{"matches":[{"vulnerability":{"id":"GHSA-1","severity":"Negligible","fix":{"versions":["1.1","2.0"]}},"artifact":{"name":"lib","version":"1.0"}}]}`
+	vulnerabilities, err := grypeScanner{}.Parse([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Vulnerability{ID: "GHSA-1", Package: "lib", Version: "1.0", Severity: "LOW", FixedIn: "1.1, 2.0"}
+	if len(vulnerabilities) != 1 || vulnerabilities[0] != want {
+		t.Errorf("unexpected vulnerabilities: %+v", vulnerabilities)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Default number of concurrent transfers per environment, overridable with
+// the maxConcurrentTransfers setting
+const defaultMaxConcurrentTransfers = 1
+
+func init() {
+	operations.setLimit("transfer", func() int {
+		return loadSettingInt("maxConcurrentTransfers", defaultMaxConcurrentTransfers)
+	})
+}
+
+// Source or destination of a transfer: the local Docker Desktop engine or a remote host
+type TransferEndpoint struct {
+	Local    bool   `json:"local"`
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+}
+
+// Request to copy images between engines without a registry
+type ImageTransferRequest struct {
+	Source       TransferEndpoint `json:"source"`
+	Destination  TransferEndpoint `json:"destination"`
+	Images       []string         `json:"images"`
+	Compress     bool             `json:"compress"`     // gzip the stream, worth it over slow links
+	SkipExisting bool             `json:"skipExisting"` // skip images whose ID the destination already has
+}
+
+// Progress of a transfer
+type TransferProgress struct {
+	Phase            string `json:"phase"` // checking, transferring or tagging
+	BytesTransferred int64  `json:"bytesTransferred"`
+	// Uncompressed size of the images, the stream is smaller when compressed
+	// or when layers are shared
+	EstimatedBytes int64 `json:"estimatedBytes"`
+}
+
+// Result of a transfer
+type TransferResult struct {
+	Transferred      []string `json:"transferred"`
+	Skipped          []string `json:"skipped"`
+	Loaded           []string `json:"loaded"` // as reported by docker load
+	BytesTransferred int64    `json:"bytesTransferred"`
+}
+
+// Start copying images from one engine to another as a tracked operation
+func transferImages(ctx echo.Context) error {
+	var req ImageTransferRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if len(req.Images) == 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	for _, endpoint := range []TransferEndpoint{req.Source, req.Destination} {
+		if err := endpoint.validate(); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	if req.Source == req.Destination {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Source and destination must differ"})
+	}
+	for _, image := range req.Images {
+		if err := utils.ValidateImageName(image); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid image name %s: %v", image, err)})
+		}
+	}
+
+	// The operation belongs to the remote side, the destination if both are remote
+	owner := req.Destination
+	if owner.Local {
+		owner = req.Source
+	}
+	target := fmt.Sprintf("%s → %s", req.Source, req.Destination)
+	op := operations.start("transfer", owner.Username, owner.Hostname, target, func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runImageTransfer(opCtx, req, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+func runImageTransfer(ctx context.Context, req ImageTransferRequest, update func(interface{})) (interface{}, error) {
+	progress := TransferProgress{Phase: "checking"}
+	update(progress)
+	result := TransferResult{Transferred: []string{}, Skipped: []string{}, Loaded: []string{}}
+
+	// ID and size of every requested image at the source
+	var out bytes.Buffer
+	args := append([]string{"image", "inspect", "--format", "{{.Id}}|{{.Size}}"}, req.Images...)
+	if err := req.Source.docker(ctx, nil, &out, args...); err != nil {
+		return nil, fmt.Errorf("failed to inspect images at %s: %v", req.Source, err)
+	}
+	sourceIDs := make(map[string]string)
+	sizes := make(map[string]int64)
+	for i, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if i >= len(req.Images) {
+			break
+		}
+		id, size, _ := strings.Cut(line, "|")
+		sourceIDs[req.Images[i]] = id
+		sizes[id], _ = strconv.ParseInt(size, 10, 64)
+	}
+
+	existing := make(map[string]bool)
+	if req.SkipExisting {
+		out.Reset()
+		if err := req.Destination.docker(ctx, nil, &out, "images", "-q", "--no-trunc"); err != nil {
+			return nil, fmt.Errorf("failed to list images at %s: %v", req.Destination, err)
+		}
+		for _, id := range strings.Fields(out.String()) {
+			existing[id] = true
+		}
+	}
+
+	var toSave []string
+	counted := make(map[string]bool)
+	for _, image := range req.Images {
+		id := sourceIDs[image]
+		if existing[id] {
+			result.Skipped = append(result.Skipped, image)
+			continue
+		}
+		toSave = append(toSave, image)
+		if !counted[id] {
+			counted[id] = true
+			progress.EstimatedBytes += sizes[id]
+		}
+	}
+
+	if len(toSave) > 0 {
+		progress.Phase = "transferring"
+		update(progress)
+		loaded, transferred, err := streamImages(ctx, req, toSave, func(n int64) {
+			progress.BytesTransferred = n
+			update(progress)
+		})
+		result.BytesTransferred = transferred
+		if err != nil {
+			return result, err
+		}
+		result.Transferred = toSave
+		result.Loaded = loaded
+	}
+
+	// The image exists under another name, make sure the requested one exists too
+	if len(result.Skipped) > 0 {
+		progress.Phase = "tagging"
+		update(progress)
+		for _, image := range result.Skipped {
+			if strings.Contains(image, "@") || strings.HasPrefix(sourceIDs[image], image) {
+				continue
+			}
+			if err := req.Destination.docker(ctx, nil, io.Discard, "tag", sourceIDs[image], image); err != nil {
+				return result, fmt.Errorf("failed to tag %s at %s: %v", image, req.Destination, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// streamImages pipes `docker save` at the source into `docker load` at the
+// destination and reports the number of bytes sent so far. docker load
+// detects gzip input itself.
+func streamImages(ctx context.Context, req ImageTransferRequest, images []string, report func(int64)) ([]string, int64, error) {
+	var transferred int64
+	var loadOutput bytes.Buffer
+	saveErr, loadErr := pipeStreams(ctx,
+		func(ctx context.Context, w io.Writer) error {
+			counter := &countingWriter{w: w, report: report}
+			defer func() { transferred = atomic.LoadInt64(&counter.n) }()
+			return saveImages(ctx, req.Source, images, req.Compress, counter)
+		},
+		func(ctx context.Context, r io.Reader) error {
+			return req.Destination.docker(ctx, r, &loadOutput, "load")
+		})
+
+	report(transferred)
+	if saveErr != nil {
+		return nil, transferred, fmt.Errorf("docker save at %s failed: %v", req.Source, saveErr)
+	}
+	if loadErr != nil {
+		return nil, transferred, fmt.Errorf("docker load at %s failed: %v", req.Destination, loadErr)
+	}
+
+	var loaded []string
+	for _, line := range strings.Split(loadOutput.String(), "\n") {
+		if ref, ok := strings.CutPrefix(strings.TrimSpace(line), "Loaded image: "); ok {
+			loaded = append(loaded, ref)
+		} else if id, ok := strings.CutPrefix(strings.TrimSpace(line), "Loaded image ID: "); ok {
+			loaded = append(loaded, id)
+		}
+	}
+	return loaded, transferred, nil
+}
+
+// pipeStreams runs produce and consume concurrently, connected by a pipe.
+// When one side fails the other is stopped, and only the error of the side
+// that failed first is returned, the other one's is a consequence of it.
+// Both sides have returned by the time pipeStreams does.
+func pipeStreams(ctx context.Context, produce func(context.Context, io.Writer) error, consume func(context.Context, io.Reader) error) (produceErr, consumeErr error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each side records its failure before breaking the pipe or cancelling,
+	// so an induced failure can never be recorded first
+	var once sync.Once
+	produceFirst := false
+	failed := func(producer bool) {
+		once.Do(func() { produceFirst = producer })
+		cancel()
+	}
+
+	pr, pw := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		produceErr = produce(ctx, pw)
+		if produceErr != nil {
+			failed(true)
+		}
+		pw.CloseWithError(produceErr)
+	}()
+
+	consumeErr = consume(ctx, pr)
+	if consumeErr != nil {
+		failed(false)
+	}
+	pr.CloseWithError(io.ErrClosedPipe)
+	wg.Wait()
+
+	if produceErr != nil && consumeErr != nil {
+		if produceFirst {
+			consumeErr = nil
+		} else {
+			produceErr = nil
+		}
+	}
+	return produceErr, consumeErr
+}
+
+// saveImages writes `docker save` of the images to w, gzipped if requested
+func saveImages(ctx context.Context, source TransferEndpoint, images []string, compress bool, w io.Writer) error {
+	args := append([]string{"save"}, images...)
+	if !compress {
+		return source.docker(ctx, nil, w, args...)
+	}
+	if !source.Local {
+		// Compress on the remote so only the small stream crosses the network
+		return tunnelManager.StreamCommand(ctx, source.Username, source.Hostname, utils.BuildDockerCommand(args...)+" | gzip -1", nil, w)
+	}
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err := source.docker(ctx, nil, gz, args...); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (e TransferEndpoint) validate() error {
+	if e.Local {
+		if e.Hostname != "" || e.Username != "" {
+			return fmt.Errorf("Local endpoints take no hostname or username")
+		}
+		return nil
+	}
+	if e.Hostname == "" || e.Username == "" {
+		return fmt.Errorf("Missing required fields")
+	}
+	return validateConnection(e.Username, e.Hostname)
+}
+
+func (e TransferEndpoint) String() string {
+	if e.Local {
+		return "local"
+	}
+	return e.Username + "@" + e.Hostname
+}
+
+// docker runs a docker command at the endpoint. The local engine is reached
+// through the socket mounted into the extension backend.
+func (e TransferEndpoint) docker(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	if !e.Local {
+		return tunnelManager.StreamCommand(ctx, e.Username, e.Hostname, utils.BuildDockerCommand(args...), stdin, stdout)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// Bytes between two progress reports of a transfer
+const transferReportInterval = 1 << 20
+
+// countingWriter counts the bytes written through it and reports the total
+// every transferReportInterval bytes
+type countingWriter struct {
+	w        io.Writer
+	n        int64
+	reported int64
+	report   func(int64)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	total := atomic.AddInt64(&c.n, int64(n))
+	if c.report != nil && total-c.reported >= transferReportInterval {
+		c.reported = total
+		c.report(total)
+	}
+	return n, err
+}
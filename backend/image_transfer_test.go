@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Fake save and load sides for pipeStreams
+var (
+	errSave = errors.New("save: no such image")
+	errLoad = errors.New("load: no space left on device")
+)
+
+func fakeSave(data string, err error) func(context.Context, io.Writer) error {
+	return func(ctx context.Context, w io.Writer) error {
+		if _, werr := io.WriteString(w, data); werr != nil {
+			return werr
+		}
+		return err
+	}
+}
+
+// fakeSaveUntilStopped writes until the load side or the context stops it
+func fakeSaveUntilStopped(ctx context.Context, w io.Writer) error {
+	chunk := make([]byte, 1024)
+	for {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func TestPipeStreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		save     func(context.Context, io.Writer) error
+		load     func(context.Context, io.Reader) error
+		wantSave error
+		wantLoad error
+	}{
+		{
+			name: "success",
+			save: fakeSave("image tar", nil),
+			load: func(ctx context.Context, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				if err == nil && string(data) != "image tar" {
+					err = errors.New("unexpected data: " + string(data))
+				}
+				return err
+			},
+		},
+		{
+			// load sees the broken stream right away, save only returns later
+			name: "save fails first",
+			save: func(ctx context.Context, w io.Writer) error {
+				return errSave
+			},
+			load: func(ctx context.Context, r io.Reader) error {
+				io.ReadAll(r)
+				return errors.New("load: unexpected EOF")
+			},
+			wantSave: errSave,
+		},
+		{
+			name: "load fails before reading",
+			save: fakeSaveUntilStopped,
+			load: func(ctx context.Context, r io.Reader) error {
+				return errLoad
+			},
+			wantLoad: errLoad,
+		},
+		{
+			// save is cut off by the cancelled context while load is still returning
+			name: "load fails mid stream",
+			save: fakeSaveUntilStopped,
+			load: func(ctx context.Context, r io.Reader) error {
+				io.ReadFull(r, make([]byte, 4096))
+				return errLoad
+			},
+			wantLoad: errLoad,
+		},
+		{
+			name: "save fails while load is slow",
+			save: fakeSave("partial", errSave),
+			load: func(ctx context.Context, r io.Reader) error {
+				time.Sleep(10 * time.Millisecond)
+				_, err := io.ReadAll(r)
+				return err
+			},
+			wantSave: errSave,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveErr, loadErr := pipeStreams(context.Background(), tt.save, tt.load)
+			if saveErr != tt.wantSave || loadErr != tt.wantLoad {
+				t.Errorf("got save %v, load %v; want save %v, load %v", saveErr, loadErr, tt.wantSave, tt.wantLoad)
+			}
+		})
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+const (
+	// Default minutes a registry lookup is reused, overridable with the
+	// updateCheckCacheMinutes setting
+	defaultUpdateCheckCacheMinutes = 60
+	// Registry of images without a registry host
+	defaultRegistry = "docker.io"
+)
+
+// Manifest types accepted from registries. Multi-platform images are compared
+// by the digest of their index, which is what docker records when pulling.
+var registryManifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Registry lookups shared by all environments
+var updateChecker = newRegistryClient()
+
+// Request to check the running containers of an environment for newer images
+type ImageUpdateCheckRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Refresh  bool   `json:"refresh"` // bypass cached registry lookups
+}
+
+// Container running an image that has a newer build for the same tag
+type OutdatedContainer struct {
+	ContainerID   string `json:"containerId"`
+	Name          string `json:"name"`
+	Image         string `json:"image"`
+	CurrentDigest string `json:"currentDigest"`
+	LatestDigest  string `json:"latestDigest"`
+}
+
+// Container that could not be checked, e.g. a locally built image
+type SkippedContainer struct {
+	ContainerID string `json:"containerId"`
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	Reason      string `json:"reason"`
+}
+
+// Registry lookup that failed
+type UpdateCheckError struct {
+	Image string `json:"image"`
+	Error string `json:"error"`
+}
+
+// Update check of one environment
+type ImageUpdateReport struct {
+	CheckedAt string              `json:"checkedAt"`
+	UpToDate  int                 `json:"upToDate"`
+	Outdated  []OutdatedContainer `json:"outdated"`
+	Skipped   []SkippedContainer  `json:"skipped"`
+	Errors    []UpdateCheckError  `json:"errors"`
+}
+
+// Update check of one environment in the fleet report
+type FleetEnvironmentUpdates struct {
+	EnvironmentID string              `json:"environmentId"`
+	Name          string              `json:"name"`
+	Hostname      string              `json:"hostname"`
+	Outdated      []OutdatedContainer `json:"outdated"`
+	Errors        int                 `json:"errors"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// Running container with the digests its image was pulled with
+type updateCandidate struct {
+	ContainerID string
+	Name        string
+	Image       string   // reference the container was created from
+	RepoDigests []string // of the image the container runs
+}
+
+// Check the running containers of an environment for newer images
+func checkImageUpdates(ctx echo.Context) error {
+	var req ImageUpdateCheckRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	report, output, err := imageUpdateReport(ctx.Request().Context(), req.Username, req.Hostname, req.Refresh)
+	if err != nil {
+		logger.Errorf("Error checking image updates: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to check image updates: %v", err),
+			"output": string(output),
+		})
+	}
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// Check the running containers of all saved environments for newer images
+func checkFleetImageUpdates(ctx echo.Context) error {
+	var req struct {
+		Refresh bool `json:"refresh"`
+	}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	environments, err := loadEnvironments()
+	if err != nil {
+		logger.Errorf("Error loading environments: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	results := make([]FleetEnvironmentUpdates, len(environments))
+	forEachEnvironment(environments, func(i int, env Environment) {
+		result := FleetEnvironmentUpdates{
+			EnvironmentID: env.ID,
+			Name:          env.Name,
+			Hostname:      env.Hostname,
+			Outdated:      []OutdatedContainer{},
+		}
+		defer func() { results[i] = result }()
+
+		report, _, err := imageUpdateReport(ctx.Request().Context(), env.Username, env.Hostname, req.Refresh)
+		if err != nil {
+			result.Error = fmt.Sprintf("Failed to check image updates: %v", err)
+			return
+		}
+		result.Outdated = report.Outdated
+		result.Errors = len(report.Errors)
+	})
+
+	return ctx.JSON(http.StatusOK, results)
+}
+
+// imageUpdateReport resolves the running containers and their images on the
+// remote and compares them with the registries
+func imageUpdateReport(ctx context.Context, username, hostname string, refresh bool) (*ImageUpdateReport, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("ps", "-q", "--no-trunc"))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to list containers: %v", err)
+	}
+	containers, output, err := inspectContainers(username, hostname, strings.Fields(string(output))...)
+	if err != nil {
+		return nil, output, err
+	}
+
+	var imageIDs []string
+	for _, c := range containers {
+		imageIDs = append(imageIDs, c.Image)
+	}
+	images, output, err := inspectImages(username, hostname, uniqueStrings(imageIDs)...)
+	if err != nil {
+		return nil, output, err
+	}
+	digests := make(map[string][]string, len(images))
+	for _, image := range images {
+		digests[image.ID] = image.RepoDigests
+	}
+
+	candidates := make([]updateCandidate, 0, len(containers))
+	for _, c := range containers {
+		candidates = append(candidates, updateCandidate{
+			ContainerID: c.ID,
+			Name:        strings.TrimPrefix(c.Name, "/"),
+			Image:       c.Config.Image,
+			RepoDigests: digests[c.Image],
+		})
+	}
+
+	client := updateChecker.withCredentials(remoteRegistryCredentials(username, hostname))
+	return compareImageUpdates(ctx, client, candidates, refresh), nil, nil
+}
+
+// compareImageUpdates looks up the current digest of every distinct tag once
+// and flags the containers whose image was pulled with another digest
+func compareImageUpdates(ctx context.Context, client *registryClient, candidates []updateCandidate, refresh bool) *ImageUpdateReport {
+	report := &ImageUpdateReport{
+		CheckedAt: time.Now().Format(time.RFC3339),
+		Outdated:  []OutdatedContainer{},
+		Skipped:   []SkippedContainer{},
+		Errors:    []UpdateCheckError{},
+	}
+
+	latest := make(map[string]string)
+	failed := make(map[string]string) // reference -> lookup error
+	for _, c := range candidates {
+		skip := func(reason string) {
+			report.Skipped = append(report.Skipped, SkippedContainer{ContainerID: c.ContainerID, Name: c.Name, Image: c.Image, Reason: reason})
+		}
+		ref, err := parseRegistryReference(c.Image)
+		switch {
+		case err != nil:
+			skip(err.Error())
+			continue
+		case ref.Tag == "":
+			skip("image is pinned by digest")
+			continue
+		}
+		current := ref.localDigest(c.RepoDigests)
+		if current == "" {
+			skip("image was not pulled from a registry")
+			continue
+		}
+
+		// The error is reported once, other containers on the tag are skipped with it
+		key := ref.String()
+		if reason, ok := failed[key]; ok {
+			skip(reason)
+			continue
+		}
+		digest, ok := latest[key]
+		if !ok {
+			digest, err = client.manifestDigest(ctx, ref, refresh)
+			if err != nil {
+				failed[key] = err.Error()
+				report.Errors = append(report.Errors, UpdateCheckError{Image: c.Image, Error: err.Error()})
+				continue
+			}
+			latest[key] = digest
+		}
+
+		if digest == current {
+			report.UpToDate++
+			continue
+		}
+		report.Outdated = append(report.Outdated, OutdatedContainer{
+			ContainerID:   c.ContainerID,
+			Name:          c.Name,
+			Image:         c.Image,
+			CurrentDigest: current,
+			LatestDigest:  digest,
+		})
+	}
+	sort.Slice(report.Outdated, func(i, j int) bool { return report.Outdated[i].Name < report.Outdated[j].Name })
+	return report
+}
+
+// registryReference is an image reference split into its registry parts
+type registryReference struct {
+	Registry   string // e.g. docker.io or registry.example.com:5000
+	Repository string // e.g. library/nginx
+	Tag        string // empty for references pinned by digest
+}
+
+// parseRegistryReference applies docker's normalization: references without
+// a registry host are on Docker Hub, and official images live in library/
+func parseRegistryReference(image string) (registryReference, error) {
+	name, tag := image, "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, tag = name[:i], ""
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if name == "" || strings.HasPrefix(name, "sha256:") || imageIDHexPattern.MatchString(name) {
+		return registryReference{}, fmt.Errorf("image is referenced by ID")
+	}
+
+	ref := registryReference{Registry: defaultRegistry, Repository: name, Tag: tag}
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+	}
+	if ref.Registry == "index.docker.io" {
+		ref.Registry = defaultRegistry
+	}
+	if ref.Registry == defaultRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	return ref, nil
+}
+
+func (r registryReference) String() string {
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// localDigest picks the digest recorded for this repository from RepoDigests
+func (r registryReference) localDigest(repoDigests []string) string {
+	for _, rd := range repoDigests {
+		name, digest, ok := strings.Cut(rd, "@")
+		if !ok {
+			continue
+		}
+		other, err := parseRegistryReference(name)
+		if err == nil && other.Registry == r.Registry && other.Repository == r.Repository {
+			return digest
+		}
+	}
+	return ""
+}
+
+// registryCredentials looks up the login for a registry host, empty if none
+type registryCredentials func(registry string) (username, password string)
+
+type cachedDigest struct {
+	digest    string
+	checkedAt time.Time
+}
+
+// registryClient queries the v2 manifest API and caches the results
+type registryClient struct {
+	http        *http.Client
+	credentials registryCredentials
+	mu          *sync.Mutex
+	cache       map[string]cachedDigest
+	ttl         func() time.Duration
+}
+
+func newRegistryClient() *registryClient {
+	return &registryClient{
+		http:  &http.Client{Timeout: 30 * time.Second},
+		mu:    &sync.Mutex{},
+		cache: make(map[string]cachedDigest),
+		ttl: func() time.Duration {
+			return time.Duration(loadSettingInt("updateCheckCacheMinutes", defaultUpdateCheckCacheMinutes)) * time.Minute
+		},
+	}
+}
+
+// withCredentials returns a client sharing the cache that logs in with credentials
+func (c *registryClient) withCredentials(credentials registryCredentials) *registryClient {
+	clone := *c
+	clone.credentials = credentials
+	return &clone
+}
+
+// manifestDigest returns the digest the registry currently serves for the tag
+func (c *registryClient) manifestDigest(ctx context.Context, ref registryReference, refresh bool) (string, error) {
+	key := ref.String()
+	c.mu.Lock()
+	cached, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && !refresh && time.Since(cached.checkedAt) < c.ttl() {
+		return cached.digest, nil
+	}
+
+	digest, err := c.fetchManifestDigest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.cache[key] = cachedDigest{digest: digest, checkedAt: time.Now()}
+	c.mu.Unlock()
+	return digest, nil
+}
+
+func (c *registryClient) fetchManifestDigest(ctx context.Context, ref registryReference) (string, error) {
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", registryBaseURL(ref.Registry), ref.Repository, ref.Tag)
+
+	authorization := ""
+	resp, err := c.manifestRequest(ctx, http.MethodHead, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		if authorization, err = c.authorize(ctx, ref, resp.Header.Get("WWW-Authenticate")); err != nil {
+			return "", err
+		}
+		if resp, err = c.manifestRequest(ctx, http.MethodHead, manifestURL, authorization); err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+			return digest, nil
+		}
+		// Not every registry sends the digest on HEAD, hash the manifest instead
+		return c.hashManifest(ctx, manifestURL, authorization)
+	case http.StatusNotFound:
+		return "", fmt.Errorf("tag %s not found in %s/%s", ref.Tag, ref.Registry, ref.Repository)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("access to %s/%s denied", ref.Registry, ref.Repository)
+	default:
+		return "", fmt.Errorf("registry returned %s", resp.Status)
+	}
+}
+
+func (c *registryClient) manifestRequest(ctx context.Context, method, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(registryManifestTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.http.Do(req)
+}
+
+func (c *registryClient) hashManifest(ctx context.Context, manifestURL, authorization string) (string, error) {
+	resp, err := c.manifestRequest(ctx, http.MethodGet, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s", resp.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(resp.Body, 4<<20)); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// authorize answers a registry challenge with basic credentials or a bearer
+// token from the registry's token service, anonymous if there is no login
+func (c *registryClient) authorize(ctx context.Context, ref registryReference, challenge string) (string, error) {
+	var username, password string
+	if c.credentials != nil {
+		username, password = c.credentials(ref.Registry)
+	}
+
+	scheme, params := parseAuthChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("%s requires a login", ref.Registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge from %s", ref.Registry)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm from %s", ref.Registry)
+	}
+	query := tokenURL.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", "repository:"+ref.Repository+":pull")
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get registry token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token service returned %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse registry token: %v", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseAuthChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseAuthChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var pair string
+		rest = strings.TrimLeft(rest, ", ")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			pair, rest = value[1:end+1], value[end+2:]
+		} else {
+			pair, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = pair
+	}
+	return strings.ToLower(scheme), params
+}
+
+// registryBaseURL returns the API endpoint of a registry. Like docker, plain
+// HTTP is used for registries on the loopback interface.
+func registryBaseURL(registry string) string {
+	if registry == defaultRegistry {
+		return "https://registry-1.docker.io"
+	}
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+// remoteRegistryCredentials reads the logins stored in the docker config of
+// the remote user, falling back to the logins stored in the extension.
+// Credential helpers are not supported; without a login the registry is
+// queried anonymously.
+func remoteRegistryCredentials(username, hostname string) registryCredentials {
+	var once sync.Once
+	auths := make(map[string][2]string)
+	return func(registry string) (string, string) {
+		once.Do(func() {
+			output, err := tunnelManager.ExecuteCommand(username, hostname, "cat ~/.docker/config.json 2>/dev/null || true")
+			if err != nil {
+				logger.Warnf("Failed to read docker config on %s: %v", hostname, err)
+				return
+			}
+			for host, login := range parseDockerConfigAuths(output) {
+				auths[host] = login
+			}
+		})
+		if login, ok := auths[registry]; ok {
+			return login[0], login[1]
+		}
+		return registryLogins.lookup(registry)
+	}
+}
+
+// parseDockerConfigAuths decodes the auths section of a docker config.json,
+// keyed by registry host
+func parseDockerConfigAuths(data []byte) map[string][2]string {
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	auths := make(map[string][2]string)
+	if len(strings.TrimSpace(string(data))) == 0 || json.Unmarshal(data, &config) != nil {
+		return auths
+	}
+	for server, entry := range config.Auths {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			continue
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			continue
+		}
+		host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+		host, _, _ = strings.Cut(host, "/")
+		if host == "index.docker.io" || host == "registry-1.docker.io" {
+			host = defaultRegistry
+		}
+		auths[host] = [2]string{user, password}
+	}
+	return auths
+}
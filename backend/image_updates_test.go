@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeRegistry stands in for registry:2 behind a token service
+func newFakeRegistry(t *testing.T, digests map[string]string, requests *int) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, _ := r.BasicAuth(); user != "ci" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"token":"t0ken"}`))
+			return
+		}
+		*requests++
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="fake"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		digest, ok := digests[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCompareImageUpdates(t *testing.T) {
+	requests := 0
+	server := newFakeRegistry(t, map[string]string{
+		"/v2/team/api/manifests/1.0": "sha256:new",
+		"/v2/team/web/manifests/2":   "sha256:same",
+	}, &requests)
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	client := newRegistryClient().withCredentials(func(host string) (string, string) {
+		if host == registry {
+			return "ci", "secret"
+		}
+		return "", ""
+	})
+	candidates := []updateCandidate{
+		{ContainerID: "c1", Name: "api-1", Image: registry + "/team/api:1.0", RepoDigests: []string{registry + "/team/api@sha256:old"}},
+		{ContainerID: "c2", Name: "api-2", Image: registry + "/team/api:1.0", RepoDigests: []string{registry + "/team/api@sha256:old"}},
+		{ContainerID: "c3", Name: "web", Image: registry + "/team/web:2", RepoDigests: []string{registry + "/team/web@sha256:same"}},
+		{ContainerID: "c4", Name: "local", Image: "myapp:dev"},
+		{ContainerID: "c5", Name: "gone", Image: registry + "/team/gone:1", RepoDigests: []string{registry + "/team/gone@sha256:x"}},
+		{ContainerID: "c6", Name: "gone-2", Image: registry + "/team/gone:1", RepoDigests: []string{registry + "/team/gone@sha256:x"}},
+	}
+
+	report := compareImageUpdates(context.Background(), client, candidates, false)
+	if len(report.Outdated) != 2 || report.Outdated[0].Name != "api-1" || report.Outdated[0].LatestDigest != "sha256:new" {
+		t.Errorf("unexpected outdated containers: %+v", report.Outdated)
+	}
+	if report.UpToDate != 1 || len(report.Skipped) != 2 || len(report.Errors) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	// The second container on a failed tag is skipped with the lookup error
+	if skipped := report.Skipped[1]; skipped.ContainerID != "c6" || skipped.Reason != report.Errors[0].Error {
+		t.Errorf("unexpected skipped container: %+v", skipped)
+	}
+
+	// Lookups are cached, a second check does not hit the registry
+	before := requests
+	compareImageUpdates(context.Background(), client, candidates[:3], false)
+	if requests != before {
+		t.Errorf("expected cached lookups, got %d new requests", requests-before)
+	}
+}
+
+func TestParseRegistryReference(t *testing.T) {
+	for image, want := range map[string]registryReference{
+		"nginx":              {Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		"grafana/grafana:10": {Registry: "docker.io", Repository: "grafana/grafana", Tag: "10"},
+		"ghcr.io/org/app:v1": {Registry: "ghcr.io", Repository: "org/app", Tag: "v1"},
+		"localhost:5000/app": {Registry: "localhost:5000", Repository: "app", Tag: "latest"},
+		"redis@sha256:" + strings.Repeat("a", 64): {Registry: "docker.io", Repository: "library/redis"},
+	} {
+		got, err := parseRegistryReference(image)
+		if err != nil || got != want {
+			t.Errorf("parseRegistryReference(%q) = %+v, %v", image, got, err)
+		}
+	}
+}
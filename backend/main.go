@@ -1,17 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -50,25 +51,26 @@ type SSHConnection struct {
 	Active      bool
 }
 
-type SSHConnectionRequest struct {
-	Hostname string `json:"hostname"`
-	Username string `json:"username"`
-}
-
 type DockerContainer struct {
-	ID             string `json:"id"`
-	Name           string `json:"name"`
-	Image          string `json:"image"`
-	Status         string `json:"status"`
-	Ports          string `json:"ports"`
-	Labels         string `json:"labels"`         // New field to store raw label string
-	ComposeProject string `json:"composeProject"` // Computed field if the container is part of a Compose project
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Image          string            `json:"image"`
+	Status         string            `json:"status"`
+	State          string            `json:"state"`            // created, running, paused, restarting, exited, dead
+	Health         string            `json:"health,omitempty"` // healthy, unhealthy or starting if the container has a healthcheck
+	CreatedAt      string            `json:"createdAt"`
+	RawPorts       string            `json:"ports"`            // Ports column as printed by docker ps
+	RawLabels      string            `json:"labels"`           // Labels column as printed by docker ps
+	Ports          []PortBinding     `json:"portBindings"`     // Parsed from RawPorts
+	Labels         map[string]string `json:"labelMap"`         // Parsed from RawLabels
+	ComposeProject string            `json:"composeProject"`   // Computed field if the container is part of a Compose project
 }
 
 // A group of containers under the same Compose project
 type ComposeGroup struct {
 	Name       string            `json:"name"`
 	Status     string            `json:"status"` // e.g. "Running(3)", "Partial(2/3)", etc.
+	Counts     GroupStateCounts  `json:"counts"`
 	Containers []DockerContainer `json:"containers"`
 }
 
@@ -76,6 +78,9 @@ type ComposeGroup struct {
 type DockerContainerResponse struct {
 	ComposeGroups []ComposeGroup    `json:"composeGroups"`
 	Ungrouped     []DockerContainer `json:"ungrouped"`
+	Total         int               `json:"total"` // Matching containers across all pages
+	Page          int               `json:"page,omitempty"`
+	PageSize      int               `json:"pageSize,omitempty"`
 }
 
 // Settings data file path
@@ -159,20 +164,79 @@ func main() {
 	// Container management endpoints
 	router.POST("/container/start", startContainer)
 	router.POST("/container/stop", stopContainer)
+	router.POST("/container/inspect", getContainerInspect)
+	router.POST("/container/files/list", listContainerFiles)
+	router.POST("/container/files/download", downloadContainerFiles)
+	router.POST("/container/files/upload", uploadContainerFile)
+	router.POST("/container/top", getContainerTop)
+	router.POST("/container/top/stream", streamContainerTop)
+	router.POST("/container/diff", getContainerDiff)
+	router.POST("/container/update", updateContainerResources)
+	router.POST("/container/limits", getContainerLimits)
+	router.POST("/container/recreate", recreateContainer)
+	router.POST("/container/recreate/status", getRecreateStatus)
+	router.POST("/container/recreate/rollback", rollbackRecreate)
+	router.POST("/compose/generate", generateComposeFile)
 
 	// Image management endpoints
 	router.POST("/images/list", listImages)
+	router.POST("/images/pull", pullImage)
+	router.POST("/images/inspect", inspectImage)
+	router.POST("/images/remove", removeImage)
+	router.POST("/images/tag", tagImage)
+	router.POST("/images/prune", pruneImages)
+	router.POST("/images/transfer", transferImages)
+	router.POST("/images/build", buildImage)
+	router.POST("/images/scan", scanImage)
+	router.POST("/images/scan/report", getScanReport)
+	router.POST("/images/updates", checkImageUpdates)
+	router.POST("/images/updates/fleet", checkFleetImageUpdates)
+
+	// Registry credential endpoints
+	router.POST("/registry/credentials/list", listRegistryCredentials)
+	router.POST("/registry/credentials/save", saveRegistryCredential)
+	router.POST("/registry/credentials/delete", deleteRegistryCredential)
+	router.POST("/registry/credentials/apply", applyRegistryCredential)
+	router.POST("/registry/credentials/remove", removeRegistryCredential)
 
 	// Volume management endpoints
 	router.POST("/volumes/list", listVolumes)
+	router.POST("/volumes/create", createVolume)
+	router.POST("/volumes/browse", browseVolume)
+	router.POST("/volumes/browse/download", downloadVolumeFile)
 	router.POST("/volumes/remove", removeVolume)
+	router.POST("/volumes/backup", backupVolume)
+	router.POST("/volumes/restore", restoreVolume)
+	router.POST("/volumes/backups/list", listVolumeBackups)
+	router.POST("/volumes/backups/delete", deleteVolumeBackup)
+	router.POST("/volumes/migrate", migrateVolume)
 
 	// Network management endpoints
 	router.POST("/networks/list", listNetworks)
 	router.POST("/networks/remove", removeNetwork)
+	router.POST("/networks/create", createNetwork)
+	router.POST("/networks/connect", connectNetwork)
+	router.POST("/networks/disconnect", disconnectNetwork)
+	router.POST("/topology", getTopology)
+
+	// Disk usage and reclaim endpoints
+	router.POST("/disk/usage", getDiskUsage)
+	router.POST("/disk/reclaim/plan", planReclaim)
+	router.POST("/disk/reclaim/execute", executeReclaim)
 
 	router.POST("/container/logs", getContainerLogs)
 	router.POST("/compose/logs", getComposeLogs)
+	router.POST("/compose/action", runComposeAction)
+	router.POST("/compose/deploy", deployComposeProject)
+	router.POST("/compose/deploy/rollback", rollbackComposeDeploy)
+	router.POST("/compose/drift", getComposeDrift)
+	router.POST("/compose/drift/fleet", getFleetComposeDrift)
+
+	// Background operations (image pulls, transfers, ...)
+	router.POST("/operations/status", getOperation)
+	router.POST("/operations/list", listOperations)
+	router.POST("/operations/cancel", cancelOperation)
+	router.POST("/operations/stream", streamOperation)
 
 	router.POST("/dashboard/overview", getDashboardOverview)
 	router.POST("/dashboard/resources", getDashboardResources)
@@ -450,9 +514,7 @@ func getDashboardResources(ctx echo.Context) error {
 	}
 
 	// Get container resource usage with docker stats
-	// Using a simpler format string that's more likely to work across different Docker versions
-	statsCmd := "docker stats --no-stream --format 'table {{.ID}}|{{.Name}}|{{.CPUPerc}}|{{.MemUsage}}|{{.MemPerc}}|{{.NetIO}}|{{.BlockIO}}' || docker stats --no-stream"
-	statsOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, statsCmd)
+	containers, err := fetchContainerStats(req.Username, req.Hostname)
 	if err != nil {
 		logger.Errorf("Error getting resource stats: %v", err)
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
@@ -460,6 +522,51 @@ func getDashboardResources(ctx echo.Context) error {
 		})
 	}
 
+	// Get system resource usage using more basic commands that are more likely to be available
+	// First, try a simpler CPU usage check
+	cpuUsage := 0.0
+	cpuCmd := "top -bn1 | grep '%Cpu' | awk '{print 100 - $8}' || echo 0"
+	cpuOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, cpuCmd)
+	if err == nil && len(cpuOutput) > 0 {
+		cpuUsage, _ = strconv.ParseFloat(strings.TrimSpace(string(cpuOutput)), 64)
+	}
+
+	// Memory usage
+	memUsage := 0.0
+	memCmd := "free | grep Mem | awk '{print $3/$2 * 100}' || echo 0"
+	memOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, memCmd)
+	if err == nil && len(memOutput) > 0 {
+		memUsage, _ = strconv.ParseFloat(strings.TrimSpace(string(memOutput)), 64)
+	}
+
+	// Disk usage
+	diskUsage := 0.0
+	diskCmd := "df -h / | awk 'NR==2 {print $5}' | sed 's/%//' || echo 0"
+	diskOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, diskCmd)
+	if err == nil && len(diskOutput) > 0 {
+		diskUsage, _ = strconv.ParseFloat(strings.TrimSpace(string(diskOutput)), 64)
+	}
+
+	// Build the response
+	resources := ResourcesResponse{
+		Containers: containers,
+	}
+	resources.System.CPUUsage = cpuUsage
+	resources.System.MemoryUsage = memUsage
+	resources.System.DiskUsage = diskUsage
+
+	return ctx.JSON(http.StatusOK, resources)
+}
+
+// fetchContainerStats returns a single `docker stats` sample for all running containers
+func fetchContainerStats(username, hostname string) ([]ContainerResource, error) {
+	// Using a simpler format string that's more likely to work across different Docker versions
+	statsCmd := "docker stats --no-stream --format 'table {{.ID}}|{{.Name}}|{{.CPUPerc}}|{{.MemUsage}}|{{.MemPerc}}|{{.NetIO}}|{{.BlockIO}}' || docker stats --no-stream"
+	statsOutput, err := tunnelManager.ExecuteCommand(username, hostname, statsCmd)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse stats output
 	lines := strings.Split(strings.TrimSpace(string(statsOutput)), "\n")
 	containers := make([]ContainerResource, 0)
@@ -553,40 +660,7 @@ func getDashboardResources(ctx echo.Context) error {
 		}
 	}
 
-	// Get system resource usage using more basic commands that are more likely to be available
-	// First, try a simpler CPU usage check
-	cpuUsage := 0.0
-	cpuCmd := "top -bn1 | grep '%Cpu' | awk '{print 100 - $8}' || echo 0"
-	cpuOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, cpuCmd)
-	if err == nil && len(cpuOutput) > 0 {
-		cpuUsage, _ = strconv.ParseFloat(strings.TrimSpace(string(cpuOutput)), 64)
-	}
-
-	// Memory usage
-	memUsage := 0.0
-	memCmd := "free | grep Mem | awk '{print $3/$2 * 100}' || echo 0"
-	memOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, memCmd)
-	if err == nil && len(memOutput) > 0 {
-		memUsage, _ = strconv.ParseFloat(strings.TrimSpace(string(memOutput)), 64)
-	}
-
-	// Disk usage
-	diskUsage := 0.0
-	diskCmd := "df -h / | awk 'NR==2 {print $5}' | sed 's/%//' || echo 0"
-	diskOutput, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, diskCmd)
-	if err == nil && len(diskOutput) > 0 {
-		diskUsage, _ = strconv.ParseFloat(strings.TrimSpace(string(diskOutput)), 64)
-	}
-
-	// Build the response
-	resources := ResourcesResponse{
-		Containers: containers,
-	}
-	resources.System.CPUUsage = cpuUsage
-	resources.System.MemoryUsage = memUsage
-	resources.System.DiskUsage = diskUsage
-
-	return ctx.JSON(http.StatusOK, resources)
+	return containers, nil
 }
 
 // Get Docker system information - simplified to avoid version-specific commands
@@ -893,15 +967,8 @@ func getComposeLogs(ctx echo.Context) error {
 	}
 
 	// Validate compose project name
-	if req.ComposeProject == "" {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Compose project name cannot be empty"})
-	}
-	if len(req.ComposeProject) > 255 {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Compose project name too long"})
-	}
-	// Basic validation for compose project names
-	if !regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`).MatchString(req.ComposeProject) {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid compose project name format"})
+	if err := utils.ValidateComposeProjectName(req.ComposeProject); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid compose project: %v", err)})
 	}
 	
 	// Build docker logs command with appropriate options
@@ -960,6 +1027,17 @@ func NewSSHTunnelManager() (*SSHTunnelManager, error) {
 	}, nil
 }
 
+// validateConnection checks the SSH username and hostname of a request
+func validateConnection(username, hostname string) error {
+	if err := utils.ValidateSSHUsername(username); err != nil {
+		return fmt.Errorf("Invalid username: %v", err)
+	}
+	if err := utils.ValidateSSHHostname(hostname); err != nil {
+		return fmt.Errorf("Invalid hostname: %v", err)
+	}
+	return nil
+}
+
 // Generate connection key for mapping
 func connectionKey(username, hostname string) string {
 	return fmt.Sprintf("%s@%s", username, hostname)
@@ -1154,6 +1232,50 @@ func (m *SSHTunnelManager) CloseAllConnections() {
 
 // Execute a command using an existing SSH connection
 func (m *SSHTunnelManager) ExecuteCommand(username, hostname, command string) ([]byte, error) {
+	cmd, err := m.sshCommand(context.Background(), username, hostname, command)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the command and return output
+	return cmd.CombinedOutput()
+}
+
+// StreamCommand runs a command over the existing SSH connection, feeding it
+// stdin and copying its stdout as it is produced. Stderr is collected and
+// returned as part of the error. A failing write to stdout, e.g. a client
+// that went away, terminates the remote command.
+func (m *SSHTunnelManager) StreamCommand(ctx context.Context, username, hostname, command string, stdin io.Reader, stdout io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd, err := m.sshCommand(ctx, username, hostname, command)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	out := &cancelOnErrorWriter{w: stdout, cancel: cancel}
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// The command was killed because of the writer, that is the real cause
+		if out.err != nil {
+			return out.err
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// sshCommand prepares an ssh invocation of command on the shared master
+// connection, opening the connection first if needed
+func (m *SSHTunnelManager) sshCommand(ctx context.Context, username, hostname, command string) (*exec.Cmd, error) {
 	m.mutex.Lock()
 	key := connectionKey(username, hostname)
 	conn, exists := m.activeConnections[key]
@@ -1174,7 +1296,7 @@ func (m *SSHTunnelManager) ExecuteCommand(username, hostname, command string) ([
 	m.mutex.Unlock()
 
 	// Execute command using the control socket
-	cmd := exec.Command("ssh",
+	cmd := exec.CommandContext(ctx, "ssh",
 		"-o", "ConnectTimeout=10",
 		"-o", "ServerAliveInterval=30",
 		"-S", controlPath,
@@ -1189,8 +1311,24 @@ func (m *SSHTunnelManager) ExecuteCommand(username, hostname, command string) ([
 	}
 	cmd.Args = append(cmd.Args, sshTarget, command)
 
-	// Run the command and return output
-	return cmd.CombinedOutput()
+	return cmd, nil
+}
+
+// cancelOnErrorWriter cancels the running command as soon as a write fails,
+// otherwise the remote side would block forever on a full pipe
+type cancelOnErrorWriter struct {
+	w      io.Writer
+	cancel context.CancelFunc
+	err    error
+}
+
+func (c *cancelOnErrorWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil && c.err == nil {
+		c.err = err
+		c.cancel()
+	}
+	return n, err
 }
 
 // Check if connection is active
@@ -1456,11 +1594,7 @@ func listVolumes(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid hostname: %v", err)})
 	}
 
-	// First, get volume names and driver info
-	dockerCommand := "docker volume ls --format '{{.Name}}|{{.Driver}}'"
-
-	// Execute command using SSH tunnel
-	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	volumes, output, err := collectVolumes(req.Username, req.Hostname)
 	if err != nil {
 		logger.Errorf("Error listing volumes: %v, output: %s", err, string(output))
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
@@ -1469,80 +1603,6 @@ func listVolumes(ctx echo.Context) error {
 		})
 	}
 
-	// Parse the output into volume objects
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	volumes := make([]map[string]interface{}, 0, len(lines))
-
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Split(line, "|")
-		if len(parts) != 2 {
-			logger.Errorf("Invalid format for volume info: %s", line)
-			continue
-		}
-
-		volumeName := parts[0]
-		driver := parts[1]
-
-		// Get detailed info about this volume
-		// Volume name is already validated from the docker volume ls output
-		inspectCommand := utils.BuildDockerCommand("volume", "inspect", volumeName)
-		inspectOutput, inspectErr := tunnelManager.ExecuteCommand(req.Username, req.Hostname, inspectCommand)
-
-		mountpoint := "N/A"
-		created := "N/A"
-		labels := []string{}
-
-		// If we can get inspect data, extract more info
-		if inspectErr == nil && len(inspectOutput) > 0 {
-			// Simple parsing approach - in production you'd want to properly parse JSON
-			inspectStr := string(inspectOutput)
-
-			// Extract mountpoint
-			if mountStart := strings.Index(inspectStr, "\"Mountpoint\": \""); mountStart >= 0 {
-				mountStart += 15 // Length of "Mountpoint": "
-				if mountEnd := strings.Index(inspectStr[mountStart:], "\""); mountEnd >= 0 {
-					mountpoint = inspectStr[mountStart : mountStart+mountEnd]
-				}
-			}
-
-			// Extract creation time if available
-			if createdStart := strings.Index(inspectStr, "\"CreatedAt\": \""); createdStart >= 0 {
-				createdStart += 14 // Length of "CreatedAt": "
-				if createdEnd := strings.Index(inspectStr[createdStart:], "\""); createdEnd >= 0 {
-					created = inspectStr[createdStart : createdStart+createdEnd]
-				}
-			}
-
-			// Extract labels
-			if labelsStart := strings.Index(inspectStr, "\"Labels\": {"); labelsStart >= 0 {
-				labelsStart += 11 // Length of "Labels": {
-				if labelsEnd := strings.Index(inspectStr[labelsStart:], "}"); labelsEnd >= 0 {
-					labelsSection := inspectStr[labelsStart : labelsStart+labelsEnd]
-					labelPairs := strings.Split(labelsSection, ",")
-					for _, pair := range labelPairs {
-						if pair = strings.TrimSpace(pair); pair != "" {
-							labels = append(labels, pair)
-						}
-					}
-				}
-			}
-		}
-
-		volume := map[string]interface{}{
-			"name":       volumeName,
-			"driver":     driver,
-			"mountpoint": mountpoint,
-			"created":    created,
-			"size":       "N/A", // Size would require more complex commands to determine
-			"labels":     labels,
-		}
-		volumes = append(volumes, volume)
-	}
-
 	return ctx.JSON(http.StatusOK, volumes)
 }
 
@@ -1562,10 +1622,27 @@ func removeVolume(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid volume name: %v", err)})
 	}
 	
+	// Refuse volumes mounted by any container, running or stopped, and name
+	// the containers instead of docker's bare IDs
+	usage, output, err := volumeUsers(req.Username, req.Hostname)
+	if err != nil {
+		logger.Errorf("Error checking volume users: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to check volume users: %v", err),
+			"output": string(output),
+		})
+	}
+	if users := usage.users[req.VolumeName]; len(users) > 0 {
+		return ctx.JSON(http.StatusConflict, map[string]interface{}{
+			"error":      fmt.Sprintf("Volume %s is in use by %s", req.VolumeName, describeVolumeUsers(users)),
+			"containers": users,
+		})
+	}
+
 	dockerCommand := utils.BuildDockerCommand("volume", "rm", req.VolumeName)
 
 	// Execute command using SSH tunnel
-	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
 	if err != nil {
 		logger.Errorf("Error removing volume: %v, output: %s", err, string(output))
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
@@ -1602,11 +1679,7 @@ func listNetworks(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid hostname: %v", err)})
 	}
 
-	// Format: ID|Name|Driver|Scope
-	dockerCommand := "docker network ls --format '{{.ID}}|{{.Name}}|{{.Driver}}|{{.Scope}}'"
-
-	// Execute command using SSH tunnel
-	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	networks, output, err := collectNetworks(req.Username, req.Hostname)
 	if err != nil {
 		logger.Errorf("Error listing networks: %v, output: %s", err, string(output))
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
@@ -1615,84 +1688,6 @@ func listNetworks(ctx echo.Context) error {
 		})
 	}
 
-	// Parse the output into network objects
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	networks := make([]map[string]interface{}, 0, len(lines))
-
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Split(line, "|")
-		if len(parts) != 4 {
-			logger.Errorf("Invalid format for network info: %s", line)
-			continue
-		}
-
-		networkId := parts[0]
-		name := parts[1]
-		driver := parts[2]
-		scope := parts[3]
-
-		// Now get detailed info about this network
-		// Network ID is already validated from the docker network ls output
-		inspectCmd := utils.BuildDockerCommand("network", "inspect", networkId)
-
-		// Execute command using SSH tunnel
-		inspectOutput, inspectErr := tunnelManager.ExecuteCommand(req.Username, req.Hostname, inspectCmd)
-
-		subnet := ""
-		gateway := ""
-		ipamDriver := "default"
-		internal := false
-
-		// If we can get inspect data, extract more info
-		if inspectErr == nil && len(inspectOutput) > 0 {
-			// Simple parsing approach - in production you'd want to properly parse JSON
-			inspectStr := string(inspectOutput)
-
-			// Extract IPAM driver
-			if driverStart := strings.Index(inspectStr, "\"Driver\": \""); driverStart >= 0 {
-				driverStart += 11 // Length of "Driver": "
-				if driverEnd := strings.Index(inspectStr[driverStart:], "\""); driverEnd >= 0 {
-					ipamDriver = inspectStr[driverStart : driverStart+driverEnd]
-				}
-			}
-
-			// Extract subnet
-			if subnetStart := strings.Index(inspectStr, "\"Subnet\": \""); subnetStart >= 0 {
-				subnetStart += 11 // Length of "Subnet": "
-				if subnetEnd := strings.Index(inspectStr[subnetStart:], "\""); subnetEnd >= 0 {
-					subnet = inspectStr[subnetStart : subnetStart+subnetEnd]
-				}
-			}
-
-			// Extract gateway
-			if gatewayStart := strings.Index(inspectStr, "\"Gateway\": \""); gatewayStart >= 0 {
-				gatewayStart += 12 // Length of "Gateway": "
-				if gatewayEnd := strings.Index(inspectStr[gatewayStart:], "\""); gatewayEnd >= 0 {
-					gateway = inspectStr[gatewayStart : gatewayStart+gatewayEnd]
-				}
-			}
-
-			// Check if internal
-			internal = strings.Contains(inspectStr, "\"Internal\": true")
-		}
-
-		network := map[string]interface{}{
-			"id":         networkId,
-			"name":       name,
-			"driver":     driver,
-			"scope":      scope,
-			"ipamDriver": ipamDriver,
-			"subnet":     subnet,
-			"gateway":    gateway,
-			"internal":   internal,
-		}
-		networks = append(networks, network)
-	}
-
 	return ctx.JSON(http.StatusOK, networks)
 }
 
@@ -1712,11 +1707,28 @@ func removeNetwork(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid network ID: %v", err)})
 	}
 	
+	// Refuse networks with attached containers, running or stopped, and name
+	// them instead of docker's bare endpoint error
+	containers, output, err := networkContainers(req.Username, req.Hostname, req.NetworkId)
+	if err != nil {
+		logger.Errorf("Error checking network containers: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to check network containers: %v", err),
+			"output": string(output),
+		})
+	}
+	if len(containers) > 0 {
+		return ctx.JSON(http.StatusConflict, map[string]interface{}{
+			"error":      fmt.Sprintf("Network %s has attached containers: %s", req.NetworkId, describeNetworkContainers(containers)),
+			"containers": containers,
+		})
+	}
+
 	// SSH to remote host and remove network
 	dockerCommand := utils.BuildDockerCommand("network", "rm", req.NetworkId)
 
 	// Execute command using SSH tunnel
-	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
+	output, err = tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
 	if err != nil {
 		logger.Errorf("Error removing network: %v, output: %s", err, string(output))
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
@@ -1738,6 +1750,20 @@ type ContainerRequest struct {
 	ContainerId string `json:"containerId"`
 }
 
+// validateContainerRequest checks the fields every container operation needs
+func validateContainerRequest(req ContainerRequest) error {
+	if req.Hostname == "" || req.Username == "" || req.ContainerId == "" {
+		return fmt.Errorf("Missing required fields")
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return err
+	}
+	if err := utils.ValidateContainerID(req.ContainerId); err != nil {
+		return fmt.Errorf("Invalid container ID: %v", err)
+	}
+	return nil
+}
+
 // Start a container
 func startContainer(ctx echo.Context) error {
 	var req ContainerRequest
@@ -1811,8 +1837,11 @@ func stopContainer(ctx echo.Context) error {
 // List images
 func listImages(ctx echo.Context) error {
 	var req struct {
-		Hostname string `json:"hostname"`
-		Username string `json:"username"`
+		Hostname  string `json:"hostname"`
+		Username  string `json:"username"`
+		All       bool   `json:"all"`       // include intermediate images
+		Dangling  bool   `json:"dangling"`  // only dangling images
+		WithUsage bool   `json:"withUsage"` // add the containers using each image
 	}
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
@@ -1831,7 +1860,14 @@ func listImages(ctx echo.Context) error {
 	}
 
 	// Format the docker command
-	dockerCommand := "docker images --format '{{.ID}}|{{.Repository}}|{{.Tag}}|{{.CreatedSince}}|{{.Size}}'"
+	args := []string{"images", "--format", "{{.ID}}|{{.Repository}}|{{.Tag}}|{{.CreatedSince}}|{{.Size}}"}
+	if req.All {
+		args = append(args, "--all")
+	}
+	if req.Dangling {
+		args = append(args, "--filter", "dangling=true")
+	}
+	dockerCommand := utils.BuildDockerCommand(args...)
 
 	// Execute command using SSH tunnel
 	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
@@ -1843,9 +1879,19 @@ func listImages(ctx echo.Context) error {
 		})
 	}
 
+	// Usage is best effort, the list is still useful without it
+	var users map[string][]ImageUser
+	if req.WithUsage {
+		var usersOutput []byte
+		users, usersOutput, err = imageUsers(req.Username, req.Hostname)
+		if err != nil {
+			logger.Warnf("Error listing image users: %v, output: %s", err, string(usersOutput))
+		}
+	}
+
 	// Parse the output into image objects
 	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	images := make([]map[string]string, 0, len(lines))
+	images := make([]ImageSummary, 0, len(lines))
 
 	for _, line := range lines {
 		if line == "" {
@@ -1858,12 +1904,20 @@ func listImages(ctx echo.Context) error {
 			continue
 		}
 
-		image := map[string]string{
-			"id":         parts[0],
-			"repository": parts[1],
-			"tag":        parts[2],
-			"created":    parts[3],
-			"size":       parts[4],
+		image := ImageSummary{
+			ID:         parts[0],
+			Repository: parts[1],
+			Tag:        parts[2],
+			Created:    parts[3],
+			Size:       parts[4],
+			Dangling:   parts[1] == "<none>" && parts[2] == "<none>",
+		}
+		image.Vulnerabilities = scanReports.summaryOfShortID(parts[0])
+		if users != nil {
+			image.Containers = usersOfShortID(users, parts[0])
+			if image.Containers == nil {
+				image.Containers = []ImageUser{}
+			}
 		}
 		images = append(images, image)
 	}
@@ -1944,7 +1998,7 @@ func saveSettings(ctx echo.Context) error {
 
 // connectToRemoteDocker: called from the frontend to list containers
 func connectToRemoteDocker(ctx echo.Context) error {
-	var req SSHConnectionRequest
+	var req ContainerListRequest
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
 	}
@@ -1961,8 +2015,11 @@ func connectToRemoteDocker(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid hostname: %v", err)})
 	}
 
-	// Include Labels in docker ps
-	dockerCommand := `docker ps --format '{{.ID}}|{{.Names}}|{{.Image}}|{{.Status}}|{{.Ports}}|{{.Labels}}'`
+	// List all containers (including stopped ones) with their labels, filtered on the remote side
+	dockerCommand, err := buildContainerListCommand(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
 	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, dockerCommand)
 	if err != nil {
@@ -1974,35 +2031,55 @@ func connectToRemoteDocker(ctx echo.Context) error {
 	}
 
 	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	groupsMap := make(map[string][]DockerContainer)
-	ungrouped := []DockerContainer{}
+	containers := make([]DockerContainer, 0, len(lines))
 
 	for _, line := range lines {
 		if line == "" {
 			continue
 		}
-		parts := strings.Split(line, "|")
-		// ID, Name, Image, Status, Ports, Labels
-		if len(parts) != 6 {
+		parts := strings.SplitN(line, "|", 8)
+		// ID, Name, Image, Status, State, CreatedAt, Ports, Labels
+		if len(parts) != 8 {
 			logger.Warnf("Invalid container info: %s", line)
 			continue
 		}
 
 		container := DockerContainer{
-			ID:     parts[0],
-			Name:   parts[1],
-			Image:  parts[2],
-			Status: parts[3],
-			Ports:  parts[4],
-			Labels: parts[5],
+			ID:        parts[0],
+			Name:      parts[1],
+			Image:     parts[2],
+			Status:    parts[3],
+			State:     parts[4],
+			Health:    healthFromStatus(parts[3]),
+			CreatedAt: parts[5],
+			RawPorts:  parts[6],
+			RawLabels: parts[7],
+			Ports:     parsePortsString(parts[6]),
+			Labels:    parseLabelsString(parts[7]),
 		}
 
 		// Check for compose project
-		projectName := parseComposeProjectLabel(container.Labels)
-		container.ComposeProject = projectName
+		container.ComposeProject = container.Labels[composeProjectLabel]
+		containers = append(containers, container)
+	}
+
+	if err := sortContainers(containers, req.SortBy, req.SortDesc); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
-		if projectName != "" {
-			groupsMap[projectName] = append(groupsMap[projectName], container)
+	// Group status reflects every matching container, not just the current page
+	projectContainers := make(map[string][]DockerContainer)
+	for _, container := range containers {
+		if container.ComposeProject != "" {
+			projectContainers[container.ComposeProject] = append(projectContainers[container.ComposeProject], container)
+		}
+	}
+
+	groupsMap := make(map[string][]DockerContainer)
+	ungrouped := []DockerContainer{}
+	for _, container := range paginateContainers(containers, req.Page, req.PageSize) {
+		if container.ComposeProject != "" {
+			groupsMap[container.ComposeProject] = append(groupsMap[container.ComposeProject], container)
 		} else {
 			ungrouped = append(ungrouped, container)
 		}
@@ -2011,22 +2088,29 @@ func connectToRemoteDocker(ctx echo.Context) error {
 	// Build final slice of ComposeGroup
 	var composeGroups []ComposeGroup
 	for projectName, containers := range groupsMap {
-		status := computeGroupStatus(containers)
+		all := projectContainers[projectName]
 		composeGroups = append(composeGroups, ComposeGroup{
 			Name:       projectName,
-			Status:     status,
+			Status:     computeGroupStatus(all),
+			Counts:     countGroupStates(all),
 			Containers: containers,
 		})
 	}
 
 	// Sort by project name
 	sort.Slice(composeGroups, func(i, j int) bool {
+		if req.SortBy == "project" && req.SortDesc {
+			return composeGroups[i].Name > composeGroups[j].Name
+		}
 		return composeGroups[i].Name < composeGroups[j].Name
 	})
 
 	response := DockerContainerResponse{
 		ComposeGroups: composeGroups,
 		Ungrouped:     ungrouped,
+		Total:         len(containers),
+		Page:          req.Page,
+		PageSize:      req.PageSize,
 	}
 	return ctx.JSON(http.StatusOK, response)
 }
@@ -2037,41 +2121,23 @@ func computeGroupStatus(containers []DockerContainer) string {
 	}
 
 	total := len(containers)
-	countUp := 0
-	for _, c := range containers {
-		if strings.Contains(strings.ToLower(c.Status), "up") {
-			countUp++
-		}
-	}
+	counts := countGroupStates(containers)
 
-	switch {
-	case countUp == 0:
-		// none up
-		return fmt.Sprintf("Stopped(%d)", total)
-	case countUp == total:
-		// all up
+	switch total {
+	case counts.Running:
 		return fmt.Sprintf("Running(%d)", total)
+	case counts.Stopped:
+		return fmt.Sprintf("Stopped(%d)", total)
+	case counts.Restarting:
+		return fmt.Sprintf("Restarting(%d)", total)
+	case counts.Paused:
+		return fmt.Sprintf("Paused(%d)", total)
+	case counts.Dead:
+		return fmt.Sprintf("Dead(%d)", total)
 	default:
-		// partial
-		return fmt.Sprintf("Partial(%d/%d)", countUp, total)
-	}
-}
-
-// parseComposeProjectLabel checks if the label string contains "com.docker.compose.project=XYZ"
-// and returns the project name if found, or empty string if not found.
-func parseComposeProjectLabel(labels string) string {
-	// Example labels string might look like:
-	//   "com.docker.compose.project=helios,com.docker.compose.version=2.15.1"
-	// or it might be empty or have other labels
-	pairs := strings.Split(labels, ",")
-	for _, pair := range pairs {
-		pair = strings.TrimSpace(pair)
-		if strings.HasPrefix(pair, "com.docker.compose.project=") {
-			// Extract everything after =
-			return strings.TrimPrefix(pair, "com.docker.compose.project=")
-		}
+		// mixed states, report how many are actually running
+		return fmt.Sprintf("Partial(%d/%d)", counts.Running, total)
 	}
-	return ""
 }
 
 func listen(path string) (net.Listener, error) {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"remote-docker/utils"
+)
+
+// Raw `docker network inspect` output. Only the fields we use are mapped.
+type networkInspectJSON struct {
+	Name       string `json:"Name"`
+	ID         string `json:"Id"`
+	Created    string `json:"Created"`
+	Scope      string `json:"Scope"`
+	Driver     string `json:"Driver"`
+	EnableIPv6 bool   `json:"EnableIPv6"`
+	IPAM       struct {
+		Driver  string            `json:"Driver"`
+		Options map[string]string `json:"Options"`
+		Config  []struct {
+			Subnet             string            `json:"Subnet"`
+			IPRange            string            `json:"IPRange"`
+			Gateway            string            `json:"Gateway"`
+			AuxiliaryAddresses map[string]string `json:"AuxiliaryAddresses"`
+		} `json:"Config"`
+	} `json:"IPAM"`
+	Internal   bool `json:"Internal"`
+	Attachable bool `json:"Attachable"`
+	Ingress    bool `json:"Ingress"`
+	Containers map[string]struct {
+		Name        string `json:"Name"`
+		EndpointID  string `json:"EndpointID"`
+		MacAddress  string `json:"MacAddress"`
+		IPv4Address string `json:"IPv4Address"`
+		IPv6Address string `json:"IPv6Address"`
+	} `json:"Containers"`
+	Options map[string]string `json:"Options"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+// Network with its address pools and attached containers
+type NetworkSummary struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	Scope  string `json:"scope"`
+	// IPAM driver, e.g. default, not the network driver
+	IPAMDriver string `json:"ipamDriver"`
+	// Subnet and gateway of the first pool, IPv4 preferred. See IPAM for all pools.
+	Subnet     string              `json:"subnet"`
+	Gateway    string              `json:"gateway"`
+	Internal   bool                `json:"internal"`
+	Attachable bool                `json:"attachable"`
+	Ingress    bool                `json:"ingress"`
+	IPv6       bool                `json:"ipv6"`
+	Created    string              `json:"created"`
+	IPAM       []NetworkIPAMConfig `json:"ipam"`
+	Options    map[string]string   `json:"options"`
+	Labels     map[string]string   `json:"labels"`
+	Containers []NetworkEndpoint   `json:"containers"`
+}
+
+// Address pool of a network
+type NetworkIPAMConfig struct {
+	Subnet             string            `json:"subnet"`
+	Gateway            string            `json:"gateway,omitempty"`
+	IPRange            string            `json:"ipRange,omitempty"`
+	AuxiliaryAddresses map[string]string `json:"auxiliaryAddresses,omitempty"`
+	IPv6               bool              `json:"ipv6"`
+}
+
+// Running container attached to a network. Addresses are in CIDR notation.
+type NetworkEndpoint struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	EndpointID  string `json:"endpointId"`
+	MacAddress  string `json:"macAddress,omitempty"`
+	IPv4Address string `json:"ipv4Address,omitempty"`
+	IPv6Address string `json:"ipv6Address,omitempty"`
+}
+
+// collectNetworks lists all networks with a single batched inspect
+func collectNetworks(username, hostname string) ([]NetworkSummary, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("network", "ls", "-q", "--no-trunc"))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to list networks: %v", err)
+	}
+	ids := []string{}
+	for _, id := range strings.Fields(string(output)) {
+		if utils.ValidateNetworkID(id) != nil {
+			logger.Warnf("Skipping network with unexpected ID: %q", id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return []NetworkSummary{}, nil, nil
+	}
+
+	output, err = tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(append([]string{"network", "inspect"}, ids...)...))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to inspect networks: %v", err)
+	}
+	var inspected []networkInspectJSON
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, output, fmt.Errorf("failed to parse network inspect output: %v", err)
+	}
+
+	networks := make([]NetworkSummary, 0, len(inspected))
+	for _, raw := range inspected {
+		networks = append(networks, newNetworkSummary(raw))
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks, nil, nil
+}
+
+// newNetworkSummary converts raw inspect output into the API model
+func newNetworkSummary(raw networkInspectJSON) NetworkSummary {
+	network := NetworkSummary{
+		ID:         shortContainerID(raw.ID),
+		Name:       raw.Name,
+		Driver:     raw.Driver,
+		Scope:      raw.Scope,
+		IPAMDriver: raw.IPAM.Driver,
+		Internal:   raw.Internal,
+		Attachable: raw.Attachable,
+		Ingress:    raw.Ingress,
+		IPv6:       raw.EnableIPv6,
+		Created:    raw.Created,
+		IPAM:       []NetworkIPAMConfig{},
+		Options:    raw.Options,
+		Labels:     raw.Labels,
+		Containers: []NetworkEndpoint{},
+	}
+	if network.IPAMDriver == "" {
+		network.IPAMDriver = "default"
+	}
+	if network.Options == nil {
+		network.Options = map[string]string{}
+	}
+	if network.Labels == nil {
+		network.Labels = map[string]string{}
+	}
+
+	for _, config := range raw.IPAM.Config {
+		ip, _, err := net.ParseCIDR(config.Subnet)
+		network.IPAM = append(network.IPAM, NetworkIPAMConfig{
+			Subnet:             config.Subnet,
+			Gateway:            config.Gateway,
+			IPRange:            config.IPRange,
+			AuxiliaryAddresses: config.AuxiliaryAddresses,
+			IPv6:               err == nil && ip.To4() == nil,
+		})
+	}
+	// Keep the single subnet fields for the table, preferring the IPv4 pool
+	for _, config := range network.IPAM {
+		if network.Subnet == "" || !config.IPv6 {
+			network.Subnet, network.Gateway = config.Subnet, config.Gateway
+		}
+		if !config.IPv6 {
+			break
+		}
+	}
+
+	for id, endpoint := range raw.Containers {
+		network.Containers = append(network.Containers, NetworkEndpoint{
+			ID:          shortContainerID(id),
+			Name:        endpoint.Name,
+			EndpointID:  endpoint.EndpointID,
+			MacAddress:  endpoint.MacAddress,
+			IPv4Address: endpoint.IPv4Address,
+			IPv6Address: endpoint.IPv6Address,
+		})
+	}
+	sort.Slice(network.Containers, func(i, j int) bool { return network.Containers[i].Name < network.Containers[j].Name })
+	return network
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const dualStackNetworkJSON = `{
+	"Name": "app_default",
+	"Id": "3f5c1e0c2b8a9d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e",
+	"Created": "2024-05-01T10:00:00.000000000Z",
+	"Scope": "local",
+	"Driver": "bridge",
+	"EnableIPv6": true,
+	"IPAM": {
+		"Driver": "default",
+		"Options": null,
+		"Config": [
+			{"Subnet": "fd00:dead:beef::/48", "Gateway": "fd00:dead:beef::1"},
+			{"Subnet": "172.20.0.0/16", "IPRange": "172.20.5.0/24", "Gateway": "172.20.0.1"}
+		]
+	},
+	"Internal": false,
+	"Attachable": true,
+	"Containers": {
+		"b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3": {
+			"Name": "web", "EndpointID": "e1", "MacAddress": "02:42:ac:14:00:03",
+			"IPv4Address": "172.20.5.3/16", "IPv6Address": "fd00:dead:beef::3/48"
+		},
+		"a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2": {
+			"Name": "db", "EndpointID": "e2", "MacAddress": "02:42:ac:14:00:02",
+			"IPv4Address": "172.20.5.2/16", "IPv6Address": ""
+		}
+	},
+	"Options": {"com.docker.network.bridge.name": "br-app"},
+	"Labels": {"com.docker.compose.project": "app"}
+}`
+
+func TestNewNetworkSummary(t *testing.T) {
+	var raw networkInspectJSON
+	if err := json.Unmarshal([]byte(dualStackNetworkJSON), &raw); err != nil {
+		t.Fatal(err)
+	}
+	network := newNetworkSummary(raw)
+
+	if network.ID != "3f5c1e0c2b8a" || network.Driver != "bridge" || network.IPAMDriver != "default" || !network.IPv6 {
+		t.Errorf("unexpected network: %+v", network)
+	}
+	if network.Subnet != "172.20.0.0/16" || network.Gateway != "172.20.0.1" {
+		t.Errorf("expected the IPv4 pool as primary subnet, got %s via %s", network.Subnet, network.Gateway)
+	}
+	if len(network.IPAM) != 2 || !network.IPAM[0].IPv6 || network.IPAM[1].IPv6 || network.IPAM[1].IPRange != "172.20.5.0/24" {
+		t.Errorf("unexpected IPAM configs: %+v", network.IPAM)
+	}
+	if len(network.Containers) != 2 || network.Containers[0].Name != "db" || network.Containers[1].IPv6Address != "fd00:dead:beef::3/48" {
+		t.Errorf("unexpected containers: %+v", network.Containers)
+	}
+	if network.Options["com.docker.network.bridge.name"] != "br-app" || network.Labels["com.docker.compose.project"] != "app" {
+		t.Errorf("unexpected options or labels: %+v %+v", network.Options, network.Labels)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Request to create a network
+type NetworkCreateRequest struct {
+	Hostname   string            `json:"hostname"`
+	Username   string            `json:"username"`
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"` // defaults to bridge
+	Subnet     string            `json:"subnet"` // CIDR, e.g. 172.28.0.0/16
+	Gateway    string            `json:"gateway"`
+	IPRange    string            `json:"ipRange"` // CIDR within the subnet containers get addresses from
+	Internal   bool              `json:"internal"`
+	Attachable bool              `json:"attachable"`
+	IPv6       bool              `json:"ipv6"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// Request to connect a container to a network or disconnect it
+type NetworkConnectRequest struct {
+	Hostname    string   `json:"hostname"`
+	Username    string   `json:"username"`
+	NetworkId   string   `json:"networkId"`
+	ContainerId string   `json:"containerId"`
+	IPv4Address string   `json:"ipv4Address"` // connect only, static address
+	IPv6Address string   `json:"ipv6Address"` // connect only, static address
+	Aliases     []string `json:"aliases"`     // connect only
+	Force       bool     `json:"force"`       // disconnect only
+}
+
+// Container attached to a network
+type NetworkContainer struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Create a network
+func createNetwork(ctx echo.Context) error {
+	var req NetworkCreateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.Name == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateNetworkID(req.Name); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid network name: %v", err)})
+	}
+	args, err := networkCreateArgs(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		logger.Errorf("Error creating network: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to create network: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"success": "true",
+		"id":      strings.TrimSpace(string(output)),
+		"message": fmt.Sprintf("Network %s created", req.Name),
+	})
+}
+
+// networkCreateArgs validates the request and builds the docker network create arguments
+func networkCreateArgs(req NetworkCreateRequest) ([]string, error) {
+	args := []string{"network", "create"}
+	if req.Driver != "" {
+		if !driverNamePattern.MatchString(req.Driver) {
+			return nil, fmt.Errorf("Invalid driver: %s", req.Driver)
+		}
+		args = append(args, "--driver", req.Driver)
+	}
+
+	if req.Subnet != "" {
+		_, subnet, err := net.ParseCIDR(req.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid subnet: %s", req.Subnet)
+		}
+		args = append(args, "--subnet", req.Subnet)
+		if req.Gateway != "" {
+			gateway := net.ParseIP(req.Gateway)
+			if gateway == nil || !subnet.Contains(gateway) {
+				return nil, fmt.Errorf("Gateway %s is not an address in %s", req.Gateway, req.Subnet)
+			}
+			args = append(args, "--gateway", req.Gateway)
+		}
+		if req.IPRange != "" {
+			rangeIP, ipRange, err := net.ParseCIDR(req.IPRange)
+			if err != nil || !subnet.Contains(rangeIP) || subnetBits(ipRange) < subnetBits(subnet) {
+				return nil, fmt.Errorf("IP range %s is not within %s", req.IPRange, req.Subnet)
+			}
+			args = append(args, "--ip-range", req.IPRange)
+		}
+	} else if req.Gateway != "" || req.IPRange != "" {
+		return nil, fmt.Errorf("Gateway and IP range require a subnet")
+	}
+
+	if req.Internal {
+		args = append(args, "--internal")
+	}
+	if req.Attachable {
+		args = append(args, "--attachable")
+	}
+	if req.IPv6 {
+		args = append(args, "--ipv6")
+	}
+	if err := validateKeyValues("label", req.Labels); err != nil {
+		return nil, err
+	}
+	for _, key := range sortedKeys(req.Labels) {
+		args = append(args, "--label", key+"="+req.Labels[key])
+	}
+	return append(args, req.Name), nil
+}
+
+func subnetBits(n *net.IPNet) int {
+	ones, _ := n.Mask.Size()
+	return ones
+}
+
+// Connect a container to a network
+func connectNetwork(ctx echo.Context) error {
+	var req NetworkConnectRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+	if err := validateNetworkConnectRequest(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	args := []string{"network", "connect"}
+	if req.IPv4Address != "" {
+		if ip := net.ParseIP(req.IPv4Address); ip == nil || ip.To4() == nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid IPv4 address: %s", req.IPv4Address)})
+		}
+		args = append(args, "--ip", req.IPv4Address)
+	}
+	if req.IPv6Address != "" {
+		if ip := net.ParseIP(req.IPv6Address); ip == nil || ip.To4() != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid IPv6 address: %s", req.IPv6Address)})
+		}
+		args = append(args, "--ip6", req.IPv6Address)
+	}
+	for _, alias := range req.Aliases {
+		if !optionKeyPattern.MatchString(alias) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid alias: %q", alias)})
+		}
+		args = append(args, "--alias", alias)
+	}
+	args = append(args, req.NetworkId, req.ContainerId)
+
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		logger.Errorf("Error connecting container to network: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to connect container: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"success": "true",
+		"message": fmt.Sprintf("Container %s connected to network %s", req.ContainerId, req.NetworkId),
+	})
+}
+
+// Disconnect a container from a network
+func disconnectNetwork(ctx echo.Context) error {
+	var req NetworkConnectRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+	if err := validateNetworkConnectRequest(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	args := []string{"network", "disconnect"}
+	if req.Force {
+		args = append(args, "--force")
+	}
+	args = append(args, req.NetworkId, req.ContainerId)
+
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		logger.Errorf("Error disconnecting container from network: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to disconnect container: %v", err),
+			"output": string(output),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"success": "true",
+		"message": fmt.Sprintf("Container %s disconnected from network %s", req.ContainerId, req.NetworkId),
+	})
+}
+
+func validateNetworkConnectRequest(req NetworkConnectRequest) error {
+	if req.NetworkId == "" {
+		return fmt.Errorf("Missing required fields")
+	}
+	if err := validateContainerRequest(ContainerRequest{Hostname: req.Hostname, Username: req.Username, ContainerId: req.ContainerId}); err != nil {
+		return err
+	}
+	if err := utils.ValidateNetworkID(req.NetworkId); err != nil {
+		return fmt.Errorf("Invalid network ID: %v", err)
+	}
+	return nil
+}
+
+// networkContainers lists the containers, running or stopped, attached to a
+// network. Stopped containers have no endpoint in docker network inspect but
+// still reference the network.
+func networkContainers(username, hostname, network string) ([]NetworkContainer, []byte, error) {
+	// The ps filter matches exact names, resolve IDs and ID prefixes first
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("network", "inspect", "--format", "{{.Name}}", network))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to inspect network %s: %v", network, err)
+	}
+	name := strings.TrimSpace(string(output))
+
+	output, err = tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(
+		"ps", "-a", "--filter", "network="+name, "--format", "{{.ID}}|{{.Names}}|{{.State}}"))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to list network containers: %v", err)
+	}
+
+	containers := []NetworkContainer{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.Split(line, "|")
+		if len(parts) != 3 {
+			continue
+		}
+		containers = append(containers, NetworkContainer{ID: parts[0], Name: parts[1], State: parts[2]})
+	}
+	return containers, nil, nil
+}
+
+// describeNetworkContainers lists the containers attached to a network for error messages
+func describeNetworkContainers(containers []NetworkContainer) string {
+	names := make([]string, 0, len(containers))
+	for _, c := range containers {
+		names = append(names, fmt.Sprintf("%s (%s)", c.Name, c.State))
+	}
+	return strings.Join(names, ", ")
+}
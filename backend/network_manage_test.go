@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetworkCreateArgs(t *testing.T) {
+	args, err := networkCreateArgs(NetworkCreateRequest{
+		Name:       "backend",
+		Driver:     "bridge",
+		Subnet:     "172.28.0.0/16",
+		Gateway:    "172.28.0.1",
+		IPRange:    "172.28.5.0/24",
+		Internal:   true,
+		Attachable: true,
+		Labels:     map[string]string{"tier": "db", "env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "network create --driver bridge --subnet 172.28.0.0/16 --gateway 172.28.0.1 --ip-range 172.28.5.0/24 --internal --attachable --label env=prod --label tier=db backend"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	for _, req := range []NetworkCreateRequest{
+		{Name: "n", Subnet: "172.28.0.0/16", Gateway: "10.0.0.1"},
+		{Name: "n", Subnet: "172.28.0.0/16", IPRange: "172.29.0.0/24"},
+		{Name: "n", Subnet: "172.28.0.0/24", IPRange: "172.28.0.0/16"},
+		{Name: "n", Gateway: "172.28.0.1"},
+		{Name: "n", Driver: "bridge --ipv6"},
+	} {
+		if _, err := networkCreateArgs(req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+}
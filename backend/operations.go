@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Operation states
+const (
+	operationQueued    = "queued"
+	operationRunning   = "running"
+	operationCompleted = "completed"
+	operationFailed    = "failed"
+	operationCancelled = "cancelled"
+)
+
+// Finished operations are kept this long for status queries
+const operationRetention = time.Hour
+
+// Operation is a long running background task such as an image pull. The
+// UI polls or streams it by ID; it keeps running when the client goes away.
+type Operation struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Hostname   string      `json:"hostname"`
+	Username   string      `json:"username"`
+	Target     string      `json:"target"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Progress   interface{} `json:"progress,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	StartedAt  string      `json:"startedAt"`
+	FinishedAt string      `json:"finishedAt,omitempty"`
+}
+
+// Request referring to an operation
+type OperationRequest struct {
+	OperationId string `json:"operationId"`
+}
+
+// Request listing the operations of an environment
+type OperationListRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Kind     string `json:"kind"`
+}
+
+// operationFunc does the work of an operation. It reports progress through
+// update and returns the result. It must stop when ctx is cancelled.
+type operationFunc func(ctx context.Context, update func(progress interface{})) (interface{}, error)
+
+type trackedOperation struct {
+	op       Operation
+	cancel   context.CancelFunc
+	seq      uint64 // start order, IDs do not sort across kinds
+	finished time.Time
+	// closed and replaced on every change so watchers can wait for the next one
+	changed chan struct{}
+}
+
+// operationTracker runs operations in the background and limits how many of
+// one kind run at the same time per environment
+type operationTracker struct {
+	mu      sync.Mutex
+	ops     map[string]*trackedOperation
+	running map[string]int
+	// signalled whenever a slot frees up
+	slotFreed chan struct{}
+	limits    map[string]func() int
+	lastSeq   uint64
+}
+
+var operations = newOperationTracker()
+
+func newOperationTracker() *operationTracker {
+	return &operationTracker{
+		ops:       make(map[string]*trackedOperation),
+		running:   make(map[string]int),
+		slotFreed: make(chan struct{}),
+		limits:    make(map[string]func() int),
+	}
+}
+
+// setLimit configures the per environment concurrency cap of a kind. The
+// function is evaluated whenever an operation waits for a slot, so settings
+// changes apply to queued operations.
+func (t *operationTracker) setLimit(kind string, limit func() int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits[kind] = limit
+}
+
+// start queues an operation and returns its initial state
+func (t *operationTracker) start(kind, username, hostname, target string, fn operationFunc) Operation {
+	ctx, cancel := context.WithCancel(context.Background())
+	tracked := &trackedOperation{
+		op: Operation{
+			ID:        fmt.Sprintf("%s-%d", kind, time.Now().UnixNano()),
+			Kind:      kind,
+			Hostname:  hostname,
+			Username:  username,
+			Target:    target,
+			Status:    operationQueued,
+			StartedAt: time.Now().Format(time.RFC3339),
+		},
+		cancel:  cancel,
+		changed: make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	t.pruneLocked()
+	t.lastSeq++
+	tracked.seq = t.lastSeq
+	t.ops[tracked.op.ID] = tracked
+	snapshot := tracked.op
+	t.mu.Unlock()
+
+	utils.SafeGo(logger, kind+" "+tracked.op.ID, func() {
+		defer cancel()
+		slot := kind + "|" + connectionKey(username, hostname)
+		if err := t.acquire(ctx, kind, slot); err != nil {
+			t.finish(ctx, tracked, nil, err)
+			return
+		}
+		defer t.release(slot)
+
+		t.modify(tracked, func(op *Operation) { op.Status = operationRunning })
+		result, err := fn(ctx, func(progress interface{}) {
+			t.modify(tracked, func(op *Operation) { op.Progress = progress })
+		})
+		t.finish(ctx, tracked, result, err)
+	})
+
+	return snapshot
+}
+
+// acquire waits for a free slot of the kind in the environment
+func (t *operationTracker) acquire(ctx context.Context, kind, slot string) error {
+	for {
+		t.mu.Lock()
+		limit := 0
+		if fn, ok := t.limits[kind]; ok {
+			limit = fn()
+		}
+		if limit <= 0 || t.running[slot] < limit {
+			t.running[slot]++
+			t.mu.Unlock()
+			return nil
+		}
+		freed := t.slotFreed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+func (t *operationTracker) release(slot string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running[slot]--
+	if t.running[slot] <= 0 {
+		delete(t.running, slot)
+	}
+	close(t.slotFreed)
+	t.slotFreed = make(chan struct{})
+}
+
+// modify changes an operation and wakes up its watchers
+func (t *operationTracker) modify(tracked *trackedOperation, fn func(op *Operation)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&tracked.op)
+	close(tracked.changed)
+	tracked.changed = make(chan struct{})
+}
+
+func (t *operationTracker) finish(ctx context.Context, tracked *trackedOperation, result interface{}, err error) {
+	t.modify(tracked, func(op *Operation) {
+		op.FinishedAt = time.Now().Format(time.RFC3339)
+		op.Result = result
+		// A cancel that arrives after the work succeeded does not undo it
+		switch {
+		case err == nil:
+			op.Status = operationCompleted
+		case ctx.Err() != nil:
+			op.Status = operationCancelled
+		default:
+			op.Status = operationFailed
+			op.Error = err.Error()
+		}
+		tracked.finished = time.Now()
+	})
+	if err != nil && ctx.Err() == nil {
+		logger.Errorf("Operation %s failed: %v", tracked.op.ID, err)
+	}
+}
+
+// watch returns the current state and a channel closed on the next change
+func (t *operationTracker) watch(id string) (Operation, <-chan struct{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked, ok := t.ops[id]
+	if !ok {
+		return Operation{}, nil, false
+	}
+	return tracked.op, tracked.changed, true
+}
+
+// cancel stops a queued or running operation
+func (t *operationTracker) cancel(id string) (Operation, bool) {
+	t.mu.Lock()
+	tracked, ok := t.ops[id]
+	t.mu.Unlock()
+	if !ok {
+		return Operation{}, false
+	}
+	tracked.cancel()
+	op, _, _ := t.watch(id)
+	return op, true
+}
+
+// list returns the operations of an environment, newest first
+func (t *operationTracker) list(username, hostname, kind string) []Operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	matching := []*trackedOperation{}
+	for _, tracked := range t.ops {
+		op := tracked.op
+		if op.Username == username && op.Hostname == hostname && (kind == "" || op.Kind == kind) {
+			matching = append(matching, tracked)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].seq > matching[j].seq })
+	result := make([]Operation, len(matching))
+	for i, tracked := range matching {
+		result[i] = tracked.op
+	}
+	return result
+}
+
+func (t *operationTracker) pruneLocked() {
+	for id, tracked := range t.ops {
+		if !tracked.finished.IsZero() && time.Since(tracked.finished) > operationRetention {
+			delete(t.ops, id)
+		}
+	}
+}
+
+func isFinishedOperation(status string) bool {
+	return status == operationCompleted || status == operationFailed || status == operationCancelled
+}
+
+// Get the state of an operation
+func getOperation(ctx echo.Context) error {
+	var req OperationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	op, _, ok := operations.watch(req.OperationId)
+	if !ok {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Operation not found"})
+	}
+	return ctx.JSON(http.StatusOK, op)
+}
+
+// List the operations of an environment
+func listOperations(ctx echo.Context) error {
+	var req OperationListRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	return ctx.JSON(http.StatusOK, operations.list(req.Username, req.Hostname, req.Kind))
+}
+
+// Cancel an operation
+func cancelOperation(ctx echo.Context) error {
+	var req OperationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	op, ok := operations.cancel(req.OperationId)
+	if !ok {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Operation not found"})
+	}
+	return ctx.JSON(http.StatusOK, op)
+}
+
+// Stream the state of an operation as NDJSON until it finishes. Updates are
+// coalesced to a few per second.
+func streamOperation(ctx echo.Context) error {
+	var req OperationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if _, _, ok := operations.watch(req.OperationId); !ok {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Operation not found"})
+	}
+
+	stream := newEventStream(ctx)
+	for {
+		op, changed, _ := operations.watch(req.OperationId)
+		if err := stream.Send(op); err != nil {
+			return nil
+		}
+		if isFinishedOperation(op.Status) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-changed:
+		}
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
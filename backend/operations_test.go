@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitForStatus waits until the operation reaches status
+func waitForStatus(t *testing.T, tracker *operationTracker, id, status string) Operation {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		op, changed, ok := tracker.watch(id)
+		if !ok {
+			t.Fatalf("operation %s not found", id)
+		}
+		if op.Status == status {
+			return op
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("operation %s is %s, expected %s", id, op.Status, status)
+		}
+	}
+}
+
+func countStatus(tracker *operationTracker, ids ...string) map[string]int {
+	counts := make(map[string]int)
+	for _, id := range ids {
+		op, _, _ := tracker.watch(id)
+		counts[op.Status]++
+	}
+	return counts
+}
+
+// blockingOperation runs until release is closed or it is cancelled
+func blockingOperation(release <-chan struct{}) operationFunc {
+	return func(ctx context.Context, update func(interface{})) (interface{}, error) {
+		update("working")
+		select {
+		case <-release:
+			return "done", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestOperationConcurrencyLimit(t *testing.T) {
+	tracker := newOperationTracker()
+	tracker.setLimit("pull", func() int { return 1 })
+
+	release := map[string]chan struct{}{"a": make(chan struct{}), "b": make(chan struct{}), "other": make(chan struct{})}
+	first := tracker.start("pull", "alice", "build-1", "a", blockingOperation(release["a"]))
+	second := tracker.start("pull", "alice", "build-1", "b", blockingOperation(release["b"]))
+	otherHost := tracker.start("pull", "alice", "build-2", "c", blockingOperation(release["other"]))
+	otherKind := tracker.start("build", "alice", "build-1", "d", blockingOperation(release["other"]))
+
+	waitForStatus(t, tracker, otherHost.ID, operationRunning)
+	waitForStatus(t, tracker, otherKind.ID, operationRunning)
+	// Either operation on build-1 may get the slot first, the other one waits
+	deadline := time.Now().Add(5 * time.Second)
+	for countStatus(tracker, first.ID, second.ID)[operationRunning] == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the queued operation the chance to start if the limit was not applied
+	time.Sleep(20 * time.Millisecond)
+	if counts := countStatus(tracker, first.ID, second.ID); counts[operationRunning] != 1 || counts[operationQueued] != 1 {
+		t.Fatalf("expected one running and one queued operation on the same host, got %v", counts)
+	}
+
+	// The queued operation starts once the running one finishes
+	running, queued := first, second
+	if op, _, _ := tracker.watch(first.ID); op.Status != operationRunning {
+		running, queued = second, first
+	}
+	close(release[running.Target])
+	waitForStatus(t, tracker, running.ID, operationCompleted)
+	waitForStatus(t, tracker, queued.ID, operationRunning)
+
+	close(release[queued.Target])
+	close(release["other"])
+	for _, id := range []string{first.ID, second.ID, otherHost.ID, otherKind.ID} {
+		if op := waitForStatus(t, tracker, id, operationCompleted); op.Result != "done" || op.Progress != "working" {
+			t.Errorf("unexpected operation state: %+v", op)
+		}
+	}
+	// Slots are released right after the final state is published
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		tracker.mu.Lock()
+		slots := len(tracker.running)
+		tracker.mu.Unlock()
+		if slots == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d slots not released", slots)
+		}
+	}
+}
+
+func TestOperationCancel(t *testing.T) {
+	tracker := newOperationTracker()
+	tracker.setLimit("pull", func() int { return 1 })
+
+	release := make(chan struct{})
+	defer close(release)
+	running := tracker.start("pull", "alice", "build-1", "a", blockingOperation(release))
+	waitForStatus(t, tracker, running.ID, operationRunning)
+
+	// Cancelling a queued operation never runs it
+	called := make(chan struct{}, 1)
+	queued := tracker.start("pull", "alice", "build-1", "b", func(ctx context.Context, update func(interface{})) (interface{}, error) {
+		called <- struct{}{}
+		return nil, nil
+	})
+	if _, ok := tracker.cancel(queued.ID); !ok {
+		t.Fatal("queued operation not found")
+	}
+	waitForStatus(t, tracker, queued.ID, operationCancelled)
+	select {
+	case <-called:
+		t.Error("cancelled operation was run")
+	default:
+	}
+
+	// A running operation is stopped through its context and frees its slot
+	tracker.cancel(running.ID)
+	if op := waitForStatus(t, tracker, running.ID, operationCancelled); op.Error != "" || op.FinishedAt == "" {
+		t.Errorf("unexpected cancelled operation: %+v", op)
+	}
+	next := tracker.start("pull", "alice", "build-1", "c", blockingOperation(release))
+	waitForStatus(t, tracker, next.ID, operationRunning)
+
+	if _, ok := tracker.cancel("pull-0"); ok {
+		t.Error("expected unknown operations to be reported")
+	}
+}
+
+func TestOperationCancelAfterSuccess(t *testing.T) {
+	tracker := newOperationTracker()
+	release := make(chan struct{})
+	op := tracker.start("pull", "alice", "build-1", "a", func(ctx context.Context, update func(interface{})) (interface{}, error) {
+		<-release
+		return "done", nil
+	})
+	waitForStatus(t, tracker, op.ID, operationRunning)
+
+	// The work ignores the cancel and finishes anyway
+	tracker.cancel(op.ID)
+	close(release)
+	if op := waitForStatus(t, tracker, op.ID, operationCompleted); op.Result != "done" {
+		t.Errorf("unexpected operation state: %+v", op)
+	}
+}
+
+func TestOperationListOrder(t *testing.T) {
+	tracker := newOperationTracker()
+	release := make(chan struct{})
+	defer close(release)
+	// "pull" sorts after "build" by ID, the list must follow start order
+	pull := tracker.start("pull", "alice", "build-1", "a", blockingOperation(release))
+	build := tracker.start("build", "alice", "build-1", "b", blockingOperation(release))
+	tracker.start("pull", "bob", "build-1", "c", blockingOperation(release))
+
+	ops := tracker.list("alice", "build-1", "")
+	if len(ops) != 2 || ops[0].ID != build.ID || ops[1].ID != pull.ID {
+		t.Errorf("expected newest first, got %+v", ops)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+var registryHostPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]*(:[0-9]{1,5})?$`)
+
+// Registry logins, stored encrypted next to the settings
+var registryLogins = newCredentialStore(filepath.Dir(settingsFilePath))
+
+// Stored registry login. The token never leaves the backend.
+type RegistryCredential struct {
+	ID        string               `json:"id"`
+	Registry  string               `json:"registry"`
+	Username  string               `json:"username"`
+	CreatedAt string               `json:"createdAt"`
+	UpdatedAt string               `json:"updatedAt"`
+	AppliedTo []AppliedEnvironment `json:"appliedTo"`
+}
+
+// Environment a login has been applied to
+type AppliedEnvironment struct {
+	Hostname  string `json:"hostname"`
+	Username  string `json:"username"`
+	AppliedAt string `json:"appliedAt"`
+}
+
+// Request to store a registry login
+type SaveCredentialRequest struct {
+	Registry string `json:"registry"` // host[:port], defaults to docker.io
+	Username string `json:"username"`
+	Token    string `json:"token"` // password or access token
+}
+
+// Request referring to a stored login, and an environment for apply and remove
+type CredentialRequest struct {
+	CredentialId string `json:"credentialId"`
+	Hostname     string `json:"hostname"`
+	Username     string `json:"username"`
+}
+
+// Request listing stored logins, optionally only those applied to an environment
+type CredentialListRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+}
+
+// List stored registry logins
+func listRegistryCredentials(ctx echo.Context) error {
+	var req CredentialListRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	credentials, err := registryLogins.list()
+	if err != nil {
+		logger.Errorf("Error reading registry credentials: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to read credentials: %v", err)})
+	}
+	if req.Hostname == "" && req.Username == "" {
+		return ctx.JSON(http.StatusOK, credentials)
+	}
+
+	applied := []RegistryCredential{}
+	for _, c := range credentials {
+		if c.appliedIndex(req.Username, req.Hostname) >= 0 {
+			applied = append(applied, c)
+		}
+	}
+	return ctx.JSON(http.StatusOK, applied)
+}
+
+// Store a registry login, replacing the token of an existing one
+func saveRegistryCredential(ctx echo.Context) error {
+	var req SaveCredentialRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Registry == "" {
+		req.Registry = defaultRegistry
+	}
+	if req.Username == "" || req.Token == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if len(req.Registry) > 255 || !registryHostPattern.MatchString(req.Registry) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid registry host"})
+	}
+	if strings.ContainsAny(req.Username, "\r\n") || strings.ContainsAny(req.Token, "\r\n") {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Username and token must be a single line"})
+	}
+
+	credential, err := registryLogins.save(req.Registry, req.Username, req.Token)
+	if err != nil {
+		logger.Errorf("Error saving registry credential: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to save credential: %v", err)})
+	}
+	return ctx.JSON(http.StatusOK, credential)
+}
+
+// Delete a stored registry login. Environments it was applied to keep their login.
+func deleteRegistryCredential(ctx echo.Context) error {
+	var req CredentialRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.CredentialId == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	found, err := registryLogins.delete(req.CredentialId)
+	if err != nil {
+		logger.Errorf("Error deleting registry credential: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to delete credential: %v", err)})
+	}
+	if !found {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Credential not found"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"success": "Credential deleted"})
+}
+
+// Log an environment in to a registry with a stored login. The token is
+// passed on stdin of the SSH session, never on a command line.
+func applyRegistryCredential(ctx echo.Context) error {
+	var req CredentialRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	credential, token, status, err := credentialForEnvironment(req)
+	if err != nil {
+		return ctx.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	args := []string{"login", "--username", credential.Username, "--password-stdin"}
+	if credential.Registry != defaultRegistry {
+		args = append(args, credential.Registry)
+	}
+	var output strings.Builder
+	err = tunnelManager.StreamCommand(ctx.Request().Context(), req.Username, req.Hostname,
+		utils.BuildDockerCommand(args...)+" 2>&1", strings.NewReader(token+"\n"), &output)
+	if err != nil {
+		logger.Errorf("Error logging %s in to %s: %v", req.Hostname, credential.Registry, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to log in to %s: %v", credential.Registry, err),
+			"output": output.String(),
+		})
+	}
+
+	updated, err := registryLogins.setApplied(req.CredentialId, req.Username, req.Hostname, true)
+	if err != nil {
+		logger.Errorf("Error recording applied registry credential: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Logged in, but failed to record it: %v", err)})
+	}
+	return ctx.JSON(http.StatusOK, updated)
+}
+
+// Log an environment out of the registry of a stored login
+func removeRegistryCredential(ctx echo.Context) error {
+	var req CredentialRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	credential, _, status, err := credentialForEnvironment(req)
+	if err != nil {
+		return ctx.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	args := []string{"logout"}
+	if credential.Registry != defaultRegistry {
+		args = append(args, credential.Registry)
+	}
+	output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(args...))
+	if err != nil {
+		logger.Errorf("Error logging %s out of %s: %v, output: %s", req.Hostname, credential.Registry, err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to log out of %s: %v", credential.Registry, err),
+			"output": string(output),
+		})
+	}
+
+	updated, err := registryLogins.setApplied(req.CredentialId, req.Username, req.Hostname, false)
+	if err != nil {
+		logger.Errorf("Error recording removed registry credential: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Logged out, but failed to record it: %v", err)})
+	}
+	return ctx.JSON(http.StatusOK, updated)
+}
+
+// credentialForEnvironment validates a request naming a login and an
+// environment and decrypts the login. The returned status goes with the error.
+func credentialForEnvironment(req CredentialRequest) (*RegistryCredential, string, int, error) {
+	if req.CredentialId == "" || req.Hostname == "" || req.Username == "" {
+		return nil, "", http.StatusBadRequest, fmt.Errorf("Missing required fields")
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	credential, token, err := registryLogins.get(req.CredentialId)
+	if err != nil {
+		logger.Errorf("Error reading registry credential: %v", err)
+		return nil, "", http.StatusInternalServerError, fmt.Errorf("Failed to read credential: %v", err)
+	}
+	if credential == nil {
+		return nil, "", http.StatusNotFound, fmt.Errorf("Credential not found")
+	}
+	return credential, token, http.StatusOK, nil
+}
+
+// appliedIndex returns the position of an environment in AppliedTo, -1 if absent
+func (c RegistryCredential) appliedIndex(username, hostname string) int {
+	for i, env := range c.AppliedTo {
+		if env.Username == username && env.Hostname == hostname {
+			return i
+		}
+	}
+	return -1
+}
+
+// Stored form of a login, the token sealed with AES-GCM
+type storedCredential struct {
+	RegistryCredential
+	Sealed string `json:"sealed"`
+}
+
+// credentialStore keeps the logins in credentials.json. Tokens are encrypted
+// with a random key generated on first use and kept in credentials.key, both
+// readable by the backend only.
+type credentialStore struct {
+	path    string
+	keyPath string
+	mu      sync.Mutex
+}
+
+func newCredentialStore(dir string) *credentialStore {
+	return &credentialStore{
+		path:    filepath.Join(dir, "credentials.json"),
+		keyPath: filepath.Join(dir, "credentials.key"),
+	}
+}
+
+func (s *credentialStore) list() ([]RegistryCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]RegistryCredential, 0, len(stored))
+	for _, c := range stored {
+		credentials = append(credentials, c.RegistryCredential)
+	}
+	return credentials, nil
+}
+
+// get returns a login and its decrypted token, nil if it does not exist
+func (s *credentialStore) get(id string) (*RegistryCredential, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.readLocked()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, c := range stored {
+		if c.ID == id {
+			token, err := s.openLocked(c.Sealed)
+			if err != nil {
+				return nil, "", err
+			}
+			credential := c.RegistryCredential
+			return &credential, token, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// lookup returns the token of the first login for a registry host
+func (s *credentialStore) lookup(registry string) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.readLocked()
+	if err != nil {
+		return "", ""
+	}
+	for _, c := range stored {
+		if c.Registry == registry {
+			if token, err := s.openLocked(c.Sealed); err == nil {
+				return c.Username, token
+			}
+		}
+	}
+	return "", ""
+}
+
+func (s *credentialStore) save(registry, username, token string) (*RegistryCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.sealLocked(token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	index := -1
+	for i, c := range stored {
+		if c.Registry == registry && c.Username == username {
+			index = i
+		}
+	}
+	if index < 0 {
+		stored = append(stored, storedCredential{RegistryCredential: RegistryCredential{
+			ID:        fmt.Sprintf("cred-%d", time.Now().UnixNano()),
+			Registry:  registry,
+			Username:  username,
+			CreatedAt: now,
+			AppliedTo: []AppliedEnvironment{},
+		}})
+		index = len(stored) - 1
+	}
+	stored[index].Sealed = sealed
+	stored[index].UpdatedAt = now
+
+	if err := s.writeLocked(stored); err != nil {
+		return nil, err
+	}
+	credential := stored[index].RegistryCredential
+	return &credential, nil
+}
+
+func (s *credentialStore) delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.readLocked()
+	if err != nil {
+		return false, err
+	}
+	for i, c := range stored {
+		if c.ID == id {
+			return true, s.writeLocked(append(stored[:i], stored[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// setApplied records whether a login is applied to an environment
+func (s *credentialStore) setApplied(id, username, hostname string, applied bool) (*RegistryCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	for i := range stored {
+		c := &stored[i]
+		if c.ID != id {
+			continue
+		}
+		if j := c.appliedIndex(username, hostname); j >= 0 {
+			c.AppliedTo = append(c.AppliedTo[:j], c.AppliedTo[j+1:]...)
+		}
+		if applied {
+			c.AppliedTo = append(c.AppliedTo, AppliedEnvironment{Hostname: hostname, Username: username, AppliedAt: time.Now().Format(time.RFC3339)})
+		}
+		if err := s.writeLocked(stored); err != nil {
+			return nil, err
+		}
+		credential := c.RegistryCredential
+		return &credential, nil
+	}
+	return nil, fmt.Errorf("credential %s not found", id)
+}
+
+func (s *credentialStore) readLocked() ([]storedCredential, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return []storedCredential{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []storedCredential
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filepath.Base(s.path), err)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+	return stored, nil
+}
+
+func (s *credentialStore) writeLocked(stored []storedCredential) error {
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	// Replace the file atomically so a crash never leaves it half written
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// keyLocked reads the encryption key, generating it on first use
+func (s *credentialStore) keyLocked() ([]byte, error) {
+	key, err := os.ReadFile(s.keyPath)
+	if err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		return nil, fmt.Errorf("%s is corrupt", filepath.Base(s.keyPath))
+	}
+
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.keyPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.keyPath, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *credentialStore) gcmLocked() (cipher.AEAD, error) {
+	key, err := s.keyLocked()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealLocked encrypts a token as base64(nonce | ciphertext)
+func (s *credentialStore) sealLocked(token string) (string, error) {
+	gcm, err := s.gcmLocked()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+func (s *credentialStore) openLocked(sealed string) (string, error) {
+	gcm, err := s.gcmLocked()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid sealed token")
+	}
+	token, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %v", err)
+	}
+	return string(token), nil
+}
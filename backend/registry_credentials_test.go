@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCredentialStore(t *testing.T) {
+	store := newCredentialStore(t.TempDir())
+
+	saved, err := store.save("ghcr.io", "ci", "s3cret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saving the same login again replaces its token
+	if again, err := store.save("ghcr.io", "ci", "rotated-token"); err != nil || again.ID != saved.ID {
+		t.Fatalf("save = %+v, %v", again, err)
+	}
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "token") {
+		t.Errorf("token stored in plain text: %s", data)
+	}
+
+	credential, token, err := store.get(saved.ID)
+	if err != nil || credential == nil || token != "rotated-token" {
+		t.Fatalf("get = %+v, %q, %v", credential, token, err)
+	}
+	if user, token := store.lookup("ghcr.io"); user != "ci" || token != "rotated-token" {
+		t.Errorf("lookup = %q, %q", user, token)
+	}
+
+	if _, err := store.setApplied(saved.ID, "deploy", "prod.example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := store.setApplied(saved.ID, "deploy", "prod.example.com", true)
+	if err != nil || len(updated.AppliedTo) != 1 {
+		t.Fatalf("setApplied = %+v, %v", updated, err)
+	}
+	if updated, _ = store.setApplied(saved.ID, "deploy", "prod.example.com", false); len(updated.AppliedTo) != 0 {
+		t.Errorf("expected no applied environments, got %+v", updated.AppliedTo)
+	}
+
+	if found, err := store.delete(saved.ID); !found || err != nil {
+		t.Fatalf("delete = %v, %v", found, err)
+	}
+	if credentials, _ := store.list(); len(credentials) != 0 {
+		t.Errorf("expected no credentials, got %+v", credentials)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MIME type of newline delimited JSON event streams
+const mimeNDJSON = "application/x-ndjson"
+
+// eventStream writes newline delimited JSON events to a response and flushes
+// each one so the UI can render progress as it happens
+type eventStream struct {
+	ctx     echo.Context
+	mu      sync.Mutex
+	started bool
+}
+
+func newEventStream(ctx echo.Context) *eventStream {
+	return &eventStream{ctx: ctx}
+}
+
+// Send writes a single event
+func (s *eventStream) Send(event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := s.ctx.Response()
+	if !s.started {
+		s.started = true
+		resp.Header().Set(echo.HeaderContentType, mimeNDJSON)
+		resp.Header().Set("Cache-Control", "no-cache")
+		resp.WriteHeader(http.StatusOK)
+	}
+	if _, err := resp.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	resp.Flush()
+	return nil
+}
+
+// lineWriter splits command output into lines and hands each one to fn.
+// Flush must be called after the command exits to emit a trailing partial line.
+type lineWriter struct {
+	fn  func(line string) error
+	buf []byte
+}
+
+func newLineWriter(fn func(line string) error) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if err := w.fn(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush emits any buffered partial line
+func (w *lineWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := strings.TrimRight(string(w.buf), "\r")
+	w.buf = nil
+	return w.fn(line)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// Node types of the topology graph
+const (
+	topologyNodeHost      = "host"
+	topologyNodeContainer = "container"
+	topologyNodeNetwork   = "network"
+	topologyNodeVolume    = "volume"
+	topologyNodeImage     = "image"
+	topologyNodeProject   = "project"
+)
+
+// Edge types of the topology graph
+const (
+	topologyEdgeAttachment = "attachment" // container -> network
+	topologyEdgeMount      = "mount"      // container -> volume
+	topologyEdgeUses       = "uses"       // container -> image
+	topologyEdgePublishes  = "publishes"  // host -> container
+	topologyEdgeMember     = "member"     // project -> container, network or volume
+)
+
+// Graph of the containers on a host and what they are connected to
+type TopologyGraph struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+type TopologyNode struct {
+	ID         string            `json:"id"` // <type>:<name or short ID>
+	Type       string            `json:"type"`
+	Label      string            `json:"label"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type TopologyEdge struct {
+	From       string            `json:"from"`
+	To         string            `json:"to"`
+	Type       string            `json:"type"`
+	Label      string            `json:"label,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Volume as listed for the topology, with its compose project if any
+type topologyVolume struct {
+	Name    string
+	Project string
+}
+
+// Build the topology graph of a host, as JSON or Graphviz DOT
+func getTopology(ctx echo.Context) error {
+	var req struct {
+		Hostname string `json:"hostname"`
+		Username string `json:"username"`
+		Format   string `json:"format"` // json (default) or dot
+	}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "dot" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format, expected json or dot"})
+	}
+
+	graph, output, err := collectTopology(req.Username, req.Hostname)
+	if err != nil {
+		logger.Errorf("Error building topology: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to build topology: %v", err),
+			"output": string(output),
+		})
+	}
+
+	if req.Format == "dot" {
+		return ctx.Blob(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(graph.DOT(req.Hostname)))
+	}
+	return ctx.JSON(http.StatusOK, graph)
+}
+
+// collectTopology gathers containers, networks and volumes in five round
+// trips: ps, inspect, network ls and inspect, and volume ls
+func collectTopology(username, hostname string) (*TopologyGraph, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("ps", "-a", "-q", "--no-trunc"))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to list containers: %v", err)
+	}
+	containers, output, err := inspectContainers(username, hostname, strings.Fields(string(output))...)
+	if err != nil {
+		return nil, output, err
+	}
+
+	networks, output, err := collectNetworks(username, hostname)
+	if err != nil {
+		return nil, output, err
+	}
+
+	output, err = tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(
+		"volume", "ls", "--format", `{{.Name}}|{{.Label "`+composeProjectLabel+`"}}`))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to list volumes: %v", err)
+	}
+	var volumes []topologyVolume
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, project, _ := strings.Cut(line, "|")
+		if name != "" {
+			volumes = append(volumes, topologyVolume{Name: name, Project: project})
+		}
+	}
+
+	return buildTopology(containers, networks, volumes), nil, nil
+}
+
+// topologyBuilder collects nodes and edges, adding each node once
+type topologyBuilder struct {
+	graph TopologyGraph
+	nodes map[string]bool
+}
+
+func (b *topologyBuilder) node(nodeType, key, label string, attributes map[string]string) string {
+	id := nodeType + ":" + key
+	if !b.nodes[id] {
+		b.nodes[id] = true
+		b.graph.Nodes = append(b.graph.Nodes, TopologyNode{ID: id, Type: nodeType, Label: label, Attributes: attributes})
+	}
+	return id
+}
+
+func (b *topologyBuilder) edge(from, to, edgeType, label string, attributes map[string]string) {
+	b.graph.Edges = append(b.graph.Edges, TopologyEdge{From: from, To: to, Type: edgeType, Label: label, Attributes: attributes})
+}
+
+func buildTopology(containers []containerInspectJSON, networks []NetworkSummary, volumes []topologyVolume) *TopologyGraph {
+	b := &topologyBuilder{
+		graph: TopologyGraph{Nodes: []TopologyNode{}, Edges: []TopologyEdge{}},
+		nodes: make(map[string]bool),
+	}
+	project := func(name string) string {
+		return b.node(topologyNodeProject, name, name, nil)
+	}
+
+	// Networks and volumes first so unused ones show up too
+	networkIDs := make(map[string]string, len(networks))
+	for _, n := range networks {
+		id := b.node(topologyNodeNetwork, n.ID, n.Name, map[string]string{"driver": n.Driver, "subnet": n.Subnet})
+		networkIDs[n.ID] = id
+		if p := n.Labels[composeProjectLabel]; p != "" {
+			b.edge(project(p), id, topologyEdgeMember, "", nil)
+		}
+	}
+	for _, v := range volumes {
+		id := b.node(topologyNodeVolume, v.Name, v.Name, nil)
+		if v.Project != "" {
+			b.edge(project(v.Project), id, topologyEdgeMember, "", nil)
+		}
+	}
+
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	host := ""
+	for _, c := range containers {
+		name := strings.TrimPrefix(c.Name, "/")
+		id := b.node(topologyNodeContainer, shortContainerID(c.ID), name, map[string]string{"state": c.State.Status, "image": c.Config.Image})
+
+		if p := c.Config.Labels[composeProjectLabel]; p != "" {
+			b.edge(project(p), id, topologyEdgeMember, c.Config.Labels["com.docker.compose.service"], nil)
+		}
+
+		imageKey := shortContainerID(strings.TrimPrefix(c.Image, "sha256:"))
+		b.edge(id, b.node(topologyNodeImage, imageKey, c.Config.Image, map[string]string{"id": c.Image}), topologyEdgeUses, "", nil)
+
+		for _, networkName := range sortedKeys(c.NetworkSettings.Networks) {
+			endpoint := c.NetworkSettings.Networks[networkName]
+			networkID, ok := networkIDs[shortContainerID(endpoint.NetworkID)]
+			if !ok {
+				// Removed network still referenced by a stopped container
+				networkID = b.node(topologyNodeNetwork, shortContainerID(endpoint.NetworkID), networkName, nil)
+			}
+			attributes := map[string]string{}
+			if endpoint.IPAddress != "" {
+				attributes["ipv4"] = endpoint.IPAddress
+			}
+			if endpoint.GlobalIPv6Address != "" {
+				attributes["ipv6"] = endpoint.GlobalIPv6Address
+			}
+			if endpoint.MacAddress != "" {
+				attributes["mac"] = endpoint.MacAddress
+			}
+			label := strings.Trim(endpoint.IPAddress+" "+endpoint.GlobalIPv6Address, " ")
+			b.edge(id, networkID, topologyEdgeAttachment, label, attributes)
+		}
+
+		for _, m := range c.Mounts {
+			if m.Type != "volume" {
+				continue
+			}
+			mode := "rw"
+			if !m.RW {
+				mode = "ro"
+			}
+			volumeID := b.node(topologyNodeVolume, m.Name, m.Name, nil)
+			b.edge(id, volumeID, topologyEdgeMount, m.Destination+" ("+mode+")", map[string]string{"destination": m.Destination, "mode": mode})
+		}
+
+		for _, port := range sortedKeys(c.NetworkSettings.Ports) {
+			for _, binding := range c.NetworkSettings.Ports[port] {
+				if binding.HostPort == "" {
+					continue
+				}
+				if host == "" {
+					host = b.node(topologyNodeHost, "host", "host", nil)
+				}
+				hostIP := binding.HostIP
+				if hostIP == "" {
+					hostIP = "0.0.0.0"
+				}
+				published := hostIP + ":" + binding.HostPort
+				b.edge(host, id, topologyEdgePublishes, published+" -> "+port, map[string]string{"hostIp": hostIP, "hostPort": binding.HostPort, "containerPort": port})
+			}
+		}
+	}
+	return &b.graph
+}
+
+// Graphviz styling per node type
+var topologyShapes = map[string]string{
+	topologyNodeHost:      "house",
+	topologyNodeContainer: "box",
+	topologyNodeNetwork:   "ellipse",
+	topologyNodeVolume:    "cylinder",
+	topologyNodeImage:     "note",
+	topologyNodeProject:   "folder",
+}
+
+// DOT renders the graph in Graphviz format, e.g. for `dot -Tsvg`
+func (g *TopologyGraph) DOT(name string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %s {\n", dotQuote(name))
+	sb.WriteString("  rankdir=LR;\n  node [fontname=\"Helvetica\"];\n  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, n := range g.Nodes {
+		label := n.Label
+		if n.Type != topologyNodeHost {
+			label = n.Type + "\n" + n.Label
+		}
+		fmt.Fprintf(&sb, "  %s [label=%s, shape=%s];\n", dotQuote(n.ID), dotQuote(label), topologyShapes[n.Type])
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.Type == topologyEdgeMember {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&sb, "  %s -> %s [label=%s%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(e.Label), style)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// dotQuote quotes an ID or label for DOT
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const topologyContainerJSON = `{
+	"Id": "c0ffee0000000000000000000000000000000000000000000000000000000001",
+	"Name": "/shop-web-1",
+	"Image": "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+	"State": {"Status": "running"},
+	"Config": {"Image": "nginx:1.25", "Labels": {"com.docker.compose.project": "shop", "com.docker.compose.service": "web"}},
+	"Mounts": [
+		{"Type": "volume", "Name": "shop_static", "Destination": "/usr/share/nginx/html", "RW": false},
+		{"Type": "bind", "Source": "/etc/nginx", "Destination": "/etc/nginx", "RW": true}
+	],
+	"NetworkSettings": {
+		"Ports": {"80/tcp": [{"HostIp": "0.0.0.0", "HostPort": "8080"}], "443/tcp": null},
+		"Networks": {"shop_default": {"NetworkID": "3f5c1e0c2b8a9d7e", "IPAddress": "172.20.0.3", "MacAddress": "02:42:ac:14:00:03"}}
+	}
+}`
+
+func TestBuildTopology(t *testing.T) {
+	var c containerInspectJSON
+	if err := json.Unmarshal([]byte(topologyContainerJSON), &c); err != nil {
+		t.Fatal(err)
+	}
+	networks := []NetworkSummary{{ID: "3f5c1e0c2b8a", Name: "shop_default", Driver: "bridge", Labels: map[string]string{composeProjectLabel: "shop"}}}
+	volumes := []topologyVolume{{Name: "shop_static", Project: "shop"}, {Name: "unused"}}
+
+	graph := buildTopology([]containerInspectJSON{c}, networks, volumes)
+
+	nodes := map[string]string{}
+	for _, n := range graph.Nodes {
+		nodes[n.ID] = n.Type
+	}
+	for _, id := range []string{"container:c0ffee000000", "network:3f5c1e0c2b8a", "volume:shop_static", "volume:unused", "image:abcdef012345", "project:shop", "host:host"} {
+		if nodes[id] == "" {
+			t.Errorf("missing node %s in %v", id, nodes)
+		}
+	}
+	if len(graph.Nodes) != 7 {
+		t.Errorf("expected 7 nodes, got %d", len(graph.Nodes))
+	}
+
+	edges := map[string]string{}
+	for _, e := range graph.Edges {
+		edges[e.Type+" "+e.From+" "+e.To] = e.Label
+	}
+	for key, label := range map[string]string{
+		"attachment container:c0ffee000000 network:3f5c1e0c2b8a": "172.20.0.3",
+		"mount container:c0ffee000000 volume:shop_static":        "/usr/share/nginx/html (ro)",
+		"uses container:c0ffee000000 image:abcdef012345":         "",
+		"publishes host:host container:c0ffee000000":             "0.0.0.0:8080 -> 80/tcp",
+		"member project:shop container:c0ffee000000":             "web",
+		"member project:shop network:3f5c1e0c2b8a":               "",
+		"member project:shop volume:shop_static":                 "",
+	} {
+		if got, ok := edges[key]; !ok || got != label {
+			t.Errorf("edge %s: got %q (present %v), want %q", key, got, ok, label)
+		}
+	}
+	if len(graph.Edges) != 7 {
+		t.Errorf("expected 7 edges, got %d", len(graph.Edges))
+	}
+
+	dot := graph.DOT("prod")
+	if !strings.HasPrefix(dot, `digraph "prod" {`) || !strings.Contains(dot, `"host:host" -> "container:c0ffee000000" [label="0.0.0.0:8080 -> 80/tcp"];`) {
+		t.Errorf("unexpected DOT output:\n%s", dot)
+	}
+}
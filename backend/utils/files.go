@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Size limits for file transfers to and from containers
+const (
+	MaxUploadSize   int64 = 1 << 30 // 1 GiB
+	MaxDownloadSize int64 = 4 << 30 // 4 GiB
+)
+
+// ErrSizeLimitExceeded is returned once a transfer grows beyond its limit
+var ErrSizeLimitExceeded = errors.New("size limit exceeded")
+
+// CleanContainerPath validates an absolute path inside a container and returns it cleaned
+func CleanContainerPath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("path cannot be empty")
+	}
+	if len(p) > 4096 {
+		return "", fmt.Errorf("path too long")
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	if strings.ContainsAny(p, "\x00\n\r") {
+		return "", fmt.Errorf("path contains invalid characters")
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path must not contain '..'")
+		}
+	}
+	return path.Clean(p), nil
+}
+
+// ValidateFileName validates a single path element such as an uploaded file name
+func ValidateFileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("file name cannot be empty")
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("file name too long")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\x00\n\r") {
+		return fmt.Errorf("invalid file name")
+	}
+	return nil
+}
+
+// ValidateTransferSize checks a known transfer size against a limit
+func ValidateTransferSize(size, limit int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid size")
+	}
+	if size > limit {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrSizeLimitExceeded, size, limit)
+	}
+	return nil
+}
+
+// LimitWriter returns a writer that fails with ErrSizeLimitExceeded once more
+// than limit bytes have been written to it
+func LimitWriter(w io.Writer, limit int64) io.Writer {
+	return &limitWriter{w: w, remaining: limit}
+}
+
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, ErrSizeLimitExceeded
+	}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCleanContainerPath(t *testing.T) {
+	valid := map[string]string{
+		"/":               "/",
+		"/etc/nginx/":     "/etc/nginx",
+		"/var//log/./app": "/var/log/app",
+	}
+	for in, want := range valid {
+		got, err := CleanContainerPath(in)
+		if err != nil || got != want {
+			t.Errorf("CleanContainerPath(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "etc/passwd", "/etc/../root", "/tmp/a\nb", "/tmp/\x00"} {
+		if _, err := CleanContainerPath(in); err == nil {
+			t.Errorf("CleanContainerPath(%q) should fail", in)
+		}
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := LimitWriter(&buf, 5)
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("def")); !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Errorf("expected ErrSizeLimitExceeded, got %v", err)
+	}
+	if buf.String() != "abc" {
+		t.Errorf("unexpected content %q", buf.String())
+	}
+
+	if err := ValidateTransferSize(10, 5); !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Errorf("expected ErrSizeLimitExceeded, got %v", err)
+	}
+}
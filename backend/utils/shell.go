@@ -11,11 +11,14 @@ import (
 var (
 	// Safe patterns for validation
 	containerIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
-	imageNamePattern   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:/-]*$`)
+	imageNamePattern   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:/-]*(@sha256:[a-f0-9]{64})?$`)
 	volumeNamePattern  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
 	networkIDPattern   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
 	usernamePattern    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
 	hostnamePattern    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]*$`)
+	composeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	labelKeyPattern    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_./-]*$`)
+	platformPattern    = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
 )
 
 // ShellEscape escapes a string for safe use in shell commands
@@ -62,6 +65,14 @@ func ValidateImageName(name string) error {
 	return nil
 }
 
+// ValidatePlatform validates a platform like "linux/amd64" or "linux/arm/v7"
+func ValidatePlatform(platform string) error {
+	if len(platform) > 64 || !platformPattern.MatchString(platform) {
+		return fmt.Errorf("invalid platform format")
+	}
+	return nil
+}
+
 // ValidateVolumeName validates a Docker volume name
 func ValidateVolumeName(name string) error {
 	if name == "" {
@@ -90,6 +101,49 @@ func ValidateNetworkID(id string) error {
 	return nil
 }
 
+// ValidateComposeProjectName validates a Docker Compose project name
+func ValidateComposeProjectName(name string) error {
+	if name == "" {
+		return fmt.Errorf("compose project name cannot be empty")
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("compose project name too long")
+	}
+	if !composeNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid compose project name format")
+	}
+	return nil
+}
+
+// ValidateComposeServiceName validates a service name from a compose file
+func ValidateComposeServiceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("compose service name cannot be empty")
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("compose service name too long")
+	}
+	if !composeNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid compose service name format")
+	}
+	return nil
+}
+
+// ValidateLabelFilter validates a label selector of the form "key" or "key=value"
+func ValidateLabelFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("label filter cannot be empty")
+	}
+	if len(filter) > 1024 {
+		return fmt.Errorf("label filter too long")
+	}
+	key, _, _ := strings.Cut(filter, "=")
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key format")
+	}
+	return nil
+}
+
 // ValidateSSHUsername validates an SSH username
 func ValidateSSHUsername(username string) error {
 	if username == "" {
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+const (
+	// Image of the throwaway containers that read and write volume contents
+	volumeHelperImage = "alpine:3"
+	// Label marking helper containers, so leftovers can be found
+	volumeHelperLabel = "com.remote-docker.helper"
+	// Default number of backups kept per volume and environment, overridable
+	// with the volumeBackupRetention setting
+	defaultVolumeBackupRetention = 5
+	volumeBackupArchiveName      = "data.tar.gz"
+	volumeBackupManifestName     = "manifest.json"
+)
+
+var backupIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Local volume backups, one directory per backup next to the settings
+var volumeBackupDir = filepath.Join(filepath.Dir(settingsFilePath), "backups", "volumes")
+
+// Request to back up a volume
+type VolumeBackupRequest struct {
+	Hostname   string `json:"hostname"`
+	Username   string `json:"username"`
+	VolumeName string `json:"volumeName"`
+	// Pause the running containers using the volume while it is archived
+	PauseContainers bool `json:"pauseContainers"`
+}
+
+// Request to restore a backup into a volume
+type VolumeRestoreRequest struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	BackupId string `json:"backupId"`
+	// Target volume, defaults to the backed up one. Created if it does not exist.
+	VolumeName string `json:"volumeName"`
+	// Replace the contents of an existing volume
+	Overwrite bool `json:"overwrite"`
+}
+
+// Request listing backups, optionally filtered by volume or environment
+type VolumeBackupListRequest struct {
+	Hostname   string `json:"hostname"`
+	Username   string `json:"username"`
+	VolumeName string `json:"volumeName"`
+}
+
+// Request referring to a backup
+type VolumeBackupRequestById struct {
+	BackupId string `json:"backupId"`
+}
+
+// Metadata stored next to a backup archive
+type VolumeBackupManifest struct {
+	ID           string            `json:"id"`
+	Volume       string            `json:"volume"`
+	Driver       string            `json:"driver"`
+	Options      map[string]string `json:"options"`
+	Labels       map[string]string `json:"labels"`
+	Size         string            `json:"size"` // volume size as reported by docker
+	ArchiveBytes int64             `json:"archiveBytes"`
+	SHA256       string            `json:"sha256"`
+	CreatedAt    string            `json:"createdAt"`
+	Hostname     string            `json:"hostname"`
+	Username     string            `json:"username"`
+	Paused       []string          `json:"paused"` // containers paused during the backup
+}
+
+// Progress of a backup or restore
+type VolumeTransferProgress struct {
+	Phase string `json:"phase"`
+	Bytes int64  `json:"bytes"`
+	Total int64  `json:"total,omitempty"` // unset when the size is unknown
+}
+
+// Start backing up a volume to a local archive
+func backupVolume(ctx echo.Context) error {
+	var req VolumeBackupRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.VolumeName == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateVolumeName(req.VolumeName); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid volume name: %v", err)})
+	}
+
+	op := operations.start("volume-backup", req.Username, req.Hostname, req.VolumeName, func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runVolumeBackup(opCtx, req, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+func runVolumeBackup(ctx context.Context, req VolumeBackupRequest, update func(interface{})) (interface{}, error) {
+	update(VolumeTransferProgress{Phase: "preparing"})
+	volume, output, err := inspectVolume(req.Username, req.Hostname, req.VolumeName)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	manifest := VolumeBackupManifest{
+		ID:        fmt.Sprintf("%s-%s", req.VolumeName, time.Now().UTC().Format("20060102T150405Z")),
+		Volume:    req.VolumeName,
+		Driver:    volume.Driver,
+		Options:   volume.Options,
+		Labels:    volume.Labels,
+		Size:      "N/A",
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Hostname:  req.Hostname,
+		Username:  req.Username,
+		Paused:    []string{},
+	}
+	if output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand("system", "df", "-v", "--format", "{{json .}}")); err == nil {
+		if sizes, _, err := parseVolumeDiskUsage(output); err == nil && sizes[req.VolumeName] != "" {
+			manifest.Size = sizes[req.VolumeName]
+		}
+	}
+
+	if req.PauseContainers {
+		usage, output, err := volumeUsers(req.Username, req.Hostname)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		for _, u := range usage.users[req.VolumeName] {
+			if u.State == "running" {
+				manifest.Paused = append(manifest.Paused, u.ID)
+			}
+		}
+		if len(manifest.Paused) > 0 {
+			update(VolumeTransferProgress{Phase: "pausing"})
+			if output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(append([]string{"pause"}, manifest.Paused...)...)); err != nil {
+				return nil, fmt.Errorf("failed to pause containers: %v: %s", err, strings.TrimSpace(string(output)))
+			}
+			// Unpause even when the backup fails or is cancelled
+			defer func() {
+				if output, err := tunnelManager.ExecuteCommand(req.Username, req.Hostname, utils.BuildDockerCommand(append([]string{"unpause"}, manifest.Paused...)...)); err != nil {
+					logger.Errorf("Failed to unpause containers %v: %v, output: %s", manifest.Paused, err, string(output))
+				}
+			}()
+		}
+	}
+
+	dir := filepath.Join(volumeBackupDir, manifest.ID)
+	if err := os.MkdirAll(volumeBackupDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory, a backup may already be running: %v", err)
+	}
+	archive, err := os.Create(filepath.Join(dir, volumeBackupArchiveName))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create backup archive: %v", err)
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(archive, hash), report: func(n int64) {
+		update(VolumeTransferProgress{Phase: "archiving", Bytes: n})
+	}}
+	update(VolumeTransferProgress{Phase: "archiving"})
+	err = streamVolumeHelper(ctx, req.Username, req.Hostname, "backup", req.VolumeName, true, nil, counter, "tar", "-czf", "-", "-C", "/volume", ".")
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to archive volume: %v", err)
+	}
+
+	manifest.ArchiveBytes = counter.n
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err := writeBackupManifest(dir, manifest); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	pruneVolumeBackups(manifest, loadSettingInt("volumeBackupRetention", defaultVolumeBackupRetention))
+	return manifest, nil
+}
+
+// Start restoring a backup into a volume
+func restoreVolume(ctx echo.Context) error {
+	var req VolumeRestoreRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.BackupId == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	manifest, err := readBackupManifest(req.BackupId)
+	if err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if req.VolumeName == "" {
+		req.VolumeName = manifest.Volume
+	}
+	if err := utils.ValidateVolumeName(req.VolumeName); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid volume name: %v", err)})
+	}
+
+	op := operations.start("volume-restore", req.Username, req.Hostname, req.VolumeName, func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runVolumeRestore(opCtx, req, *manifest, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+func runVolumeRestore(ctx context.Context, req VolumeRestoreRequest, manifest VolumeBackupManifest, update func(interface{})) (result interface{}, err error) {
+	path := filepath.Join(volumeBackupDir, manifest.ID, volumeBackupArchiveName)
+	update(VolumeTransferProgress{Phase: "verifying", Total: manifest.ArchiveBytes})
+	if sum, err := fileSHA256(path); err != nil {
+		return nil, fmt.Errorf("failed to read backup archive: %v", err)
+	} else if sum != manifest.SHA256 {
+		return nil, fmt.Errorf("backup archive is corrupt: checksum mismatch")
+	}
+
+	existing, _, inspectErr := inspectVolume(req.Username, req.Hostname, req.VolumeName)
+	switch {
+	case inspectErr == nil && !req.Overwrite:
+		return nil, fmt.Errorf("volume %s already exists, restore with overwrite to replace its contents", req.VolumeName)
+	case inspectErr == nil:
+		usage, output, err := volumeUsers(req.Username, req.Hostname)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		for _, u := range usage.users[existing.Name] {
+			if u.State == "running" || u.State == "paused" {
+				return nil, fmt.Errorf("volume %s is in use by %s, stop them first", req.VolumeName, describeVolumeUsers(usage.users[existing.Name]))
+			}
+		}
+	default:
+		update(VolumeTransferProgress{Phase: "creating", Total: manifest.ArchiveBytes})
+		if output, err := createDockerVolume(req.Username, req.Hostname, req.VolumeName, manifest.Driver, manifest.Options, manifest.Labels); err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		// Do not leave a half restored volume behind that did not exist before
+		defer func() {
+			if err != nil {
+				removeDockerVolume(req.Username, req.Hostname, req.VolumeName)
+			}
+		}()
+	}
+
+	archive, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup archive: %v", err)
+	}
+	defer archive.Close()
+
+	reader := &countingReader{r: archive, report: func(n int64) {
+		update(VolumeTransferProgress{Phase: "restoring", Bytes: n, Total: manifest.ArchiveBytes})
+	}}
+	update(VolumeTransferProgress{Phase: "restoring", Total: manifest.ArchiveBytes})
+	err = streamVolumeHelper(ctx, req.Username, req.Hostname, "restore", req.VolumeName, false, reader, io.Discard,
+		"sh", "-c", stagedExtractScript("-xzf"), "sh", "/volume")
+	if err != nil {
+		if inspectErr == nil {
+			cleanupRestoreStaging(req.Username, req.Hostname, req.VolumeName)
+		}
+		return nil, fmt.Errorf("failed to restore volume: %v", err)
+	}
+	return map[string]string{"volume": req.VolumeName, "backupId": manifest.ID}, nil
+}
+
+// Directory inside the volume an archive is extracted to before it replaces
+// the volume contents
+const restoreStagingDir = ".remote-docker-restore"
+
+// stagedExtractScript returns a script extracting the tar archive on stdin
+// into the directory $1 with the given tar flags. The archive goes to a
+// staging directory first and replaces the contents only once tar
+// succeeded, so a broken or cancelled stream leaves the old contents. Files
+// missing from the archive do not survive.
+func stagedExtractScript(tarFlags string) string {
+	return `set -e; cd "$1"; staging=` + restoreStagingDir + `; ` +
+		`rm -rf "$staging"; mkdir "$staging"; ` +
+		`tar ` + tarFlags + ` - -C "$staging" || { rm -rf "$staging"; exit 1; }; ` +
+		`find . -mindepth 1 -maxdepth 1 ! -name "$staging" -exec rm -rf {} +; ` +
+		`find "$staging" -mindepth 1 -maxdepth 1 -exec mv {} . \;; ` +
+		`rmdir "$staging"`
+}
+
+// cleanupRestoreStaging removes the staging directory a killed restore helper
+// left in an existing volume
+func cleanupRestoreStaging(username, hostname, volume string) {
+	err := streamVolumeHelper(context.Background(), username, hostname, "cleanup", volume, false, nil, io.Discard,
+		"rm", "-rf", "/volume/"+restoreStagingDir)
+	if err != nil {
+		logger.Warnf("Failed to clean up the restore staging directory in %s: %v", volume, err)
+	}
+}
+
+// List stored volume backups, newest first
+func listVolumeBackups(ctx echo.Context) error {
+	var req VolumeBackupListRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	manifests, err := loadVolumeBackups()
+	if err != nil {
+		logger.Errorf("Error listing volume backups: %v", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to list backups: %v", err)})
+	}
+	filtered := []VolumeBackupManifest{}
+	for _, m := range manifests {
+		if (req.VolumeName == "" || m.Volume == req.VolumeName) &&
+			(req.Hostname == "" || m.Hostname == req.Hostname) &&
+			(req.Username == "" || m.Username == req.Username) {
+			filtered = append(filtered, m)
+		}
+	}
+	return ctx.JSON(http.StatusOK, filtered)
+}
+
+// Delete a stored volume backup
+func deleteVolumeBackup(ctx echo.Context) error {
+	var req VolumeBackupRequestById
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.BackupId == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if _, err := readBackupManifest(req.BackupId); err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err := os.RemoveAll(filepath.Join(volumeBackupDir, req.BackupId)); err != nil {
+		logger.Errorf("Error deleting volume backup %s: %v", req.BackupId, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to delete backup: %v", err)})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"success": "Backup deleted"})
+}
+
+// volumeHelperArgs returns the docker run arguments of a throwaway helper
+// container with the volume mounted at /volume. --rm only applies when the
+// command exits on its own, see streamVolumeHelper.
+func volumeHelperArgs(name, volume string, readOnly, interactive bool, command ...string) []string {
+	mount := volume + ":/volume"
+	if readOnly {
+		mount += ":ro"
+	}
+	args := []string{"run", "--rm", "--name", name, "--network", "none", "--label", volumeHelperLabel + "=true", "-v", mount}
+	if interactive {
+		args = append(args, "-i")
+	}
+	return append(append(args, volumeHelperImage), command...)
+}
+
+// streamVolumeHelper runs a command in a named helper container, feeding it
+// stdin if not nil, and force-removes the container afterwards. Cutting the
+// SSH session of a cancelled operation only kills the docker CLI, the
+// container keeps running and --rm never triggers.
+func streamVolumeHelper(ctx context.Context, username, hostname, purpose, volume string, readOnly bool, stdin io.Reader, stdout io.Writer, command ...string) error {
+	name := fmt.Sprintf("remote-docker-volume-%s-%d", purpose, time.Now().UnixNano())
+	defer func() {
+		output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("rm", "-f", name))
+		if err != nil && !strings.Contains(string(output), "No such container") {
+			logger.Warnf("Failed to remove volume helper %s: %v, output: %s", name, err, string(output))
+		}
+	}()
+
+	args := volumeHelperArgs(name, volume, readOnly, stdin != nil, command...)
+	return tunnelManager.StreamCommand(ctx, username, hostname, utils.BuildDockerCommand(args...), stdin, stdout)
+}
+
+// createDockerVolume creates a volume with the given driver, driver options and labels
+func createDockerVolume(username, hostname, name, driver string, options, labels map[string]string) ([]byte, error) {
+	args := []string{"volume", "create"}
+	if driver != "" {
+		args = append(args, "--driver", driver)
+	}
+	for _, key := range sortedKeys(options) {
+		args = append(args, "--opt", key+"="+options[key])
+	}
+	for _, key := range sortedKeys(labels) {
+		args = append(args, "--label", key+"="+labels[key])
+	}
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(append(args, name)...))
+	if err != nil {
+		return output, fmt.Errorf("failed to create volume %s: %v", name, err)
+	}
+	return output, nil
+}
+
+// removeDockerVolume removes a volume created by a failed operation
+func removeDockerVolume(username, hostname, name string) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("volume", "rm", name))
+	if err != nil {
+		logger.Warnf("Failed to remove volume %s after the failed operation: %v, output: %s", name, err, string(output))
+	}
+}
+
+// inspectVolume returns the inspect data of a single volume
+func inspectVolume(username, hostname, name string) (*volumeInspectJSON, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("volume", "inspect", name))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to inspect volume %s: %v", name, err)
+	}
+	var inspected []volumeInspectJSON
+	if err := json.Unmarshal(output, &inspected); err != nil || len(inspected) != 1 {
+		return nil, output, fmt.Errorf("failed to parse volume inspect output: %v", err)
+	}
+	return &inspected[0], output, nil
+}
+
+func writeBackupManifest(dir string, manifest VolumeBackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, volumeBackupManifestName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup manifest: %v", err)
+	}
+	return nil
+}
+
+func readBackupManifest(id string) (*VolumeBackupManifest, error) {
+	if !backupIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid backup ID: %s", id)
+	}
+	data, err := os.ReadFile(filepath.Join(volumeBackupDir, id, volumeBackupManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("backup %s not found", id)
+	}
+	var manifest VolumeBackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("backup %s has an unreadable manifest: %v", id, err)
+	}
+	return &manifest, nil
+}
+
+// loadVolumeBackups reads all backup manifests, newest first. Directories
+// without a manifest are incomplete backups and skipped.
+func loadVolumeBackups() ([]VolumeBackupManifest, error) {
+	entries, err := os.ReadDir(volumeBackupDir)
+	if os.IsNotExist(err) {
+		return []VolumeBackupManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifests := make([]VolumeBackupManifest, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		manifest, err := readBackupManifest(entry.Name())
+		if err != nil {
+			continue
+		}
+		manifests = append(manifests, *manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].CreatedAt > manifests[j].CreatedAt })
+	return manifests, nil
+}
+
+// pruneVolumeBackups deletes all but the newest keep backups of the volume
+// in the environment of the given backup
+func pruneVolumeBackups(latest VolumeBackupManifest, keep int) {
+	manifests, err := loadVolumeBackups()
+	if err != nil {
+		logger.Warnf("Failed to list backups for retention: %v", err)
+		return
+	}
+	for _, id := range expiredVolumeBackups(manifests, latest, keep) {
+		logger.Infof("Deleting volume backup %s past retention", id)
+		if err := os.RemoveAll(filepath.Join(volumeBackupDir, id)); err != nil {
+			logger.Warnf("Failed to delete backup %s: %v", id, err)
+		}
+	}
+}
+
+// expiredVolumeBackups picks the backups of the same volume and environment
+// beyond the newest keep. manifests must be sorted newest first.
+func expiredVolumeBackups(manifests []VolumeBackupManifest, latest VolumeBackupManifest, keep int) []string {
+	var expired []string
+	kept := 0
+	for _, m := range manifests {
+		if m.Volume != latest.Volume || m.Hostname != latest.Hostname || m.Username != latest.Username {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		expired = append(expired, m.ID)
+	}
+	return expired
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// countingReader counts the bytes read through it and reports the total
+// every transferReportInterval bytes
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	reported int64
+	report   func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.report != nil && (c.n-c.reported >= transferReportInterval || err == io.EOF) {
+		c.reported = c.n
+		c.report(c.n)
+	}
+	return n, err
+}
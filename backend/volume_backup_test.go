@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpiredVolumeBackups(t *testing.T) {
+	backup := func(id, volume, hostname string) VolumeBackupManifest {
+		return VolumeBackupManifest{ID: id, Volume: volume, Hostname: hostname, Username: "deploy"}
+	}
+	// Newest first, as loadVolumeBackups returns them
+	manifests := []VolumeBackupManifest{
+		backup("db-4", "db", "prod"),
+		backup("db-3", "db", "prod"),
+		backup("other-host", "db", "staging"),
+		backup("cache-1", "cache", "prod"),
+		backup("db-2", "db", "prod"),
+		backup("db-1", "db", "prod"),
+	}
+
+	if got := expiredVolumeBackups(manifests, manifests[0], 2); !reflect.DeepEqual(got, []string{"db-2", "db-1"}) {
+		t.Errorf("expiredVolumeBackups = %v", got)
+	}
+	if got := expiredVolumeBackups(manifests, manifests[0], 5); got != nil {
+		t.Errorf("expected nothing to expire, got %v", got)
+	}
+}
+
+func TestVolumeHelperArgs(t *testing.T) {
+	got := strings.Join(volumeHelperArgs("remote-docker-volume-restore-1", "data", false, true, "sh", "-c", "true"), " ")
+	want := "run --rm --name remote-docker-volume-restore-1 --network none --label " + volumeHelperLabel + "=true -v data:/volume -i " + volumeHelperImage + " sh -c true"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+// writeTestTar writes a gzipped tar of the files, "" marks a directory
+func writeTestTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedKeys(files) {
+		if files[name] == "" {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755})
+			continue
+		}
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name]))})
+		tw.Write([]byte(files[name]))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// listTree returns the relative paths and contents of the files below dir
+func listTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			tree[rel] = ""
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		tree[rel] = string(data)
+		return err
+	})
+	return tree
+}
+
+func TestStagedExtractScript(t *testing.T) {
+	for _, tool := range []string{"sh", "tar", "find"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	extract := func(dir string, archive []byte) error {
+		cmd := exec.Command("sh", "-c", stagedExtractScript("-xzf"), "sh", dir)
+		cmd.Stdin = bytes.NewReader(archive)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, output)
+		}
+		return nil
+	}
+
+	dir := t.TempDir()
+	old := map[string]string{"deleted-since.txt": "old", "app.conf": "v1", ".hidden": "old", "sub": "", "sub/stale": "old"}
+	for _, name := range sortedKeys(old) {
+		if data := old[name]; data == "" {
+			os.Mkdir(filepath.Join(dir, name), 0755)
+		} else if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A truncated stream leaves the contents untouched
+	archive := writeTestTar(t, map[string]string{"app.conf": "v2", ".env": "KEY=1", "sub": "", "sub/new": "new"})
+	if err := extract(dir, archive[:len(archive)/2]); err == nil {
+		t.Fatal("expected truncated archive to fail")
+	}
+	if got := listTree(t, dir); !reflect.DeepEqual(got, old) {
+		t.Errorf("failed restore changed the volume: %v", got)
+	}
+
+	// A complete archive replaces the contents, including hidden files
+	if err := extract(dir, archive); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app.conf": "v2", ".env": "KEY=1", "sub": "", "sub/new": "new"}
+	if got := listTree(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
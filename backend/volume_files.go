@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+var (
+	// Volume and network driver plugins, e.g. local or vieux/sshfs:latest
+	driverNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:/-]*$`)
+	// Keys of driver options and labels
+	optionKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Request to create a volume
+type VolumeCreateRequest struct {
+	Hostname   string            `json:"hostname"`
+	Username   string            `json:"username"`
+	VolumeName string            `json:"volumeName"`
+	Driver     string            `json:"driver"`     // defaults to local
+	DriverOpts map[string]string `json:"driverOpts"` // e.g. type=nfs, o=addr=10.0.0.5,rw, device=:/export
+	Labels     map[string]string `json:"labels"`
+}
+
+// Request for browsing the files of a volume
+type VolumeFilesRequest struct {
+	Hostname   string `json:"hostname"`
+	Username   string `json:"username"`
+	VolumeName string `json:"volumeName"`
+	Path       string `json:"path"` // absolute, relative to the volume root
+}
+
+// Create a volume
+func createVolume(ctx echo.Context) error {
+	var req VolumeCreateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.Hostname == "" || req.Username == "" || req.VolumeName == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := utils.ValidateVolumeName(req.VolumeName); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid volume name: %v", err)})
+	}
+	if err := validateVolumeCreateOptions(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	// docker volume create succeeds silently for an existing volume
+	if _, _, err := inspectVolume(req.Username, req.Hostname, req.VolumeName); err == nil {
+		return ctx.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Volume %s already exists", req.VolumeName)})
+	}
+
+	output, err := createDockerVolume(req.Username, req.Hostname, req.VolumeName, req.Driver, req.DriverOpts, req.Labels)
+	if err != nil {
+		logger.Errorf("Error creating volume: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to create volume: %v", err),
+			"output": string(output),
+		})
+	}
+
+	volume, output, err := inspectVolume(req.Username, req.Hostname, req.VolumeName)
+	if err != nil {
+		logger.Errorf("Error inspecting created volume: %v, output: %s", err, string(output))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":  fmt.Sprintf("Failed to inspect volume: %v", err),
+			"output": string(output),
+		})
+	}
+	return ctx.JSON(http.StatusOK, volume)
+}
+
+// validateVolumeCreateOptions checks the driver, driver options and labels
+// before they end up as docker volume create flags
+func validateVolumeCreateOptions(req VolumeCreateRequest) error {
+	if req.Driver != "" && !driverNamePattern.MatchString(req.Driver) {
+		return fmt.Errorf("Invalid driver: %s", req.Driver)
+	}
+	if err := validateKeyValues("driver option", req.DriverOpts); err != nil {
+		return err
+	}
+	return validateKeyValues("label", req.Labels)
+}
+
+// validateKeyValues checks key=value pairs passed as --opt or --label flags
+func validateKeyValues(kind string, values map[string]string) error {
+	for key, value := range values {
+		if !optionKeyPattern.MatchString(key) {
+			return fmt.Errorf("Invalid %s key: %q", kind, key)
+		}
+		if strings.ContainsAny(value, "\x00\n\r") {
+			return fmt.Errorf("Invalid %s value for %s", kind, key)
+		}
+	}
+	return nil
+}
+
+// validateVolumeFilesRequest checks the common fields of volume browser
+// requests and returns the cleaned path relative to the volume root
+func validateVolumeFilesRequest(req VolumeFilesRequest) (string, error) {
+	if req.Hostname == "" || req.Username == "" || req.VolumeName == "" {
+		return "", fmt.Errorf("Missing required fields")
+	}
+	if err := validateConnection(req.Username, req.Hostname); err != nil {
+		return "", err
+	}
+	if err := utils.ValidateVolumeName(req.VolumeName); err != nil {
+		return "", fmt.Errorf("Invalid volume name: %v", err)
+	}
+	if req.Path == "" {
+		req.Path = "/"
+	}
+	cleanPath, err := utils.CleanContainerPath(req.Path)
+	if err != nil {
+		return "", fmt.Errorf("Invalid path: %v", err)
+	}
+	return cleanPath, nil
+}
+
+// List a directory inside a volume
+func browseVolume(ctx echo.Context) error {
+	var req VolumeFilesRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	dir, err := validateVolumeFilesRequest(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var out strings.Builder
+	err = runVolumeHelper(ctx.Request().Context(), req.Username, req.Hostname, req.VolumeName, &out,
+		"find", path.Join("/volume", dir), "-mindepth", "1", "-maxdepth", "1", "-exec", "stat", "-c", containerStatFormat, "{}", "+")
+	if err != nil {
+		logger.Errorf("Error listing %s in volume %s: %v", dir, req.VolumeName, err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to list directory: %v", err),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, ContainerFilesResponse{Path: dir, Entries: parseStatOutput(out.String(), dir)})
+}
+
+// Download a single file from a volume
+func downloadVolumeFile(ctx echo.Context) error {
+	var req VolumeFilesRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	file, err := validateVolumeFilesRequest(req)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if file == "/" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Path is a directory"})
+	}
+
+	out := newDeferredResponse(ctx, "application/octet-stream", path.Base(file))
+	err = runVolumeHelper(ctx.Request().Context(), req.Username, req.Hostname, req.VolumeName, utils.LimitWriter(out, utils.MaxDownloadSize),
+		"sh", "-c", `if [ ! -f "$1" ]; then echo "not a regular file: $2" >&2; exit 1; fi; exec cat "$1"`, "sh", path.Join("/volume", file), file)
+	if err != nil {
+		logger.Errorf("Error downloading %s from volume %s: %v", file, req.VolumeName, err)
+		if !out.started {
+			status := http.StatusInternalServerError
+			if errors.Is(err, utils.ErrSizeLimitExceeded) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			return ctx.JSON(status, map[string]string{
+				"error": fmt.Sprintf("Failed to download: %v", err),
+			})
+		}
+		// Headers are already sent, all we can do is cut the stream short
+		return nil
+	}
+
+	out.start()
+	return nil
+}
+
+// runVolumeHelper runs a command in a helper container with the volume
+// mounted read-only
+func runVolumeHelper(ctx context.Context, username, hostname, volume string, stdout io.Writer, command ...string) error {
+	// docker run would silently create a missing volume
+	if _, output, err := inspectVolume(username, hostname, volume); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return streamVolumeHelper(ctx, username, hostname, "browse", volume, true, nil, stdout, command...)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestValidateVolumeCreateOptions(t *testing.T) {
+	valid := VolumeCreateRequest{
+		Driver:     "local",
+		DriverOpts: map[string]string{"type": "nfs", "o": "addr=10.0.0.5,rw", "device": ":/export"},
+		Labels:     map[string]string{"com.example.team": "data"},
+	}
+	if err := validateVolumeCreateOptions(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, req := range []VolumeCreateRequest{
+		{Driver: "local; rm -rf /"},
+		{DriverOpts: map[string]string{"o=x": "y"}},
+		{Labels: map[string]string{"team": "a\nb"}},
+	} {
+		if err := validateVolumeCreateOptions(req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"remote-docker/utils"
+)
+
+// Volume with its size and the containers mounting it
+type VolumeSummary struct {
+	Name       string   `json:"name"`
+	Driver     string   `json:"driver"`
+	Scope      string   `json:"scope"`
+	Mountpoint string   `json:"mountpoint"`
+	Created    string   `json:"created"`
+	Size       string   `json:"size"`      // human readable, N/A when the driver does not report it
+	SizeBytes  int64    `json:"sizeBytes"` // -1 when unknown
+	Labels     []string `json:"labels"`
+	// Number of containers, running or stopped, mounting the volume
+	RefCount   int          `json:"refCount"`
+	Containers []VolumeUser `json:"containers"`
+	Anonymous  bool         `json:"anonymous"`
+	// No container mounts the volume
+	Dangling bool `json:"dangling"`
+	// Dangling and left behind: anonymous, or created by a compose project
+	// that no longer has containers
+	Orphaned bool `json:"orphaned"`
+}
+
+// Container mounting a volume
+type VolumeUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// `docker volume inspect` output, only the listed fields are mapped
+type volumeInspectJSON struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`
+	Scope      string            `json:"Scope"`
+	Mountpoint string            `json:"Mountpoint"`
+	CreatedAt  string            `json:"CreatedAt"`
+	Labels     map[string]string `json:"Labels"`
+	Options    map[string]string `json:"Options"`
+}
+
+// collectVolumes builds the volume list from `docker system df -v`, which
+// reports sizes, one batched `docker volume inspect`, and the mounts of all
+// containers. Three round trips regardless of the number of volumes.
+func collectVolumes(username, hostname string) ([]VolumeSummary, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("system", "df", "-v", "--format", "{{json .}}"))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to read disk usage: %v", err)
+	}
+	sizes, names, err := parseVolumeDiskUsage(output)
+	if err != nil {
+		return nil, output, err
+	}
+	if len(names) == 0 {
+		return []VolumeSummary{}, nil, nil
+	}
+
+	output, err = tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand(append([]string{"volume", "inspect"}, names...)...))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to inspect volumes: %v", err)
+	}
+	var inspected []volumeInspectJSON
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, output, fmt.Errorf("failed to parse volume inspect output: %v", err)
+	}
+
+	usage, output, err := volumeUsers(username, hostname)
+	if err != nil {
+		return nil, output, err
+	}
+	return buildVolumeSummaries(inspected, sizes, usage), nil, nil
+}
+
+// parseVolumeDiskUsage reads the volume sizes from `docker system df -v
+// --format '{{json .}}'` and returns the volume names in listing order
+func parseVolumeDiskUsage(output []byte) (map[string]string, []string, error) {
+	var usage struct {
+		Volumes []struct {
+			Name string `json:"Name"`
+			Size string `json:"Size"`
+		} `json:"Volumes"`
+	}
+	if err := json.Unmarshal(output, &usage); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse disk usage: %v", err)
+	}
+
+	sizes := make(map[string]string, len(usage.Volumes))
+	names := make([]string, 0, len(usage.Volumes))
+	for _, v := range usage.Volumes {
+		if utils.ValidateVolumeName(v.Name) != nil {
+			logger.Warnf("Skipping volume with unexpected name: %q", v.Name)
+			continue
+		}
+		sizes[v.Name] = v.Size
+		names = append(names, v.Name)
+	}
+	return sizes, names, nil
+}
+
+// Containers mounting each volume and the compose projects that have containers
+type volumeUsage struct {
+	users    map[string][]VolumeUser
+	projects map[string]bool
+}
+
+// volumeUsers finds the containers, running or stopped, mounting each volume
+func volumeUsers(username, hostname string) (*volumeUsage, []byte, error) {
+	output, err := tunnelManager.ExecuteCommand(username, hostname, utils.BuildDockerCommand("ps", "-a", "-q", "--no-trunc"))
+	if err != nil {
+		return nil, output, fmt.Errorf("failed to list containers: %v", err)
+	}
+	containers, output, err := inspectContainers(username, hostname, strings.Fields(string(output))...)
+	if err != nil {
+		return nil, output, err
+	}
+
+	usage := &volumeUsage{users: make(map[string][]VolumeUser), projects: make(map[string]bool)}
+	for _, c := range containers {
+		if project := c.Config.Labels[composeProjectLabel]; project != "" {
+			usage.projects[project] = true
+		}
+		for _, m := range c.Mounts {
+			if m.Type != "volume" {
+				continue
+			}
+			usage.users[m.Name] = append(usage.users[m.Name], VolumeUser{
+				ID:          shortContainerID(c.ID),
+				Name:        strings.TrimPrefix(c.Name, "/"),
+				State:       c.State.Status,
+				Destination: m.Destination,
+			})
+		}
+	}
+	return usage, nil, nil
+}
+
+func buildVolumeSummaries(inspected []volumeInspectJSON, sizes map[string]string, usage *volumeUsage) []VolumeSummary {
+	volumes := make([]VolumeSummary, 0, len(inspected))
+	for _, v := range inspected {
+		summary := VolumeSummary{
+			Name:       v.Name,
+			Driver:     v.Driver,
+			Scope:      v.Scope,
+			Mountpoint: v.Mountpoint,
+			Created:    v.CreatedAt,
+			Size:       sizes[v.Name],
+			SizeBytes:  parseDockerSize(sizes[v.Name]),
+			Labels:     []string{},
+			Containers: usage.users[v.Name],
+			Anonymous:  anonymousVolumePattern.MatchString(v.Name),
+		}
+		if summary.SizeBytes < 0 {
+			summary.Size = "N/A"
+		}
+		for _, key := range sortedKeys(v.Labels) {
+			summary.Labels = append(summary.Labels, key+"="+v.Labels[key])
+		}
+		if summary.Containers == nil {
+			summary.Containers = []VolumeUser{}
+		}
+		sort.Slice(summary.Containers, func(i, j int) bool { return summary.Containers[i].Name < summary.Containers[j].Name })
+
+		summary.RefCount = len(summary.Containers)
+		summary.Dangling = summary.RefCount == 0
+		project := v.Labels[composeProjectLabel]
+		summary.Orphaned = summary.Dangling && (summary.Anonymous || (project != "" && !usage.projects[project]))
+		volumes = append(volumes, summary)
+	}
+	return volumes
+}
+
+// parseDockerSize parses sizes as docker prints them, e.g. 12.5MB, with
+// decimal units. Returns -1 for N/A and unparsable values.
+func parseDockerSize(size string) int64 {
+	size = strings.TrimSpace(size)
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"KB", 1e3}, {"B", 1},
+	}
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(size, unit.suffix); ok {
+			value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil || value < 0 {
+				return -1
+			}
+			return int64(math.Round(value * unit.multiplier))
+		}
+	}
+	return -1
+}
+
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// describeVolumeUsers lists the containers mounting a volume for error messages
+func describeVolumeUsers(users []VolumeUser) string {
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, fmt.Sprintf("%s (%s)", u.Name, u.State))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildVolumeSummaries(t *testing.T) {
+	anonymous := strings.Repeat("f", 64)
+	sizes, names, err := parseVolumeDiskUsage([]byte(`{"Images":[],"Volumes":[
+		{"Name":"db","Size":"1.5GB","Links":"1"},
+		{"Name":"` + anonymous + `","Size":"0B","Links":"0"},
+		{"Name":"old_cache","Size":"12kB","Links":"0"},
+		{"Name":"nfs","Size":"N/A","Links":"0"}]}`))
+	if err != nil || len(names) != 4 {
+		t.Fatalf("parseVolumeDiskUsage = %v, %v", names, err)
+	}
+
+	inspected := []volumeInspectJSON{
+		{Name: "db", Labels: map[string]string{composeProjectLabel: "shop"}},
+		{Name: anonymous},
+		{Name: "old_cache", Labels: map[string]string{composeProjectLabel: "old"}},
+		{Name: "nfs"},
+	}
+	usage := &volumeUsage{
+		users:    map[string][]VolumeUser{"db": {{ID: "abc", Name: "shop-db-1", State: "exited"}}},
+		projects: map[string]bool{"shop": true},
+	}
+	volumes := buildVolumeSummaries(inspected, sizes, usage)
+
+	db, anon, cache, nfs := volumes[0], volumes[1], volumes[2], volumes[3]
+	if db.SizeBytes != 1500000000 || db.RefCount != 1 || db.Dangling || db.Orphaned || db.Labels[0] != composeProjectLabel+"=shop" {
+		t.Errorf("unexpected db volume: %+v", db)
+	}
+	if !anon.Anonymous || !anon.Dangling || !anon.Orphaned || anon.SizeBytes != 0 {
+		t.Errorf("unexpected anonymous volume: %+v", anon)
+	}
+	if !cache.Orphaned || cache.SizeBytes != 12000 {
+		t.Errorf("unexpected cache volume: %+v", cache)
+	}
+	if !nfs.Dangling || nfs.Orphaned || nfs.SizeBytes != -1 || nfs.Size != "N/A" {
+		t.Errorf("unexpected nfs volume: %+v", nfs)
+	}
+}
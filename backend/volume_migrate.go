@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"remote-docker/utils"
+)
+
+// What to do when the target volume already exists on the destination
+const (
+	collisionAbort     = "abort"
+	collisionOverwrite = "overwrite"
+	collisionRename    = "rename"
+)
+
+// Checksum over the relative path and contents of every file in /volume,
+// independent of file order and archive format
+const volumeChecksumScript = `cd /volume && find . -type f -exec sha256sum {} + | sort -k 2 | sha256sum | cut -d ' ' -f 1`
+
+// Request to copy a volume from one saved environment to another
+type VolumeMigrateRequest struct {
+	SourceEnvironmentId      string `json:"sourceEnvironmentId"`
+	DestinationEnvironmentId string `json:"destinationEnvironmentId"`
+	VolumeName               string `json:"volumeName"`
+	TargetName               string `json:"targetName"` // defaults to the source name
+	Compress                 bool   `json:"compress"`
+	OnCollision              string `json:"onCollision"` // abort (default), overwrite or rename
+}
+
+// Result of a migration
+type VolumeMigrateResult struct {
+	Source       string `json:"source"`
+	Destination  string `json:"destination"`
+	Volume       string `json:"volume"`
+	TargetVolume string `json:"targetVolume"`
+	Created      bool   `json:"created"`
+	Bytes        int64  `json:"bytes"`    // size of the stream, compressed if requested
+	Checksum     string `json:"checksum"` // content checksum, equal on both sides
+}
+
+// Start copying a volume between environments
+func migrateVolume(ctx echo.Context) error {
+	var req VolumeMigrateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+	}
+
+	if req.SourceEnvironmentId == "" || req.DestinationEnvironmentId == "" || req.VolumeName == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	}
+	if req.TargetName == "" {
+		req.TargetName = req.VolumeName
+	}
+	for _, name := range []string{req.VolumeName, req.TargetName} {
+		if err := utils.ValidateVolumeName(name); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid volume name: %v", err)})
+		}
+	}
+	switch req.OnCollision {
+	case "":
+		req.OnCollision = collisionAbort
+	case collisionAbort, collisionOverwrite, collisionRename:
+	default:
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown collision policy: %s", req.OnCollision)})
+	}
+
+	source, err := findEnvironment(req.SourceEnvironmentId)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	destination, err := findEnvironment(req.DestinationEnvironmentId)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if connectionKey(source.Username, source.Hostname) == connectionKey(destination.Username, destination.Hostname) && req.VolumeName == req.TargetName {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Source and destination must differ"})
+	}
+
+	target := fmt.Sprintf("%s (%s → %s)", req.VolumeName, source.Name, destination.Name)
+	op := operations.start("volume-migrate", destination.Username, destination.Hostname, target, func(opCtx context.Context, update func(interface{})) (interface{}, error) {
+		return runVolumeMigration(opCtx, req, *source, *destination, update)
+	})
+	return ctx.JSON(http.StatusAccepted, op)
+}
+
+func runVolumeMigration(ctx context.Context, req VolumeMigrateRequest, source, destination Environment, update func(interface{})) (interface{}, error) {
+	update(VolumeTransferProgress{Phase: "preparing"})
+	volume, output, err := inspectVolume(source.Username, source.Hostname, req.VolumeName)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	// The progress counts the stream, which is no longer comparable to the
+	// volume size once compressed
+	var total int64
+	if !req.Compress {
+		if output, err := tunnelManager.ExecuteCommand(source.Username, source.Hostname, utils.BuildDockerCommand("system", "df", "-v", "--format", "{{json .}}")); err == nil {
+			if sizes, _, err := parseVolumeDiskUsage(output); err == nil {
+				total = max(parseDockerSize(sizes[req.VolumeName]), 0)
+			}
+		}
+	}
+
+	output, err = tunnelManager.ExecuteCommand(destination.Username, destination.Hostname, utils.BuildDockerCommand("volume", "ls", "-q"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes on %s: %v: %s", destination.Name, err, strings.TrimSpace(string(output)))
+	}
+	existing := make(map[string]bool)
+	for _, name := range strings.Fields(string(output)) {
+		existing[name] = true
+	}
+	target, overwrite, err := resolveMigrationTarget(req.OnCollision, req.TargetName, existing)
+	if err != nil {
+		return nil, fmt.Errorf("%v on %s", err, destination.Name)
+	}
+	result := VolumeMigrateResult{
+		Source:       source.Name,
+		Destination:  destination.Name,
+		Volume:       req.VolumeName,
+		TargetVolume: target,
+	}
+
+	if overwrite {
+		usage, output, err := volumeUsers(destination.Username, destination.Hostname)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		for _, u := range usage.users[target] {
+			if u.State == "running" || u.State == "paused" {
+				return nil, fmt.Errorf("volume %s on %s is in use by %s, stop them first", target, destination.Name, describeVolumeUsers(usage.users[target]))
+			}
+		}
+	} else {
+		update(VolumeTransferProgress{Phase: "creating", Total: total})
+		if output, err := createDockerVolume(destination.Username, destination.Hostname, target, volume.Driver, volume.Options, volume.Labels); err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		result.Created = true
+	}
+	// A volume created for the copy is removed again when the copy fails
+	fail := func(err error) (interface{}, error) {
+		if result.Created {
+			removeDockerVolume(destination.Username, destination.Hostname, target)
+		}
+		return nil, err
+	}
+
+	update(VolumeTransferProgress{Phase: "copying", Total: total})
+	bytes, err := streamVolume(ctx, req, source, destination, target, overwrite, func(n int64) {
+		update(VolumeTransferProgress{Phase: "copying", Bytes: n, Total: total})
+	})
+	result.Bytes = bytes
+	if err != nil {
+		return fail(err)
+	}
+
+	update(VolumeTransferProgress{Phase: "verifying", Bytes: bytes, Total: total})
+	sourceSum, err := volumeChecksum(ctx, source, req.VolumeName)
+	if err != nil {
+		return fail(fmt.Errorf("failed to checksum source volume: %v", err))
+	}
+	destinationSum, err := volumeChecksum(ctx, destination, target)
+	if err != nil {
+		return fail(fmt.Errorf("failed to checksum destination volume: %v", err))
+	}
+	if sourceSum != destinationSum {
+		return fail(fmt.Errorf("verification failed: contents of %s on %s differ from the source, it may have changed during the copy", target, destination.Name))
+	}
+	result.Checksum = sourceSum
+	return result, nil
+}
+
+// resolveMigrationTarget applies the collision policy to the volumes existing
+// on the destination. It returns the volume to copy into and whether that
+// volume exists and gets overwritten.
+func resolveMigrationTarget(onCollision, target string, existing map[string]bool) (string, bool, error) {
+	if !existing[target] {
+		return target, false, nil
+	}
+	switch onCollision {
+	case collisionRename:
+		return nextFreeVolumeName(target, existing), false, nil
+	case collisionOverwrite:
+		return target, true, nil
+	default:
+		return "", false, fmt.Errorf("volume %s already exists", target)
+	}
+}
+
+// nextFreeVolumeName appends the first free numeric suffix, e.g. data-2
+func nextFreeVolumeName(name string, existing map[string]bool) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if !existing[candidate] {
+			return candidate
+		}
+	}
+}
+
+// migrationCommands returns the helper commands that pack the source volume
+// and unpack the stream into the target volume
+func migrationCommands(compress, overwrite bool) (pack, unpack []string) {
+	packScript := "tar -cf - -C /volume ."
+	tarFlags := "-xf"
+	if compress {
+		// Without pipefail a failing tar would go unnoticed behind gzip
+		packScript = "set -o pipefail; " + packScript + " | gzip -1"
+		tarFlags = "-xzf"
+	}
+	pack = []string{"sh", "-c", packScript}
+	if overwrite {
+		// Files missing on the source must not survive, and a failed copy
+		// must not leave the volume emptied
+		return pack, []string{"sh", "-c", stagedExtractScript(tarFlags), "sh", "/volume"}
+	}
+	return pack, []string{"tar", tarFlags, "-", "-C", "/volume"}
+}
+
+// streamVolume pipes a tar of the source volume from a read-only helper
+// container into a helper container extracting it on the destination
+func streamVolume(ctx context.Context, req VolumeMigrateRequest, source, destination Environment, target string, overwrite bool, report func(int64)) (int64, error) {
+	pack, unpack := migrationCommands(req.Compress, overwrite)
+	var sent int64
+	sourceErr, destinationErr := pipeStreams(ctx,
+		func(ctx context.Context, w io.Writer) error {
+			counter := &countingWriter{w: w, report: report}
+			defer func() { sent = atomic.LoadInt64(&counter.n) }()
+			return streamVolumeHelper(ctx, source.Username, source.Hostname, "migrate", req.VolumeName, true, nil, counter, pack...)
+		},
+		func(ctx context.Context, r io.Reader) error {
+			return streamVolumeHelper(ctx, destination.Username, destination.Hostname, "migrate", target, false, r, io.Discard, unpack...)
+		})
+
+	switch {
+	case sourceErr != nil:
+		return sent, fmt.Errorf("failed to read volume on %s: %v", source.Name, sourceErr)
+	case destinationErr != nil:
+		return sent, fmt.Errorf("failed to write volume on %s: %v", destination.Name, destinationErr)
+	}
+	report(sent)
+	return sent, nil
+}
+
+// volumeChecksum computes the content checksum of a volume in a read-only helper container
+func volumeChecksum(ctx context.Context, env Environment, volume string) (string, error) {
+	var out strings.Builder
+	if err := streamVolumeHelper(ctx, env.Username, env.Hostname, "checksum", volume, true, nil, &out, "sh", "-c", volumeChecksumScript); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNextFreeVolumeName(t *testing.T) {
+	existing := map[string]bool{"data": true, "data-2": true, "data-3": true}
+	if got := nextFreeVolumeName("data", existing); got != "data-4" {
+		t.Errorf("nextFreeVolumeName = %q, want data-4", got)
+	}
+	if got := nextFreeVolumeName("cache", existing); got != "cache-2" {
+		t.Errorf("nextFreeVolumeName = %q, want cache-2", got)
+	}
+}
+
+func TestResolveMigrationTarget(t *testing.T) {
+	existing := map[string]bool{"data": true, "data-2": true}
+	tests := []struct {
+		policy    string
+		target    string
+		want      string
+		overwrite bool
+		wantErr   bool
+	}{
+		{collisionAbort, "fresh", "fresh", false, false},
+		{collisionOverwrite, "fresh", "fresh", false, false},
+		{collisionRename, "fresh", "fresh", false, false},
+		{collisionAbort, "data", "", false, true},
+		{collisionOverwrite, "data", "data", true, false},
+		{collisionRename, "data", "data-3", false, false},
+	}
+	for _, tt := range tests {
+		got, overwrite, err := resolveMigrationTarget(tt.policy, tt.target, existing)
+		if got != tt.want || overwrite != tt.overwrite || (err != nil) != tt.wantErr {
+			t.Errorf("%s %s: got %q, overwrite %v, err %v", tt.policy, tt.target, got, overwrite, err)
+		}
+	}
+}
+
+func TestMigrationCommands(t *testing.T) {
+	tests := []struct {
+		compress, overwrite bool
+		pack                string
+		unpack              []string
+	}{
+		{false, false, "tar -cf - -C /volume .", []string{"tar", "-xf", "-", "-C", "/volume"}},
+		{true, false, "set -o pipefail; tar -cf - -C /volume . | gzip -1", []string{"tar", "-xzf", "-", "-C", "/volume"}},
+		{false, true, "tar -cf - -C /volume .", []string{"sh", "-c", stagedExtractScript("-xf"), "sh", "/volume"}},
+		{true, true, "set -o pipefail; tar -cf - -C /volume . | gzip -1", []string{"sh", "-c", stagedExtractScript("-xzf"), "sh", "/volume"}},
+	}
+	for _, tt := range tests {
+		pack, unpack := migrationCommands(tt.compress, tt.overwrite)
+		if !reflect.DeepEqual(pack, []string{"sh", "-c", tt.pack}) {
+			t.Errorf("compress %v, overwrite %v: pack = %q", tt.compress, tt.overwrite, pack)
+		}
+		if !reflect.DeepEqual(unpack, tt.unpack) {
+			t.Errorf("compress %v, overwrite %v: unpack = %q", tt.compress, tt.overwrite, unpack)
+		}
+	}
+	// Overwriting extracts next to the old contents, never clears them up front
+	if _, unpack := migrationCommands(false, true); strings.Contains(strings.Join(unpack, " "), "-delete") {
+		t.Errorf("overwrite clears the volume before extracting: %q", unpack)
+	}
+}